@@ -0,0 +1,133 @@
+// phabrik-dump prints framed Service Fabric messages from a file or stdin
+// as annotated byte trees, useful to reverse-engineer captured traffic
+//
+// usage: phabrik-dump [file]
+//
+// exit code 0 on success, 1 on usage or io error, 2 on malformed or truncated input
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tg123/phabrik/serialization"
+	"github.com/tg123/phabrik/transport"
+)
+
+const (
+	exitOK        = 0
+	exitError     = 1
+	exitMalformed = 2
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	r := stdin
+
+	switch len(args) {
+	case 0:
+	case 1:
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				fmt.Fprintln(stderr, err)
+				return exitError
+			}
+			defer f.Close()
+
+			r = f
+		}
+	default:
+		fmt.Fprintln(stderr, "usage: phabrik-dump [file]")
+		return exitError
+	}
+
+	code := exitOK
+	br := bufio.NewReader(r)
+
+	for i := 0; ; i++ {
+		msg, err := transport.ReadMessage(br)
+		if err == io.EOF {
+			return code
+		}
+
+		if err == io.ErrUnexpectedEOF {
+			fmt.Fprintf(stderr, "frame %v: truncated input\n", i)
+			return exitMalformed
+		}
+
+		if err != nil {
+			fmt.Fprintf(stderr, "frame %v: %v\n", i, err)
+			return exitMalformed
+		}
+
+		if err := dumpMessage(stdout, i, msg); err != nil {
+			fmt.Fprintf(stderr, "frame %v: %v\n", i, err)
+			code = exitMalformed
+		}
+	}
+}
+
+func dumpBytes(w io.Writer, indent string, data []byte) error {
+	s, err := serialization.Dump(data)
+
+	for _, line := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+		if line != "" {
+			fmt.Fprintf(w, "%v%v\n", indent, line)
+		}
+	}
+
+	return err
+}
+
+func dumpMessage(w io.Writer, i int, msg *transport.ByteArrayMessage) error {
+	h := &msg.Headers
+
+	fmt.Fprintf(w, "frame %v\n", i)
+	fmt.Fprintf(w, "  Id: %v\n", h.Id)
+
+	if !h.RelatesTo.IsEmpty() {
+		fmt.Fprintf(w, "  RelatesTo: %v\n", h.RelatesTo)
+	}
+
+	fmt.Fprintf(w, "  Actor: %v\n", h.Actor)
+	fmt.Fprintf(w, "  Action: %v\n", h.Action)
+	fmt.Fprintf(w, "  ExpectsReply: %v HighPriority: %v Idempotent: %v\n", h.ExpectsReply, h.HighPriority, h.Idempotent)
+
+	if h.ErrorCode != transport.FabricErrorCodeSuccess || h.HasFaultBody {
+		fmt.Fprintf(w, "  ErrorCode: %v HasFaultBody: %v\n", h.ErrorCode, h.HasFaultBody)
+	}
+
+	if h.RetryCount > 0 {
+		fmt.Fprintf(w, "  RetryCount: %v\n", h.RetryCount)
+	}
+
+	var lasterr error
+
+	for _, id := range h.CustomHeaderIds() {
+		for _, v := range h.GetCustomHeaders(id) {
+			fmt.Fprintf(w, "  Header %v:\n", id)
+
+			if b, ok := v.([]byte); ok {
+				if err := dumpBytes(w, "    ", b); err != nil {
+					lasterr = fmt.Errorf("header %v: %v", id, err)
+				}
+			} else {
+				fmt.Fprintf(w, "    %+v\n", v)
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "  Body: %v bytes\n", len(msg.Body))
+	if err := dumpBytes(w, "    ", msg.Body); err != nil {
+		lasterr = fmt.Errorf("body: %v", err)
+	}
+
+	return lasterr
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// GenericTestActor message TEST with body struct{ A string; B bool }{"a", true}
+const testFrame = "5d000000000040000000000003800e00000d000000000000001f4c482f3f01801000000f000000000000001f098480002f3f028016000015000000000000001f8d0454004500530054002f3f0010000000000000001f8d016100422f3f"
+
+func TestRun(t *testing.T) {
+	frame, err := hex.DecodeString(testFrame)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("frames", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run(nil, bytes.NewReader(append(frame, frame...)), &stdout, &stderr)
+
+		assert.Equal(t, exitOK, code)
+		assert.Empty(t, stderr.String())
+		assert.Contains(t, stdout.String(), "frame 1\n")
+		assert.Contains(t, stdout.String(), "  Action: TEST\n")
+		assert.Contains(t, stdout.String(), `    000a     WString|Array len=1 "a"`+"\n")
+		assert.Contains(t, stdout.String(), "    000e     Bool true\n")
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"-"}, bytes.NewReader(frame[:50]), &stdout, &stderr)
+
+		assert.Equal(t, exitMalformed, code)
+		assert.Equal(t, "frame 0: truncated input\n", stderr.String())
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"a", "b"}, strings.NewReader(""), &stdout, &stderr)
+
+		assert.Equal(t, exitError, code)
+	})
+}
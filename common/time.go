@@ -3,6 +3,8 @@ package common
 import (
 	"math"
 	"time"
+
+	"github.com/tg123/phabrik/serialization"
 )
 
 type StopwatchTime int64
@@ -22,3 +24,14 @@ func TimeSpanFromDuration(d time.Duration) TimeSpan {
 func (s TimeSpan) ToDuration() time.Duration {
 	return time.Duration(s) * 100
 }
+
+// DateTimeFromTime converts t to DateTime, which is a windows filetime in 100ns ticks since 1601-01-01
+// times before 1601, e.g. zero time.Time, are 0 and times beyond the DateTime range are max
+func DateTimeFromTime(t time.Time) DateTime {
+	ticks, _ := serialization.TicksFromTime(t)
+	return DateTime(ticks)
+}
+
+func (d DateTime) ToTime() time.Time {
+	return serialization.TimeFromTicks(int64(d))
+}
@@ -204,8 +204,9 @@ type Session interface {
 func PingLoop(ctx context.Context, sess Session, interval time.Duration) error {
 
 	for {
-		ctx0, _ := context.WithTimeout(ctx, interval)
+		ctx0, cancel := context.WithTimeout(ctx, interval)
 		sess.Ping(ctx0) // TODO ignore error atm, introduce arbitrator later
+		cancel()
 
 		select {
 		case <-ctx.Done():
@@ -0,0 +1,171 @@
+package naming
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/tg123/phabrik/common"
+	"github.com/tg123/phabrik/serialization"
+	"github.com/tg123/phabrik/transport"
+)
+
+type HealthEntityKind int64
+
+const (
+	HealthEntityKindInvalid                = 0x0
+	HealthEntityKindNode                   = 0x1
+	HealthEntityKindPartition              = 0x2
+	HealthEntityKindService                = 0x3
+	HealthEntityKindApplication            = 0x4
+	HealthEntityKindReplica                = 0x5
+	HealthEntityKindDeployedApplication    = 0x6
+	HealthEntityKindDeployedServicePackage = 0x7
+	HealthEntityKindCluster                = 0x8
+)
+
+// EntityHealthInformation identifies the entity a health report is about
+// only the identifiers matching Kind are used
+type EntityHealthInformation struct {
+	Kind           HealthEntityKind
+	EntityName     string
+	PartitionId    serialization.GUID
+	ReplicaId      int64
+	EntityInstance int64
+}
+
+type HealthInformation struct {
+	SourceId          string
+	Property          string
+	State             FabricHealthState
+	TimeToLive        common.TimeSpan
+	Description       string
+	SequenceNumber    int64
+	RemoveWhenExpired bool
+}
+
+type HealthReport struct {
+	EntityInformation  EntityHealthInformation
+	HealthInformation  HealthInformation
+	SourceUtcTimestamp common.DateTime
+}
+
+type ReportHealthRequestBody struct {
+	Reports []HealthReport
+}
+
+type HealthReportResult struct {
+	Kind           HealthEntityKind
+	EntityName     string
+	SourceId       string
+	SequenceNumber int64
+	Error          int32
+}
+
+type ReportHealthReplyBody struct {
+	Results []HealthReportResult
+}
+
+func (e *EntityHealthInformation) validate() error {
+	switch e.Kind {
+	case HealthEntityKindNode, HealthEntityKindService, HealthEntityKindApplication,
+		HealthEntityKindDeployedApplication, HealthEntityKindDeployedServicePackage:
+		if e.EntityName == "" {
+			return fmt.Errorf("health entity kind %v requires EntityName", e.Kind)
+		}
+	case HealthEntityKindPartition, HealthEntityKindReplica:
+		if e.PartitionId.IsEmpty() {
+			return fmt.Errorf("health entity kind %v requires PartitionId", e.Kind)
+		}
+	case HealthEntityKindCluster:
+	default:
+		return fmt.Errorf("invalid health entity kind %v", e.Kind)
+	}
+
+	return nil
+}
+
+func (h *HealthInformation) validate() error {
+	if h.SourceId == "" {
+		return fmt.Errorf("health SourceId must not be empty")
+	}
+
+	if h.Property == "" {
+		return fmt.Errorf("health Property must not be empty")
+	}
+
+	switch h.State {
+	case FabricHealthStateOK, FabricHealthStateWarning, FabricHealthStateError:
+	default:
+		return fmt.Errorf("invalid health state %v", h.State)
+	}
+
+	if h.TimeToLive <= 0 {
+		return fmt.Errorf("health TimeToLive must > 0, use common.TimeSpanMax for infinite")
+	}
+
+	if h.SequenceNumber < 0 {
+		return fmt.Errorf("health SequenceNumber must >= 0")
+	}
+
+	return nil
+}
+
+// ReportHealth sends health reports to the health manager
+// zero SequenceNumber will be generated from current time
+func (n *NamingClient) ReportHealth(ctx context.Context, reports ...HealthReport) error {
+	if len(reports) == 0 {
+		return fmt.Errorf("no health report to send")
+	}
+
+	now := time.Now()
+	body := &ReportHealthRequestBody{
+		Reports: make([]HealthReport, len(reports)),
+	}
+
+	for i, r := range reports {
+		if err := r.EntityInformation.validate(); err != nil {
+			return err
+		}
+
+		if err := r.HealthInformation.validate(); err != nil {
+			return err
+		}
+
+		if r.HealthInformation.SequenceNumber == 0 {
+			r.HealthInformation.SequenceNumber = now.UnixNano()/100 + int64(i)
+		}
+
+		if r.SourceUtcTimestamp == 0 {
+			r.SourceUtcTimestamp = common.DateTimeFromTime(now)
+		}
+
+		body.Reports[i] = r
+	}
+
+	msg, err := NewNamingMessage("ReportHealth")
+	if err != nil {
+		return err
+	}
+
+	msg.Headers.Actor = transport.MessageActorTypeHM
+	msg.Body = body
+
+	reply, err := n.requestReply(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	var b ReportHealthReplyBody
+	if err := serialization.Unmarshal(reply.Body, &b); err != nil {
+		return err
+	}
+
+	for _, r := range b.Results {
+		if r.Error != 0 {
+			return fmt.Errorf("ReportHealth %v from %v returns HResult %v", r.EntityName, r.SourceId, r.Error)
+		}
+	}
+
+	return nil
+}
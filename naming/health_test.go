@@ -0,0 +1,163 @@
+package naming
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tg123/phabrik/common"
+	"github.com/tg123/phabrik/serialization"
+	"github.com/tg123/phabrik/transport"
+)
+
+func mustLoopbackNamingClient(t *testing.T, cb transport.MessageCallback) *NamingClient {
+	server, err := transport.ListenTCP("127.0.0.1:0", transport.ServerConfig{
+		MessageCallback: cb,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	go server.Serve()
+
+	c, err := transport.DialTCP(server.Addr().String(), transport.ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	go c.Wait()
+
+	n, err := NewNamingClient(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+func replyTo(t *testing.T, c transport.Conn, bam *transport.ByteArrayMessage, action string, body interface{}) {
+	msg, err := NewNamingMessage(action)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	msg.Headers.RelatesTo = bam.Headers.Id
+	msg.Body = body
+
+	if err := c.SendOneWay(msg); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHealthInformationLayout(t *testing.T) {
+	info := HealthInformation{
+		SourceId:          "s",
+		Property:          "p",
+		State:             FabricHealthStateOK,
+		TimeToLive:        10,
+		SequenceNumber:    1,
+		RemoveWhenExpired: true,
+	}
+
+	data, err := serialization.Marshal(&info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []byte{
+		0x00,                                           // object
+		0x1b, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // object header
+		0x1f,                   // scope begin
+		0x8d, 0x01, 0x73, 0x00, // SourceId
+		0x8d, 0x01, 0x70, 0x00, // Property
+		0x09, 0x01, // State
+		0x09, 0x0a, // TimeToLive
+		0xcd,       // Description, empty
+		0x09, 0x01, // SequenceNumber
+		0x42,       // RemoveWhenExpired
+		0x2f, 0x3f, // scope end, object end
+	}, data)
+
+	var info2 HealthInformation
+	if err := serialization.Unmarshal(data, &info2); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, info, info2)
+}
+
+func TestReportHealth(t *testing.T) {
+	reports := make(chan ReportHealthRequestBody, 1)
+
+	n := mustLoopbackNamingClient(t, func(c transport.Conn, bam *transport.ByteArrayMessage) {
+		if bam.Headers.Actor != transport.MessageActorTypeHM || bam.Headers.Action != "ReportHealth" {
+			return
+		}
+
+		var b ReportHealthRequestBody
+		if err := serialization.Unmarshal(bam.Body, &b); err != nil {
+			t.Error(err)
+			return
+		}
+
+		reports <- b
+
+		replyTo(t, c, bam, "ReportHealthReply", &ReportHealthReplyBody{
+			Results: []HealthReportResult{
+				{
+					Kind:           b.Reports[0].EntityInformation.Kind,
+					EntityName:     b.Reports[0].EntityInformation.EntityName,
+					SourceId:       b.Reports[0].HealthInformation.SourceId,
+					SequenceNumber: b.Reports[0].HealthInformation.SequenceNumber,
+				},
+			},
+		})
+	})
+
+	report := HealthReport{
+		EntityInformation: EntityHealthInformation{
+			Kind:       HealthEntityKindNode,
+			EntityName: "_Node_0",
+		},
+		HealthInformation: HealthInformation{
+			SourceId:    "phabrik",
+			Property:    "Connectivity",
+			State:       FabricHealthStateWarning,
+			TimeToLive:  common.TimeSpanFromDuration(time.Minute),
+			Description: "flaky",
+		},
+	}
+
+	if err := n.ReportHealth(context.Background(), report); err != nil {
+		t.Fatal(err)
+	}
+
+	b := <-reports
+	assert.Len(t, b.Reports, 1)
+	assert.Equal(t, report.EntityInformation, b.Reports[0].EntityInformation)
+	assert.Equal(t, "Connectivity", b.Reports[0].HealthInformation.Property)
+	assert.Greater(t, b.Reports[0].HealthInformation.SequenceNumber, int64(0))
+	assert.NotZero(t, b.Reports[0].SourceUtcTimestamp)
+
+	t.Run("validation", func(t *testing.T) {
+		bad := report
+		bad.HealthInformation.TimeToLive = 0
+		assert.Error(t, n.ReportHealth(context.Background(), bad))
+
+		bad = report
+		bad.HealthInformation.SourceId = ""
+		assert.Error(t, n.ReportHealth(context.Background(), bad))
+
+		bad = report
+		bad.EntityInformation.EntityName = ""
+		assert.Error(t, n.ReportHealth(context.Background(), bad))
+
+		bad = report
+		bad.EntityInformation.Kind = HealthEntityKindPartition
+		assert.Error(t, n.ReportHealth(context.Background(), bad))
+
+		assert.Error(t, n.ReportHealth(context.Background()))
+	})
+}
@@ -0,0 +1,135 @@
+package naming
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/tg123/phabrik/serialization"
+)
+
+const queryArgumentContinuationToken = "ContinuationToken"
+
+// PagingStatus carries the continuation token of a paged query result
+// empty token means no more pages
+type PagingStatus struct {
+	ContinuationToken string
+}
+
+// queryReplyType returns reply body type
+// struct { ResultKind; ResultList *struct { List []elem }; ErrorCode int32; PagingStatus *PagingStatus }
+func queryReplyType(elem reflect.Type) reflect.Type {
+	return reflect.StructOf([]reflect.StructField{
+		{
+			Name: "ResultKind",
+			Type: reflect.TypeOf(FabriQueryResultKindInvalid),
+		},
+		{
+			Name: "ResultList",
+			Type: reflect.PtrTo(reflect.StructOf([]reflect.StructField{
+				{
+					Name: "List",
+					Type: reflect.SliceOf(elem),
+				},
+			})),
+		},
+		{
+			Name: "ErrorCode",
+			Type: reflect.TypeOf(int32(0)),
+		},
+		{
+			Name: "PagingStatus",
+			Type: reflect.TypeOf(&PagingStatus{}),
+		},
+	})
+}
+
+// withContinuationToken returns a copy of r for the page of token, r is not changed
+func (r *QueryRequest) withContinuationToken(token string) *QueryRequest {
+	page := &QueryRequest{
+		QueryName: r.QueryName,
+	}
+
+	page.QueryArgs.QueryArgumentMap = make(map[QueryArgumentMapKey]string, len(r.QueryArgs.QueryArgumentMap)+1)
+	for k, v := range r.QueryArgs.QueryArgumentMap {
+		page.QueryArgs.QueryArgumentMap[k] = v
+	}
+
+	if token != "" {
+		page.QueryArgs.QueryArgumentMap[QueryArgumentMapKey{queryArgumentContinuationToken}] = token
+	}
+
+	return page
+}
+
+// ListAll runs a paged query, passing the continuation token of each reply into the next request until exhausted
+// elem is a prototype of the result item, e.g. ApplicationQueryResult{}, fn is called with each item of that type
+// pages without items are skipped, iteration stops at the first error from fn or ctx
+// req is not changed, a continuation token already in it is the first page requested
+func (n *NamingClient) ListAll(ctx context.Context, req *QueryRequest, elem interface{}, fn func(item interface{}) error) error {
+	if elem == nil {
+		return fmt.Errorf("query result prototype must not be nil")
+	}
+
+	replyTyp := queryReplyType(reflect.TypeOf(elem))
+
+	// resumes from a continuation token set by the caller
+	token := req.QueryArgs.QueryArgumentMap[QueryArgumentMapKey{queryArgumentContinuationToken}]
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		msg, err := NewNamingMessage("QueryRequest")
+		if err != nil {
+			return err
+		}
+
+		msg.Body = req.withContinuationToken(token)
+		reply, err := n.requestReply(ctx, msg)
+		if err != nil {
+			return err
+		}
+
+		b := reflect.New(replyTyp)
+		if err := serialization.Unmarshal(reply.Body, b.Interface()); err != nil {
+			return err
+		}
+
+		b = b.Elem()
+
+		if errorCode := b.FieldByName("ErrorCode").Int(); errorCode != 0 {
+			return fmt.Errorf("%v returns HResult %v", req.QueryName, errorCode)
+		}
+
+		if kind := FabriQueryResultKind(b.FieldByName("ResultKind").Int()); kind != FabriQueryResultKindList {
+			return fmt.Errorf("%v returns unexpected result kind %v", req.QueryName, kind)
+		}
+
+		if list := b.FieldByName("ResultList"); !list.IsNil() {
+			items := list.Elem().Field(0)
+
+			for i := 0; i < items.Len(); i++ {
+				if err := fn(items.Index(i).Interface()); err != nil {
+					return err
+				}
+			}
+		}
+
+		next := ""
+		if paging, ok := b.FieldByName("PagingStatus").Interface().(*PagingStatus); ok && paging != nil {
+			next = paging.ContinuationToken
+		}
+
+		if next == "" {
+			return nil
+		}
+
+		if next == token {
+			return fmt.Errorf("%v returns same continuation token %v", req.QueryName, token)
+		}
+
+		token = next
+	}
+}
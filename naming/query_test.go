@@ -0,0 +1,122 @@
+package naming
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tg123/phabrik/serialization"
+	"github.com/tg123/phabrik/transport"
+)
+
+type testQueryReply struct {
+	ResultKind FabriQueryResultKind
+	ResultList *struct {
+		List []ApplicationQueryResult
+	}
+	ErrorCode    int32
+	PagingStatus *PagingStatus
+}
+
+func newTestQueryPage(token string, names ...string) *testQueryReply {
+	r := &testQueryReply{
+		ResultKind: FabriQueryResultKindList,
+		ResultList: &struct {
+			List []ApplicationQueryResult
+		}{},
+	}
+
+	for _, n := range names {
+		r.ResultList.List = append(r.ResultList.List, ApplicationQueryResult{ApplicationTypeName: n})
+	}
+
+	if token != "" {
+		r.PagingStatus = &PagingStatus{ContinuationToken: token}
+	}
+
+	return r
+}
+
+func TestListAll(t *testing.T) {
+	pages := map[string]*testQueryReply{
+		"":   newTestQueryPage("t1", "a", "b"),
+		"t1": newTestQueryPage("t2"), // empty page
+		"t2": newTestQueryPage("", "c"),
+	}
+
+	var tokens []string
+
+	n := mustLoopbackNamingClient(t, func(c transport.Conn, bam *transport.ByteArrayMessage) {
+		var req QueryRequest
+		if err := serialization.Unmarshal(bam.Body, &req); err != nil {
+			t.Error(err)
+			return
+		}
+
+		token := req.QueryArgs.QueryArgumentMap[QueryArgumentMapKey{queryArgumentContinuationToken}]
+		tokens = append(tokens, token)
+
+		replyTo(t, c, bam, "QueryReply", pages[token])
+	})
+
+	req := NewQueryRequest("GetApplicationList")
+
+	var names []string
+	err := n.ListAll(context.Background(), req, ApplicationQueryResult{}, func(item interface{}) error {
+		names = append(names, item.(ApplicationQueryResult).ApplicationTypeName)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []string{"a", "b", "c"}, names)
+	assert.Equal(t, []string{"", "t1", "t2"}, tokens)
+	assert.Empty(t, req.QueryArgs.QueryArgumentMap, "continuation token should not leak into request")
+
+	t.Run("nil argument map", func(t *testing.T) {
+		tokens = nil
+
+		var names []string
+		err := n.ListAll(context.Background(), &QueryRequest{QueryName: "GetApplicationList"}, ApplicationQueryResult{}, func(item interface{}) error {
+			names = append(names, item.(ApplicationQueryResult).ApplicationTypeName)
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a", "b", "c"}, names)
+		assert.Equal(t, []string{"", "t1", "t2"}, tokens)
+	})
+
+	t.Run("caller token", func(t *testing.T) {
+		tokens = nil
+
+		req := NewQueryRequest("GetApplicationList")
+		req.QueryArgs.QueryArgumentMap[QueryArgumentMapKey{queryArgumentContinuationToken}] = "t2"
+
+		var names []string
+		err := n.ListAll(context.Background(), req, ApplicationQueryResult{}, func(item interface{}) error {
+			names = append(names, item.(ApplicationQueryResult).ApplicationTypeName)
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"c"}, names)
+		assert.Equal(t, []string{"t2"}, tokens)
+		assert.Equal(t, map[QueryArgumentMapKey]string{{queryArgumentContinuationToken}: "t2"}, req.QueryArgs.QueryArgumentMap, "request not changed")
+	})
+
+	t.Run("cancel mid iteration", func(t *testing.T) {
+		tokens = nil
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		err := n.ListAll(ctx, NewQueryRequest("GetApplicationList"), ApplicationQueryResult{}, func(item interface{}) error {
+			cancel()
+			return nil
+		})
+
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, []string{""}, tokens)
+	})
+}
@@ -0,0 +1,142 @@
+package naming
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tg123/phabrik/common"
+	"github.com/tg123/phabrik/serialization"
+)
+
+var (
+	ErrNoEndpoints     = fmt.Errorf("no endpoints available for the resolved partition")
+	ErrStaleResolution = fmt.Errorf("resolution is not newer than the previous one")
+)
+
+// ServiceLocationVersion identifies a resolution result, a newer result is returned when the previous one is passed back
+type ServiceLocationVersion struct {
+	FMVersion    int64
+	Generation   GenerationNumber
+	StoreVersion int64
+}
+
+// olderOrEqual reports whether v is not newer than o, versions of different generations are not comparable
+func (v ServiceLocationVersion) olderOrEqual(o ServiceLocationVersion) bool {
+	if v.Generation != o.Generation {
+		return false
+	}
+
+	if v.FMVersion != o.FMVersion {
+		return v.FMVersion < o.FMVersion
+	}
+
+	return v.StoreVersion <= o.StoreVersion
+}
+
+type PartitionInfo struct {
+	PartitionKind FabricServicePartitionKind
+	LowKey        int64
+	HighKey       int64
+	PartitionName string
+}
+
+type ResolveServiceRequestBody struct {
+	Name            common.Uri
+	PartitionKey    serialization.PartitionKey
+	PreviousVersion ServiceLocationVersion
+}
+
+type ResolvedServicePartition struct {
+	IsServiceGroup bool
+	Locations      ServiceTableEntry
+	PartitionData  PartitionInfo
+	Generation     GenerationNumber
+	StoreVersion   int64
+}
+
+type ServiceEndpoint struct {
+	Address string
+	Role    FabricReplicaRole
+}
+
+// Version returns the version to pass back when the partition is resolved again
+func (p *ResolvedServicePartition) Version() ServiceLocationVersion {
+	return ServiceLocationVersion{
+		FMVersion:    p.Locations.ServiceReplicaSet.LookupVersion,
+		Generation:   p.Generation,
+		StoreVersion: p.StoreVersion,
+	}
+}
+
+// Endpoints returns all endpoints of the partition, primary first for stateful services
+func (p *ResolvedServicePartition) Endpoints() []ServiceEndpoint {
+	rs := p.Locations.ServiceReplicaSet
+
+	var endpoints []ServiceEndpoint
+
+	if !rs.IsStateful {
+		for _, l := range rs.ReplicaLocations {
+			endpoints = append(endpoints, ServiceEndpoint{Address: l, Role: FabricReplicaRoleNone})
+		}
+
+		return endpoints
+	}
+
+	if rs.IsPrimaryLocationValid {
+		endpoints = append(endpoints, ServiceEndpoint{Address: rs.PrimaryLocation, Role: FabricReplicaRolePrimary})
+	}
+
+	for _, l := range rs.ReplicaLocations {
+		endpoints = append(endpoints, ServiceEndpoint{Address: l, Role: FabricReplicaRoleActiveSecondary})
+	}
+
+	return endpoints
+}
+
+// ResolveService resolves the partition of name owning key
+// pass the previous result when its endpoints turned out to be stale, ErrStaleResolution is returned if the gateway has nothing newer
+func (n *NamingClient) ResolveService(ctx context.Context, name common.Uri, key serialization.PartitionKey, previous *ResolvedServicePartition) (*ResolvedServicePartition, error) {
+	if err := key.Validate(); err != nil {
+		return nil, err
+	}
+
+	msg, err := NewNamingMessage("ResolveService")
+	if err != nil {
+		return nil, err
+	}
+
+	body := &ResolveServiceRequestBody{
+		Name:         name,
+		PartitionKey: key,
+	}
+
+	if previous != nil {
+		body.PreviousVersion = previous.Version()
+	}
+
+	msg.Body = body
+
+	reply, err := n.requestReply(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(reply.Body) == 0 {
+		return nil, fmt.Errorf("empty ResolveService reply")
+	}
+
+	var p ResolvedServicePartition
+	if err := serialization.Unmarshal(reply.Body, &p); err != nil {
+		return nil, err
+	}
+
+	if previous != nil && p.Version().olderOrEqual(previous.Version()) {
+		return nil, ErrStaleResolution
+	}
+
+	if len(p.Endpoints()) == 0 {
+		return nil, ErrNoEndpoints
+	}
+
+	return &p, nil
+}
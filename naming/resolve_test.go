@@ -0,0 +1,163 @@
+package naming
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tg123/phabrik/common"
+	"github.com/tg123/phabrik/serialization"
+	"github.com/tg123/phabrik/transport"
+)
+
+func testResolvedPartition(lookupVersion int64, locations ...string) *ResolvedServicePartition {
+	p := &ResolvedServicePartition{
+		Locations: ServiceTableEntry{
+			ConsistencyUnitId: ConsistencyUnitId{serialization.MustNewGuidV4()},
+			ServiceName:       "fabric:/app/svc",
+			ServiceReplicaSet: ServiceReplicaSet{
+				IsStateful:    true,
+				LookupVersion: lookupVersion,
+			},
+			IsFound: true,
+		},
+		PartitionData: PartitionInfo{
+			PartitionKind: FabricServicePartitionKindInt64Range,
+			LowKey:        0,
+			HighKey:       100,
+		},
+		Generation:   GenerationNumber{Generation: 1},
+		StoreVersion: 7,
+	}
+
+	if len(locations) > 0 {
+		p.Locations.ServiceReplicaSet.IsPrimaryLocationValid = true
+		p.Locations.ServiceReplicaSet.PrimaryLocation = locations[0]
+		p.Locations.ServiceReplicaSet.ReplicaLocations = locations[1:]
+	}
+
+	return p
+}
+
+func TestResolvedServicePartitionLayout(t *testing.T) {
+	p := testResolvedPartition(3, "primary", "secondary1", "secondary2")
+
+	data, err := serialization.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, byte(serialization.FabricSerializationTypeObject), data[0])
+	assert.Equal(t, uint32(len(data)-1), uint32(data[1])|uint32(data[2])<<8|uint32(data[3])<<16|uint32(data[4])<<24)
+
+	var p2 ResolvedServicePartition
+	if err := serialization.Unmarshal(data, &p2); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, *p, p2)
+
+	assert.Equal(t, []ServiceEndpoint{
+		{Address: "primary", Role: FabricReplicaRolePrimary},
+		{Address: "secondary1", Role: FabricReplicaRoleActiveSecondary},
+		{Address: "secondary2", Role: FabricReplicaRoleActiveSecondary},
+	}, p2.Endpoints())
+
+	assert.Equal(t, ServiceLocationVersion{
+		FMVersion:    3,
+		Generation:   GenerationNumber{Generation: 1},
+		StoreVersion: 7,
+	}, p2.Version())
+
+	t.Run("stateless", func(t *testing.T) {
+		p := testResolvedPartition(1)
+		p.Locations.ServiceReplicaSet.IsStateful = false
+		p.Locations.ServiceReplicaSet.ReplicaLocations = []string{"a", "b"}
+
+		assert.Equal(t, []ServiceEndpoint{
+			{Address: "a", Role: FabricReplicaRoleNone},
+			{Address: "b", Role: FabricReplicaRoleNone},
+		}, p.Endpoints())
+	})
+}
+
+func TestResolveService(t *testing.T) {
+	requests := make(chan ResolveServiceRequestBody, 1)
+
+	n := mustLoopbackNamingClient(t, func(c transport.Conn, bam *transport.ByteArrayMessage) {
+		if bam.Headers.Action != "ResolveService" {
+			return
+		}
+
+		var b ResolveServiceRequestBody
+		if err := serialization.Unmarshal(bam.Body, &b); err != nil {
+			t.Error(err)
+			return
+		}
+
+		requests <- b
+
+		var reply *ResolvedServicePartition
+		switch b.PartitionKey.Int64Key {
+		case 1:
+			// no replica is up
+			reply = testResolvedPartition(1)
+		default:
+			// lookup version only moves forward after the stale version 2 is reported
+			version := int64(2)
+			if b.PreviousVersion.FMVersion == 2 && b.PartitionKey.Int64Key == 3 {
+				version = 3
+			}
+
+			reply = testResolvedPartition(version, "primary", "secondary")
+		}
+
+		replyTo(t, c, bam, "ResolveServiceReply", reply)
+	})
+
+	name := common.Uri{
+		Type:         common.UriTypeAbsolute,
+		Scheme:       "fabric",
+		Port:         -1,
+		Path:         "/app/svc",
+		PathSegments: []string{"app", "svc"},
+	}
+
+	p, err := n.ResolveService(context.Background(), name, serialization.Int64PartitionKey(2), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := <-requests
+	assert.Equal(t, name, b.Name)
+	assert.Equal(t, serialization.Int64PartitionKey(2), b.PartitionKey)
+	assert.Equal(t, ServiceLocationVersion{}, b.PreviousVersion)
+
+	assert.Equal(t, []ServiceEndpoint{
+		{Address: "primary", Role: FabricReplicaRolePrimary},
+		{Address: "secondary", Role: FabricReplicaRoleActiveSecondary},
+	}, p.Endpoints())
+
+	t.Run("no endpoints", func(t *testing.T) {
+		_, err := n.ResolveService(context.Background(), name, serialization.Int64PartitionKey(1), nil)
+		<-requests
+		assert.Equal(t, ErrNoEndpoints, err)
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		_, err := n.ResolveService(context.Background(), name, serialization.Int64PartitionKey(2), p)
+		b := <-requests
+		assert.Equal(t, p.Version(), b.PreviousVersion)
+		assert.Equal(t, ErrStaleResolution, err)
+
+		p2, err := n.ResolveService(context.Background(), name, serialization.Int64PartitionKey(3), p)
+		<-requests
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), p2.Version().FMVersion)
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		_, err := n.ResolveService(context.Background(), name, serialization.PartitionKey{}, nil)
+		assert.Error(t, err)
+	})
+}
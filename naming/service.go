@@ -0,0 +1,114 @@
+package naming
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tg123/phabrik/common"
+	"github.com/tg123/phabrik/serialization"
+)
+
+type ServiceLoadMetricDescription struct {
+	Name                 string
+	Weight               uint32
+	PrimaryDefaultLoad   uint32
+	SecondaryDefaultLoad uint32
+}
+
+// StatelessServiceDescription describes a stateless service to create
+// InstanceCount -1 places an instance on every node
+type StatelessServiceDescription struct {
+	ApplicationName      common.Uri
+	ServiceName          common.Uri
+	ServiceTypeName      string
+	InitializationData   []byte
+	PartitionKind        FabricServicePartitionKind
+	PartitionCount       int32
+	LowKey               int64
+	HighKey              int64
+	PartitionNames       []string
+	InstanceCount        int32
+	PlacementConstraints string
+	Metrics              []ServiceLoadMetricDescription
+}
+
+type CreateServiceRequestBody struct {
+	Name        common.Uri
+	Description StatelessServiceDescription
+}
+
+type CreateServiceReplyBody struct {
+	ErrorCode int32
+}
+
+func (d *StatelessServiceDescription) validate() error {
+	if d.ServiceName.Path == "" {
+		return fmt.Errorf("service name must not be empty")
+	}
+
+	if d.ServiceTypeName == "" {
+		return fmt.Errorf("service type name must not be empty")
+	}
+
+	if d.InstanceCount == 0 || d.InstanceCount < -1 {
+		return fmt.Errorf("invalid instance count %v", d.InstanceCount)
+	}
+
+	switch d.PartitionKind {
+	case FabricServicePartitionKindSingleton:
+	case FabricServicePartitionKindInt64Range:
+		if d.PartitionCount <= 0 {
+			return fmt.Errorf("int64 range partition count must > 0")
+		}
+
+		if d.LowKey > d.HighKey {
+			return fmt.Errorf("int64 range low key %v > high key %v", d.LowKey, d.HighKey)
+		}
+	case FabricServicePartitionKindNamee:
+		if len(d.PartitionNames) == 0 || int(d.PartitionCount) != len(d.PartitionNames) {
+			return fmt.Errorf("named partition count must match partition names")
+		}
+	default:
+		return fmt.Errorf("invalid partition kind %v", d.PartitionKind)
+	}
+
+	return nil
+}
+
+// CreateService creates a stateless service
+func (n *NamingClient) CreateService(ctx context.Context, description *StatelessServiceDescription) error {
+	if err := description.validate(); err != nil {
+		return err
+	}
+
+	msg, err := NewNamingMessage("CreateServiceRequest")
+	if err != nil {
+		return err
+	}
+
+	msg.Body = &CreateServiceRequestBody{
+		Name:        description.ServiceName,
+		Description: *description,
+	}
+
+	reply, err := n.requestReply(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	// empty reply body is success
+	if len(reply.Body) == 0 {
+		return nil
+	}
+
+	var b CreateServiceReplyBody
+	if err := serialization.Unmarshal(reply.Body, &b); err != nil {
+		return err
+	}
+
+	if b.ErrorCode != 0 {
+		return fmt.Errorf("CreateService returns HResult %v", b.ErrorCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package naming
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tg123/phabrik/common"
+	"github.com/tg123/phabrik/serialization"
+	"github.com/tg123/phabrik/transport"
+)
+
+func testServiceDescription() *StatelessServiceDescription {
+	return &StatelessServiceDescription{
+		ApplicationName: common.Uri{
+			Type:         common.UriTypeAbsolute,
+			Scheme:       "fabric",
+			Port:         -1,
+			Path:         "/app",
+			PathSegments: []string{"app"},
+		},
+		ServiceName: common.Uri{
+			Type:         common.UriTypeAbsolute,
+			Scheme:       "fabric",
+			Port:         -1,
+			Path:         "/app/svc",
+			PathSegments: []string{"app", "svc"},
+		},
+		ServiceTypeName:    "SvcType",
+		InitializationData: []byte{1, 2, 3},
+		PartitionKind:      FabricServicePartitionKindSingleton,
+		InstanceCount:      -1,
+		Metrics: []ServiceLoadMetricDescription{
+			{Name: "CPU", Weight: 1, PrimaryDefaultLoad: 10},
+		},
+	}
+}
+
+func TestServiceDescriptionLayout(t *testing.T) {
+	d := testServiceDescription()
+
+	data, err := serialization.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// object meta, header whose size spans the rest, scope begin, then ApplicationName as nested object
+	assert.Equal(t, byte(serialization.FabricSerializationTypeObject), data[0])
+	assert.Equal(t, uint32(len(data)-1), uint32(data[1])|uint32(data[2])<<8|uint32(data[3])<<16|uint32(data[4])<<24)
+	assert.Equal(t, byte(serialization.FabricSerializationTypeScopeBegin), data[9])
+	assert.Equal(t, byte(serialization.FabricSerializationTypeObject), data[10])
+
+	var d2 StatelessServiceDescription
+	if err := serialization.Unmarshal(data, &d2); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, *d, d2)
+}
+
+func TestCreateService(t *testing.T) {
+	requests := make(chan CreateServiceRequestBody, 1)
+
+	n := mustLoopbackNamingClient(t, func(c transport.Conn, bam *transport.ByteArrayMessage) {
+		if bam.Headers.Action != "CreateServiceRequest" {
+			return
+		}
+
+		var b CreateServiceRequestBody
+		if err := serialization.Unmarshal(bam.Body, &b); err != nil {
+			t.Error(err)
+			return
+		}
+
+		requests <- b
+
+		var errorCode int32
+		if b.Description.ServiceTypeName == "Exists" {
+			errorCode = 1
+		}
+
+		replyTo(t, c, bam, "CreateServiceReply", &CreateServiceReplyBody{
+			ErrorCode: errorCode,
+		})
+	})
+
+	d := testServiceDescription()
+	if err := n.CreateService(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+
+	b := <-requests
+	assert.Equal(t, d.ServiceName, b.Name)
+	assert.Equal(t, *d, b.Description)
+
+	t.Run("error reply", func(t *testing.T) {
+		d := testServiceDescription()
+		d.ServiceTypeName = "Exists"
+		assert.Error(t, n.CreateService(context.Background(), d))
+		<-requests
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		d := testServiceDescription()
+		d.ServiceName = common.Uri{}
+		assert.Error(t, n.CreateService(context.Background(), d))
+
+		d = testServiceDescription()
+		d.ServiceTypeName = ""
+		assert.Error(t, n.CreateService(context.Background(), d))
+
+		d = testServiceDescription()
+		d.InstanceCount = 0
+		assert.Error(t, n.CreateService(context.Background(), d))
+
+		d = testServiceDescription()
+		d.PartitionKind = FabricServicePartitionKindNamee
+		d.PartitionCount = 2
+		d.PartitionNames = []string{"a"}
+		assert.Error(t, n.CreateService(context.Background(), d))
+	})
+}
@@ -0,0 +1,147 @@
+package naming
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tg123/phabrik/serialization"
+	"github.com/tg123/phabrik/transport"
+)
+
+// MaxUploadChunkSize is the largest chunk content accepted by the image store
+const MaxUploadChunkSize = 4 * 1024 * 1024
+
+type UploadChunkRequestBody struct {
+	StoreRelativePath string
+	SessionId         serialization.GUID
+	StartPosition     uint64
+	EndPosition       uint64
+	Content           []byte
+}
+
+type UploadSessionRequestBody struct {
+	StoreRelativePath string
+	SessionId         serialization.GUID
+	FileSize          uint64
+}
+
+type UploadReplyBody struct {
+	ErrorCode int32
+}
+
+// UploadSession tracks an upload of a file to the image store, chunks must be uploaded in order
+type UploadSession struct {
+	StoreRelativePath string
+	SessionId         serialization.GUID
+	FileSize          uint64
+
+	uploaded uint64
+}
+
+func NewUploadSession(storeRelativePath string, fileSize uint64) (*UploadSession, error) {
+	if storeRelativePath == "" {
+		return nil, fmt.Errorf("store relative path must not be empty")
+	}
+
+	id, err := serialization.NewGuidV4()
+	if err != nil {
+		return nil, err
+	}
+
+	return &UploadSession{
+		StoreRelativePath: storeRelativePath,
+		SessionId:         id,
+		FileSize:          fileSize,
+	}, nil
+}
+
+// Uploaded returns the number of bytes uploaded, which is also the offset of the next chunk
+func (s *UploadSession) Uploaded() uint64 {
+	return s.uploaded
+}
+
+func newFileStoreMessage(action string, body interface{}) (*transport.Message, error) {
+	msg, err := NewNamingMessage(action)
+	if err != nil {
+		return nil, err
+	}
+
+	msg.Headers.Actor = transport.MessageActorTypeFileStoreService
+	msg.Body = body
+
+	return msg, nil
+}
+
+func (n *NamingClient) fileStoreRequest(ctx context.Context, action string, body interface{}) error {
+	msg, err := newFileStoreMessage(action, body)
+	if err != nil {
+		return err
+	}
+
+	reply, err := n.requestReply(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	// empty reply body is success
+	if len(reply.Body) == 0 {
+		return nil
+	}
+
+	var b UploadReplyBody
+	if err := serialization.Unmarshal(reply.Body, &b); err != nil {
+		return err
+	}
+
+	if b.ErrorCode != 0 {
+		return fmt.Errorf("%v returns HResult %v", action, b.ErrorCode)
+	}
+
+	return nil
+}
+
+// UploadChunk uploads content at offset, which must be where the previous chunk ended
+func (n *NamingClient) UploadChunk(ctx context.Context, session *UploadSession, offset uint64, content []byte) error {
+	if len(content) == 0 {
+		return fmt.Errorf("chunk must not be empty")
+	}
+
+	if len(content) > MaxUploadChunkSize {
+		return fmt.Errorf("chunk size %v exceeds max %v", len(content), MaxUploadChunkSize)
+	}
+
+	if offset != session.uploaded {
+		return fmt.Errorf("out of order chunk at offset %v, expect %v", offset, session.uploaded)
+	}
+
+	end := offset + uint64(len(content))
+	if end > session.FileSize {
+		return fmt.Errorf("chunk end %v exceeds file size %v", end, session.FileSize)
+	}
+
+	if err := n.fileStoreRequest(ctx, "UploadChunk", &UploadChunkRequestBody{
+		StoreRelativePath: session.StoreRelativePath,
+		SessionId:         session.SessionId,
+		StartPosition:     offset,
+		EndPosition:       end - 1,
+		Content:           content,
+	}); err != nil {
+		return err
+	}
+
+	session.uploaded = end
+	return nil
+}
+
+// Commit completes the upload session after all chunks are uploaded
+func (n *NamingClient) Commit(ctx context.Context, session *UploadSession) error {
+	if session.uploaded != session.FileSize {
+		return fmt.Errorf("upload incomplete, %v of %v bytes uploaded", session.uploaded, session.FileSize)
+	}
+
+	return n.fileStoreRequest(ctx, "CommitUploadSession", &UploadSessionRequestBody{
+		StoreRelativePath: session.StoreRelativePath,
+		SessionId:         session.SessionId,
+		FileSize:          session.FileSize,
+	})
+}
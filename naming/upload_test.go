@@ -0,0 +1,127 @@
+package naming
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tg123/phabrik/serialization"
+	"github.com/tg123/phabrik/transport"
+)
+
+func TestUploadChunkLayout(t *testing.T) {
+	b := UploadChunkRequestBody{
+		StoreRelativePath: "p",
+		SessionId:         serialization.MustNewGuidV4(),
+		StartPosition:     0,
+		EndPosition:       2,
+		Content:           []byte{1, 2, 3},
+	}
+
+	data, err := serialization.Marshal(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, byte(serialization.FabricSerializationTypeObject), data[0])
+	assert.Equal(t, uint32(len(data)-1), uint32(data[1])|uint32(data[2])<<8|uint32(data[3])<<16|uint32(data[4])<<24)
+	assert.Equal(t, []byte{
+		0x8d, 0x01, 0x70, 0x00, // StoreRelativePath
+	}, data[10:14])
+	assert.Equal(t, []byte{
+		0x4a,       // StartPosition, empty
+		0x0a, 0x02, // EndPosition
+		0x84, 0x03, 0x04, 0x01, 0x04, 0x02, 0x04, 0x03, // Content, uchar array
+		0x2f, 0x3f, // scope end, object end
+	}, data[len(data)-13:])
+
+	var b2 UploadChunkRequestBody
+	if err := serialization.Unmarshal(data, &b2); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, b, b2)
+}
+
+func TestUploadChunks(t *testing.T) {
+	var lock sync.Mutex
+	var content bytes.Buffer
+	var committed *UploadSessionRequestBody
+
+	n := mustLoopbackNamingClient(t, func(c transport.Conn, bam *transport.ByteArrayMessage) {
+		if bam.Headers.Actor != transport.MessageActorTypeFileStoreService {
+			return
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch bam.Headers.Action {
+		case "UploadChunk":
+			var b UploadChunkRequestBody
+			if err := serialization.Unmarshal(bam.Body, &b); err != nil {
+				t.Error(err)
+				return
+			}
+
+			var errorCode int32
+			if b.StartPosition != uint64(content.Len()) || b.EndPosition-b.StartPosition+1 != uint64(len(b.Content)) {
+				errorCode = 1
+			} else {
+				content.Write(b.Content)
+			}
+
+			replyTo(t, c, bam, "UploadChunkReply", &UploadReplyBody{ErrorCode: errorCode})
+		case "CommitUploadSession":
+			var b UploadSessionRequestBody
+			if err := serialization.Unmarshal(bam.Body, &b); err != nil {
+				t.Error(err)
+				return
+			}
+
+			committed = &b
+			replyTo(t, c, bam, "CommitUploadSessionReply", nil)
+		}
+	})
+
+	file := bytes.Repeat([]byte{1, 2, 3, 4, 5, 6, 7}, 3)
+
+	s, err := NewUploadSession("pkg/app.sfpkg", uint64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	assert.NoError(t, n.UploadChunk(ctx, s, 0, file[:10]))
+
+	t.Run("rejected", func(t *testing.T) {
+		assert.Error(t, n.UploadChunk(ctx, s, 0, file[:10]), "replayed chunk")
+		assert.Error(t, n.UploadChunk(ctx, s, 15, file[15:]), "gap")
+		assert.Error(t, n.UploadChunk(ctx, s, 10, nil), "empty")
+		assert.Error(t, n.UploadChunk(ctx, s, 10, make([]byte, MaxUploadChunkSize+1)), "too large")
+		assert.Error(t, n.UploadChunk(ctx, s, 10, append(file[10:], 0)), "beyond file size")
+		assert.Error(t, n.Commit(ctx, s), "incomplete")
+		assert.Equal(t, uint64(10), s.Uploaded())
+	})
+
+	assert.NoError(t, n.UploadChunk(ctx, s, 10, file[10:]))
+	assert.NoError(t, n.Commit(ctx, s))
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	assert.Equal(t, file, content.Bytes())
+	assert.Equal(t, &UploadSessionRequestBody{
+		StoreRelativePath: "pkg/app.sfpkg",
+		SessionId:         s.SessionId,
+		FileSize:          uint64(len(file)),
+	}, committed)
+
+	t.Run("empty path", func(t *testing.T) {
+		_, err := NewUploadSession("", 1)
+		assert.Error(t, err)
+	})
+}
@@ -5,12 +5,11 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math"
+	"math/bits"
 	"reflect"
 	"testing"
 )
 
-// TODO test binary values
-
 func TestCompressedEmpty(t *testing.T) {
 	ec := &encodeState{}
 	ec.pushBuffer()
@@ -82,7 +81,7 @@ func TestCompressedSigned(t *testing.T) {
 				return
 			}
 
-			dc := decodeState{bytes.NewReader(ec.buf.Bytes())}
+			dc := decodeState{inner: bytes.NewReader(ec.buf.Bytes())}
 
 			v, err := dc.readCompressedSigned(int(rv.Type().Size()))
 			if err != nil {
@@ -98,6 +97,90 @@ func TestCompressedSigned(t *testing.T) {
 	}
 }
 
+func TestCompressedSignedBytes(t *testing.T) {
+	tests := []struct {
+		size   int
+		value  int64
+		expect []byte
+	}{
+		{2, 1, []byte{0x01}},
+		{4, -1, []byte{0x7f}},
+		{4, 63, []byte{0x3f}},
+		{4, 64, []byte{0x80, 0x40}},
+		{4, -64, []byte{0x40}},
+		{4, -65, []byte{0xff, 0x3f}},
+		{2, math.MaxInt16, []byte{0x81, 0xff, 0x7f}},
+		{2, math.MinInt16, []byte{0xfe, 0x80, 0x00}},
+		{4, math.MaxInt32, []byte{0x87, 0xff, 0xff, 0xff, 0x7f}},
+		{4, math.MinInt32, []byte{0xf8, 0x80, 0x80, 0x80, 0x00}},
+		{8, math.MaxInt64, []byte{0x80, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}},
+		{8, math.MinInt64, []byte{0xff, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x00}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("compress %v", tt.value), func(t *testing.T) {
+			ec := &encodeState{}
+			ec.pushBuffer()
+			if err := ec.writeCompressedSigned(tt.size, tt.value); err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(ec.buf.Bytes(), tt.expect) {
+				t.Errorf("got % x, expect % x", ec.buf.Bytes(), tt.expect)
+			}
+		})
+	}
+}
+
+// TestCompressedSignedBoundaries round trips values around each 7 bit group boundary, each with the fewest bytes
+func TestCompressedSignedBoundaries(t *testing.T) {
+	for _, size := range []int{2, 4, 8} {
+		width := size * 8
+		minValue := int64(-1) << (width - 1)
+		maxValue := ^minValue
+
+		var values []int64
+		for k := 0; k < width-1; k++ {
+			v := int64(1) << k
+			values = append(values, v, v-1, -v, -v-1)
+		}
+		values = append(values, minValue, maxValue, minValue+1, maxValue-1)
+
+		for _, v := range values {
+			if v < minValue || v > maxValue || v == 0 {
+				continue
+			}
+
+			ec := &encodeState{}
+			ec.pushBuffer()
+			if err := ec.writeCompressedSigned(size, v); err != nil {
+				t.Fatal(err)
+			}
+
+			// fewest 7 bit groups that hold v and its sign
+			u := v
+			if v < 0 {
+				u = ^v
+			}
+			n := (bits.Len64(uint64(u)) + 1 + 6) / 7
+
+			if ec.buf.Len() != n {
+				t.Errorf("size %v value %v written in %v bytes, expect %v", size, v, ec.buf.Len(), n)
+			}
+
+			dc := decodeState{inner: bytes.NewReader(ec.buf.Bytes())}
+			got, err := dc.readCompressedSigned(size)
+			if err != nil {
+				t.Errorf("size %v value %v decompress error = %v", size, v, err)
+				continue
+			}
+
+			if got != v {
+				t.Errorf("size %v value changed got %v, expect %v", size, got, v)
+			}
+		}
+	}
+}
+
 func TestCompressedUnsigned(t *testing.T) {
 	tests := []struct {
 		value interface{}
@@ -134,7 +217,7 @@ func TestCompressedUnsigned(t *testing.T) {
 				return
 			}
 
-			dc := decodeState{bytes.NewReader(ec.buf.Bytes())}
+			dc := decodeState{inner: bytes.NewReader(ec.buf.Bytes())}
 
 			v, err := dc.readCompressedUnsigned(int(rv.Type().Size()))
 			if err != nil {
@@ -149,3 +232,84 @@ func TestCompressedUnsigned(t *testing.T) {
 		})
 	}
 }
+
+func TestCompressedUnsignedBytes(t *testing.T) {
+	tests := []struct {
+		size   int
+		value  uint64
+		expect []byte
+	}{
+		{4, 0, nil}, // carried by the empty meta
+		{2, 1, []byte{0x01}},
+		{2, 0x7f, []byte{0x7f}},
+		{2, 0x80, []byte{0x81, 0x00}},
+		{4, 0x3fff, []byte{0xff, 0x7f}},
+		{4, 0x4000, []byte{0x81, 0x80, 0x00}},
+		{2, math.MaxUint16, []byte{0x83, 0xff, 0x7f}},
+		{4, math.MaxUint32, []byte{0x8f, 0xff, 0xff, 0xff, 0x7f}},
+		{8, math.MaxUint64, []byte{0x81, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("compress %v", tt.value), func(t *testing.T) {
+			ec := &encodeState{}
+			ec.pushBuffer()
+			if err := ec.writeCompressedUnsigned(tt.size, tt.value); err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(ec.buf.Bytes(), tt.expect) {
+				t.Errorf("got % x, expect % x", ec.buf.Bytes(), tt.expect)
+			}
+		})
+	}
+}
+
+// TestCompressedUnsignedBoundaries round trips values around each 7 bit group boundary, each with the fewest bytes
+func TestCompressedUnsignedBoundaries(t *testing.T) {
+	for _, size := range []int{2, 4, 8} {
+		width := size * 8
+		maxValue := uint64(math.MaxUint64) >> (64 - width)
+
+		var values []uint64
+		for k := 0; k < width; k++ {
+			v := uint64(1) << k
+			values = append(values, v, v-1, v+1)
+		}
+		values = append(values, maxValue, maxValue-1)
+
+		for _, v := range values {
+			if v == 0 || v > maxValue {
+				continue
+			}
+
+			ec := &encodeState{}
+			ec.pushBuffer()
+			if err := ec.writeCompressedUnsigned(size, v); err != nil {
+				t.Fatal(err)
+			}
+
+			n := (bits.Len64(v) + 6) / 7
+			if ec.buf.Len() != n {
+				t.Errorf("size %v value %#x written in %v bytes, expect %v", size, v, ec.buf.Len(), n)
+			}
+
+			// every byte but the last has the continuation bit
+			for i, b := range ec.buf.Bytes() {
+				if (b&valueCompressMaskMoredata != 0) != (i < n-1) {
+					t.Errorf("size %v value %#x byte %v is %#x", size, v, i, b)
+				}
+			}
+
+			dc := decodeState{inner: bytes.NewReader(ec.buf.Bytes())}
+			got, err := dc.readCompressedUnsigned(size)
+			if err != nil {
+				t.Errorf("size %v value %#x decompress error = %v", size, v, err)
+				continue
+			}
+
+			if got != v {
+				t.Errorf("size %v value changed got %#x, expect %#x", size, got, v)
+			}
+		}
+	}
+}
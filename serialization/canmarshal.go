@@ -0,0 +1,110 @@
+package serialization
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// CanMarshal returns the error Marshal would fail with for an unsupported type in v, e.g. a chan field, with its field path.
+// Only types are checked, nothing is written: values checked by Marshal, e.g. unregistered variants of interface fields,
+// union variants or enums, may still fail. Custom marshalers are assumed to succeed.
+func CanMarshal(v interface{}) error {
+	if v == nil {
+		return fmt.Errorf("cannot marshal nil")
+	}
+
+	switch v.(type) {
+	case []byte, *Precompiled:
+		return nil
+	}
+
+	t := reflect.TypeOf(v)
+	if t.Kind() != reflect.Ptr {
+		return fmt.Errorf("marshal type must be ptr")
+	}
+
+	if t.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("marshal type must be ptr to struct")
+	}
+
+	return canMarshalType(t.Elem(), make(map[reflect.Type]bool))
+}
+
+// canMarshalType follows the kind dispatch of encodeState.value for type t, each type is checked once
+func canMarshalType(t reflect.Type, checked map[reflect.Type]bool) error {
+	if checked[t] {
+		return nil
+	}
+	checked[t] = true
+
+	if t == rawType || isTicksType(t) {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool, reflect.String, reflect.Interface, reflect.Float32, reflect.Float64,
+		reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+		return nil
+	case reflect.Ptr:
+		return canMarshalType(t.Elem(), checked)
+	case reflect.Struct:
+		if value, pointer := implementsMarshaler(t); value || pointer {
+			return nil
+		}
+
+		rv := reflect.New(t).Elem()
+		if _, err := unionOf(rv, false); err != nil {
+			return err
+		}
+
+		fields, _, err := splitRest(allFields(rv, true))
+		if err != nil {
+			return err
+		}
+
+		for _, f := range fields {
+			if _, err := escapedValue(f); err != nil {
+				return marshalErrorAt(err, f.name)
+			}
+
+			if err := canMarshalType(f.value.Type(), checked); err != nil {
+				return marshalErrorAt(err, f.name)
+			}
+		}
+
+		return nil
+	case reflect.Slice, reflect.Array:
+		elem := t.Elem()
+
+		switch elmKind := elemKind(elem); elmKind {
+		case reflect.String, reflect.Ptr, reflect.Interface:
+		default:
+			if kindToFabricSerializationType(elmKind) == FabricSerializationTypeNotAMeta {
+				return &UnsupportedTypeError{Type: elem}
+			}
+		}
+
+		if err := canMarshalType(elem, checked); err != nil {
+			return marshalErrorAt(err, "[]")
+		}
+
+		return nil
+	case reflect.Map:
+		if t == stringSetType {
+			return nil
+		}
+
+		if err := canMarshalType(t.Key(), checked); err != nil {
+			return marshalErrorAt(err, "[key]")
+		}
+
+		if err := canMarshalType(t.Elem(), checked); err != nil {
+			return marshalErrorAt(err, "[]")
+		}
+
+		return nil
+	default:
+		return &UnsupportedTypeError{Type: t}
+	}
+}
@@ -0,0 +1,62 @@
+package serialization
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanMarshal(t *testing.T) {
+	type inner struct {
+		F func()
+	}
+
+	type supported struct {
+		A int
+		B []string
+		C map[int32][]byte
+		D *nestedNode
+		E interface{}
+		F GUID
+		G StringSet
+		H Raw `fabric:",rest"`
+	}
+
+	assert.NoError(t, CanMarshal(&supported{}))
+	assert.NoError(t, CanMarshal([]byte{1}))
+
+	for _, c := range []struct {
+		v      interface{}
+		expect string
+	}{
+		{&struct{ C chan int }{}, "field C: unsupported marshal type chan int"},
+		{&struct{ In inner }{}, "field In.F: unsupported marshal type func()"},
+		{&struct{ In []*inner }{}, "field In[].F: unsupported marshal type func()"},
+		{&struct{ M map[string]complex64 }{}, "field M[]: unsupported marshal type complex64"},
+		{&struct{ S [][]int32 }{}, "field S: unsupported marshal type []int32"},
+		{&struct {
+			N string `fabric:",escape"`
+			E int32  `fabric:",escape"`
+		}{}, "field E: escape field E must be string"},
+		{&struct {
+			R Raw `fabric:",rest"`
+			A int32
+		}{}, "rest field R must be the last field"},
+		{supported{}, "marshal type must be ptr"},
+	} {
+		err := CanMarshal(c.v)
+		if assert.Error(t, err) {
+			assert.Equal(t, c.expect, err.Error())
+		}
+	}
+
+	t.Run("same as marshal", func(t *testing.T) {
+		v := &struct{ In []*inner }{In: []*inner{{F: func() {}}}}
+
+		_, err := Marshal(v)
+		if assert.Error(t, err) {
+			assert.Equal(t, "field In[0].F: unsupported marshal type func()", err.Error())
+			assert.Equal(t, "field In[].F: unsupported marshal type func()", CanMarshal(v).Error())
+		}
+	})
+}
@@ -3,20 +3,26 @@ package serialization
 import (
 	"encoding/binary"
 	"reflect"
+	"strings"
+	"sync"
 )
 
+// Encoder is the write side given to CustomMarshaler.Marshal, values are written as meta followed by data
 type Encoder interface {
 	WriteTypeMeta(FabricSerializationType) error
 	WriteBinary(interface{}) error
 	WriteCompressedUInt32(uint32) error
 }
 
+// Decoder is the read side given to CustomMarshaler.Unmarshal
 type Decoder interface {
 	ReadTypeMeta() (FabricSerializationType, error)
 	ReadBinary(interface{}) error
 	ReadCompressedUInt32() (uint32, error)
 }
 
+// CustomMarshaler is implemented by types outside the reflection rules, it is checked for on the value and its pointer
+// Marshal writes the meta and data of the value, Unmarshal reads the data following meta, including empty metas
 type CustomMarshaler interface {
 	Marshal(Encoder) error
 	Unmarshal(FabricSerializationType, Decoder) error
@@ -24,12 +30,38 @@ type CustomMarshaler interface {
 
 var customMarshalerType = reflect.TypeOf((*CustomMarshaler)(nil)).Elem()
 
+// customMarshalers caches whether T and *T implement CustomMarshaler
+var customMarshalers sync.Map // reflect.Type -> [2]bool
+
+func implementsMarshaler(t reflect.Type) (value, pointer bool) {
+	if v, ok := customMarshalers.Load(t); ok {
+		b := v.([2]bool)
+		return b[0], b[1]
+	}
+
+	b := [2]bool{t.Implements(customMarshalerType), t.Kind() != reflect.Ptr && reflect.PtrTo(t).Implements(customMarshalerType)}
+	customMarshalers.Store(t, b)
+	return b[0], b[1]
+}
+
 func castToMarshaler(rv reflect.Value) (CustomMarshaler, bool) {
+	value, pointer := implementsMarshaler(rv.Type())
+	if !value && !pointer {
+		return nil, false
+	}
+
 	var v interface{}
-	if rv.Kind() != reflect.Ptr && reflect.PtrTo(rv.Type()).Implements(customMarshalerType) {
+	if rv.Kind() != reflect.Ptr && pointer {
+
+		if !rv.CanAddr() {
+			// marshal a copy of non-addressable value, e.g. element of a non-addressable array
+			cp := reflect.New(rv.Type())
+			cp.Elem().Set(rv)
+			rv = cp.Elem()
+		}
 
 		v = rv.Addr().Interface()
-	} else if rv.Type().Implements(customMarshalerType) {
+	} else if value {
 		v = rv.Interface()
 	}
 
@@ -43,6 +75,13 @@ const (
 	headerFlagsEmpty                   headerFlags = 0x00
 	headerFlagsContainsTypeInformation headerFlags = 0x01
 	headerFlagsContainsExtensionData   headerFlags = 0x02
+
+	// phabrik extension, fields are prefixed with ordinal and length, see MarshalOptions.OrdinalFields
+	headerFlagsOrdinalFields headerFlags = 0x04
+
+	// header is longer than objectHeader, Padding[0] declares the header size including the fixed fields
+	// decoders skip header bytes they do not understand so the header can grow
+	headerFlagsExtendedHeader headerFlags = 0x08
 )
 
 type objectHeader struct {
@@ -53,29 +92,216 @@ type objectHeader struct {
 
 var sizeOfobjectHeader = uint32(binary.Size(objectHeader{}))
 
-func allFields(rv reflect.Value) []reflect.Value {
-	if rv.Kind() != reflect.Struct {
-		return nil
+// headerSize returns the declared size of the header
+func (h *objectHeader) headerSize() uint32 {
+	if h.Flag&headerFlagsExtendedHeader != headerFlagsExtendedHeader {
+		return sizeOfobjectHeader
+	}
+
+	return uint32(h.Padding[0])
+}
+
+// fieldTag is parsed from struct tag `fabric:"[name][,option...]"`
+type fieldTag struct {
+	name      string
+	truncate  bool   // fixed array accepts wire array with different length
+	union     bool   // discriminator of a union, see union.go
+	unionCase string // kind value of a union variant
+	rest      bool   // Raw capturing unknown trailing fields, see raw.go
+	escape    bool   // string escaped as naming uri, see escape.go
+	cppName   string // name of the field in C++ Fabric, written in the field name table
+	required  bool   // nil pointer or interface fails marshal instead of written as empty
+	present   bool   // empty string written as zero length wstring instead of empty meta
+}
+
+func parseFieldTag(tag string) fieldTag {
+	var t fieldTag
+
+	for i, opt := range strings.Split(tag, ",") {
+		switch opt {
+		case "truncate":
+			t.truncate = true
+		case "union":
+			t.union = true
+		case "rest":
+			t.rest = true
+		case "escape":
+			t.escape = true
+		case "required":
+			t.required = true
+		case "present":
+			t.present = true
+		default:
+			if strings.HasPrefix(opt, "case=") {
+				t.unionCase = strings.TrimPrefix(opt, "case=")
+				continue
+			}
+
+			if strings.HasPrefix(opt, "cpp=") {
+				t.cppName = strings.TrimPrefix(opt, "cpp=")
+				continue
+			}
+
+			if i == 0 {
+				t.name = opt
+			}
+		}
+	}
+
+	return t
+}
+
+type structField struct {
+	value reflect.Value
+	tag   fieldTag
+	name  string // tag name, or go field name
+}
+
+// wireName is the name in the field name table, C++ name if tagged
+func (f structField) wireName() string {
+	if f.tag.cppName != "" {
+		return f.tag.cppName
 	}
 
-	var fields []reflect.Value
+	return f.name
+}
 
-	typ := rv.Type()
+// flattened reports whether the fields of embedded ft are promoted into the parent object
+// embeds of non struct types and of types with their own encoding, e.g. time.Time or a CustomMarshaler, are normal fields
+func flattened(ft reflect.StructField) bool {
+	if !ft.Anonymous {
+		return false
+	}
+
+	t := ft.Type
+	if t.Kind() == reflect.Ptr {
+		// unexported *Base cannot be allocated
+		if ft.PkgPath != "" {
+			return false
+		}
+
+		t = t.Elem()
+	}
+
+	return t.Kind() == reflect.Struct && !isTicksType(t) && !reflect.PtrTo(t).Implements(customMarshalerType)
+}
+
+// fieldPlan is the reflection of a struct field done once per type
+type fieldPlan struct {
+	index   int
+	tag     fieldTag
+	name    string // tag name, or go field name
+	flatten bool   // embedded struct or *struct, fields are promoted
+}
 
-	for i := 0; i < typ.NumField(); i++ {
-		ft := typ.Field(i)
-		fv := rv.Field(i)
+var structPlans sync.Map // reflect.Type -> []fieldPlan
 
-		if !fv.CanSet() {
+// planOf returns the serialized fields of struct type t in declaration order, embedded plans are looked up when walked
+func planOf(t reflect.Type) []fieldPlan {
+	if p, ok := structPlans.Load(t); ok {
+		return p.([]fieldPlan)
+	}
+
+	var plan []fieldPlan
+
+	for i := 0; i < t.NumField(); i++ {
+		ft := t.Field(i)
+		flatten := flattened(ft)
+
+		// only exported fields are serialized, like encoding/json exported fields of an embedded unexported struct are promoted
+		if ft.PkgPath != "" && !flatten {
+			continue
+		}
+
+		// fabric:"-" is never on the wire, a field named - is tagged fabric:"-,"
+		if ft.Tag.Get("fabric") == "-" {
 			continue
 		}
 
-		if ft.Anonymous {
-			fields = append(fields, allFields(fv)...)
-		} else {
-			fields = append(fields, fv)
+		f := fieldPlan{
+			index:   i,
+			flatten: flatten,
 		}
+
+		if !flatten {
+			f.tag = parseFieldTag(ft.Tag.Get("fabric"))
+			f.name = f.tag.name
+			if f.name == "" {
+				f.name = ft.Name
+			}
+		}
+
+		plan = append(plan, f)
+	}
+
+	p, _ := structPlans.LoadOrStore(t, plan)
+	return p.([]fieldPlan)
+}
+
+// allFields returns fields in wire order, embedded structs are flattened and fields tagged fabric:"-" skipped
+// embedded *Base is flattened as Base, nil is marshaled as zero Base and allocated when alloc
+func allFields(rv reflect.Value, alloc bool) []structField {
+	return appendFields(nil, rv, alloc)
+}
+
+// appendFields appends the fields of rv to dst as allFields returns them
+func appendFields(dst []structField, rv reflect.Value, alloc bool) []structField {
+	if rv.Kind() != reflect.Struct {
+		return dst
+	}
+
+	for _, f := range planOf(rv.Type()) {
+		fv := rv.Field(f.index)
+
+		if !f.flatten {
+			dst = append(dst, structField{
+				value: fv,
+				tag:   f.tag,
+				name:  f.name,
+			})
+
+			continue
+		}
+
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				if !alloc {
+					dst = appendFields(dst, reflect.New(fv.Type().Elem()).Elem(), alloc)
+					continue
+				}
+
+				fv.Set(reflect.New(fv.Type().Elem()))
+			}
+
+			fv = fv.Elem()
+		}
+
+		dst = appendFields(dst, fv, alloc)
+	}
+
+	return dst
+}
+
+// fieldStack reuses one slice for the fields of nested objects being encoded or decoded,
+// so walking a struct does not allocate once the stack has grown to the deepest nesting
+type fieldStack struct {
+	fields []structField
+}
+
+// push appends the fields of rv, they are valid until pop(start)
+func (st *fieldStack) push(rv reflect.Value, alloc bool) (fields []structField, start int) {
+	start = len(st.fields)
+	st.fields = appendFields(st.fields, rv, alloc)
+
+	// capped, a nested push never writes into fields
+	return st.fields[start:len(st.fields):len(st.fields)], start
+}
+
+// pop releases fields pushed at start, values are cleared to not retain the object
+func (st *fieldStack) pop(start int) {
+	for i := start; i < len(st.fields); i++ {
+		st.fields[i] = structField{}
 	}
 
-	return fields
+	st.fields = st.fields[:start]
 }
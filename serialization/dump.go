@@ -0,0 +1,335 @@
+package serialization
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type dumpState struct {
+	decodeState
+	out strings.Builder
+}
+
+func metaName(meta FabricSerializationType) string {
+	switch meta {
+	case FabricSerializationTypeScopeBegin:
+		return "ScopeBegin"
+	case FabricSerializationTypeScopeEnd:
+		return "ScopeEnd"
+	case FabricSerializationTypeObjectEnd:
+		return "ObjectEnd"
+	case FabricSerializationTypeByteArrayNoCopy:
+		return "ByteArrayNoCopy"
+	}
+
+	name := strings.TrimPrefix((meta & FabricSerializationTypeBaseTypeMask).String(), "FabricSerializationType")
+
+	if IsArrayMeta(meta) {
+		name += "|Array"
+	}
+
+	if IsEmptyMeta(meta) {
+		name += "|Empty"
+	}
+
+	return name
+}
+
+func (d *dumpState) offset() int64 {
+	return d.inner.Size() - int64(d.inner.Len())
+}
+
+func (d *dumpState) line(depth int, pos int64, format string, a ...interface{}) {
+	fmt.Fprintf(&d.out, "%04x %s%s\n", pos, strings.Repeat("  ", depth), fmt.Sprintf(format, a...))
+}
+
+func (d *dumpState) object(depth int, pos int64) error {
+	var header objectHeader
+	if err := d.readObjectHeader(&header); err != nil {
+		return err
+	}
+
+	d.line(depth, pos, "Object size=%v flags=0x%02x", header.Size, header.Flag)
+
+	if size := header.headerSize(); size > sizeOfobjectHeader {
+		d.line(depth+1, pos+1+int64(sizeOfobjectHeader), "ExtendedHeader len=%v", size-sizeOfobjectHeader)
+	}
+
+	if header.Flag&headerFlagsContainsTypeInformation == headerFlagsContainsTypeInformation {
+		pos := d.offset()
+		len, err := d.readCompressedUInt32()
+		if err != nil {
+			return err
+		}
+
+		if err := d.checkAlloc(int64(len), 1); err != nil {
+			return err
+		}
+
+		typeinfo := make([]byte, len)
+		if err := d.ReadBinary(typeinfo); err != nil {
+			return err
+		}
+
+		d.line(depth+1, pos, "TypeInformation len=%v %v", len, hex.EncodeToString(typeinfo))
+	}
+
+	if header.Flag&headerFlagsContainsExtensionData == headerFlagsContainsExtensionData {
+		pos := d.offset()
+		names, err := d.readFieldNames()
+		if err != nil {
+			return err
+		}
+
+		d.line(depth+1, pos, "FieldNames %v", strings.Join(names, " "))
+	}
+
+	pos = d.offset()
+	if err := d.expectTypeMeta(FabricSerializationTypeScopeBegin); err != nil {
+		return err
+	}
+	d.line(depth+1, pos, "ScopeBegin")
+
+	for {
+		pos := d.offset()
+		meta, err := d.readTypeMeta()
+		if err != nil {
+			return err
+		}
+
+		if meta == FabricSerializationTypeScopeEnd {
+			d.line(depth+1, pos, "ScopeEnd")
+			break
+		}
+
+		if header.Flag&headerFlagsOrdinalFields == headerFlagsOrdinalFields {
+			if meta != FabricSerializationTypeUInt32 {
+				return fmt.Errorf("expect field ordinal got %v at 0x%04x", metaName(meta), pos)
+			}
+
+			ordinal, err := d.readCompressedUInt32()
+			if err != nil {
+				return err
+			}
+
+			size, err := d.readCompressedUInt32()
+			if err != nil {
+				return err
+			}
+
+			d.line(depth+2, pos, "Field ordinal=%v len=%v", ordinal, size)
+
+			if err := d.next(depth + 3); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := d.value(depth+2, pos, meta); err != nil {
+			return err
+		}
+	}
+
+	pos = d.offset()
+	if err := d.expectTypeMeta(FabricSerializationTypeObjectEnd); err != nil {
+		return err
+	}
+	d.line(depth, pos, "ObjectEnd")
+
+	return nil
+}
+
+func (d *dumpState) next(depth int) error {
+	pos := d.offset()
+	meta, err := d.readTypeMeta()
+	if err != nil {
+		return err
+	}
+
+	return d.value(depth, pos, meta)
+}
+
+func (d *dumpState) value(depth int, pos int64, meta FabricSerializationType) error {
+	name := metaName(meta)
+
+	// nested values, e.g. a run of pointer metas, are bounded as Unmarshal bounds objects
+	d.decodeState.depth++
+	defer func() { d.decodeState.depth-- }()
+
+	if d.maxDepth > 0 && d.decodeState.depth > d.maxDepth {
+		return fmt.Errorf("nesting exceeds max depth %v at 0x%04x", d.maxDepth, pos)
+	}
+
+	if IsEmptyMeta(meta) {
+		if IsBaseMeta(meta, FabricSerializationTypeBool) {
+			d.line(depth, pos, "Bool %v", meta&FabricSerializationTypeBoolFalseFlag == 0)
+		} else {
+			d.line(depth, pos, "%v", name)
+		}
+		return nil
+	}
+
+	if meta == FabricSerializationTypeObject {
+		return d.object(depth, pos)
+	}
+
+	if IsArrayMeta(meta) {
+		len, err := d.readCompressedUInt32()
+		if err != nil {
+			return err
+		}
+
+		switch meta {
+		case FabricSerializationTypeWString | FabricSerializationTypeArray:
+			str, err := d.readWString(len)
+			if err != nil {
+				return err
+			}
+
+			d.line(depth, pos, "%v len=%v %v", name, len, strconv.Quote(str))
+		case FabricSerializationTypeUChar | FabricSerializationTypeArray,
+			FabricSerializationTypeChar | FabricSerializationTypeArray:
+
+			// each element carries its own meta, printed together as hex
+			body, err := d.byteElems(meta&FabricSerializationTypeBaseTypeMask, len)
+			if err != nil {
+				return err
+			}
+
+			d.line(depth, pos, "%v len=%v %v", name, len, hex.EncodeToString(body))
+		case FabricSerializationTypeByteArrayNoCopy:
+			if err := d.checkAlloc(int64(len), 1); err != nil {
+				return err
+			}
+
+			body := make([]byte, len)
+			if err := d.ReadBinary(body); err != nil {
+				return err
+			}
+
+			d.line(depth, pos, "%v len=%v %v", name, len, hex.EncodeToString(body))
+		default:
+			d.line(depth, pos, "%v len=%v", name, len)
+
+			for i := uint32(0); i < len; i++ {
+				if err := d.next(depth + 1); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	switch meta {
+	case FabricSerializationTypePointer:
+		d.line(depth, pos, "%v", name)
+		return d.next(depth + 1)
+	case FabricSerializationTypeChar:
+		var v int8
+		if err := d.ReadBinary(&v); err != nil {
+			return err
+		}
+		d.line(depth, pos, "%v %v", name, v)
+	case FabricSerializationTypeUChar:
+		var v uint8
+		if err := d.ReadBinary(&v); err != nil {
+			return err
+		}
+		d.line(depth, pos, "%v %v", name, v)
+	case FabricSerializationTypeShort, FabricSerializationTypeInt32, FabricSerializationTypeInt64:
+		v, err := d.readCompressedSigned(metaSize(meta))
+		if err != nil {
+			return err
+		}
+		d.line(depth, pos, "%v %v", name, v)
+	case FabricSerializationTypeUShort, FabricSerializationTypeUInt32, FabricSerializationTypeUInt64:
+		v, err := d.readCompressedUnsigned(metaSize(meta))
+		if err != nil {
+			return err
+		}
+		d.line(depth, pos, "%v %v", name, v)
+	case FabricSerializationTypeDouble:
+		var v float64
+		if err := d.ReadBinary(&v); err != nil {
+			return err
+		}
+		d.line(depth, pos, "%v %v", name, v)
+	case FabricSerializationTypeGuid:
+		var v GUID
+		if err := d.ReadBinary(&v); err != nil {
+			return err
+		}
+		d.line(depth, pos, "%v %v", name, v)
+	default:
+		return fmt.Errorf("unexpected meta %v at 0x%04x", name, pos)
+	}
+
+	return nil
+}
+
+// byteElems reads n uchar or char elements, each its meta followed by the byte or an empty meta for 0
+func (d *dumpState) byteElems(base FabricSerializationType, n uint32) ([]byte, error) {
+	if err := d.checkAlloc(int64(n), 1); err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	for i := uint32(0); i < n; i++ {
+		pos := d.offset()
+		meta, err := d.readTypeMeta()
+		if err != nil {
+			return nil, err
+		}
+
+		if !IsBaseMeta(meta, base) || IsArrayMeta(meta) {
+			return nil, fmt.Errorf("expect %v element got %v at 0x%04x", metaName(base), metaName(meta), pos)
+		}
+
+		if IsEmptyMeta(meta) {
+			body = append(body, 0)
+			continue
+		}
+
+		c, err := d.inner.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		body = append(body, c)
+	}
+
+	return body, nil
+}
+
+func metaSize(meta FabricSerializationType) int {
+	switch meta & FabricSerializationTypeBaseTypeMask {
+	case FabricSerializationTypeShort, FabricSerializationTypeUShort:
+		return 2
+	case FabricSerializationTypeInt32, FabricSerializationTypeUInt32:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// Dump returns a human readable tree of serialized data, one value per line prefixed with its offset
+// the tree parsed so far is returned together with the error if data is malformed or truncated
+// nesting and declared lengths are limited by DefaultMaxDepth and DefaultMaxAllocBytes as with Unmarshal, data may be untrusted
+func Dump(data []byte) (string, error) {
+	d := &dumpState{
+		decodeState: decodeState{inner: bytes.NewReader(data), maxDepth: DefaultMaxDepth, maxAlloc: DefaultMaxAllocBytes},
+	}
+
+	for d.inner.Len() > 0 {
+		if err := d.next(0); err != nil {
+			return d.out.String(), err
+		}
+	}
+
+	return d.out.String(), nil
+}
@@ -0,0 +1,118 @@
+package serialization
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDump(t *testing.T) {
+	type child struct {
+		Ushort uint16
+	}
+
+	data, err := Marshal(&struct {
+		String      string
+		True        bool
+		False       bool
+		Long        int32
+		UlongArray  []uint32
+		Child       child
+		ChildPtr    *child
+		StringArray []string
+	}{
+		String:      "hi",
+		True:        true,
+		Long:        -5,
+		UlongArray:  []uint32{1, 0},
+		Child:       child{3},
+		StringArray: []string{"x"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Dump(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, `0000 Object size=47 flags=0x00
+0009   ScopeBegin
+000a     WString|Array len=2 "hi"
+0010     Bool true
+0011     Bool false
+0012     Int32 -5
+0014     UInt32|Array len=2
+0016       UInt32 1
+0018       UInt32|Empty
+0019     Object size=13 flags=0x00
+0022       ScopeBegin
+0023         UShort 3
+0025       ScopeEnd
+0026     ObjectEnd
+0027     Pointer|Empty
+0028     UInt32 1
+002a     WString|Array len=1 "x"
+002e   ScopeEnd
+002f ObjectEnd
+`, s)
+
+	t.Run("byte arrays", func(t *testing.T) {
+		data, err := Marshal(&struct {
+			Bytes []byte
+			Chars []int8
+			After bool
+		}{
+			Bytes: []byte{0, 0xff},
+			Chars: []int8{-1},
+			After: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := Dump(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// elements carry their own meta, the field after is still in place
+		assert.Contains(t, s, `000a     UChar|Array len=2 00ff
+000f     Char|Array len=1 ff
+0013     Bool true
+`)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		s, err := Dump(data[:0x18])
+		assert.Error(t, err)
+		assert.Contains(t, s, "UInt32 1")
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		// a long run of pointer metas
+		s, err := Dump(bytes.Repeat([]byte{byte(FabricSerializationTypePointer)}, 200000))
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "exceeds max depth")
+		}
+		assert.Less(t, len(s), 4<<20)
+
+		for _, meta := range []FabricSerializationType{
+			FabricSerializationTypeByteArrayNoCopy,
+			FabricSerializationTypeUChar | FabricSerializationTypeArray,
+		} {
+			e := &encodeState{}
+			e.pushBuffer()
+			assert.NoError(t, e.writeTypeMeta(meta))
+			assert.NoError(t, e.writeCompressedUint32(math.MaxUint32))
+
+			_, err := Dump(e.buf.Bytes())
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), "exceed max alloc")
+			}
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package serialization
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var enums = struct {
+	sync.RWMutex
+	valid map[reflect.Type]map[int64]bool
+}{
+	valid: make(map[reflect.Type]map[int64]bool),
+}
+
+// RegisterEnum registers the known constants of a named integer type, the wire encoding is the same as the integer
+// values are checked when marshaling with MarshalOptions.ValidateEnums and unmarshaling with UnmarshalOptions.ValidateEnums
+func RegisterEnum(t reflect.Type, valid []int64) {
+	switch t.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+	default:
+		panic(fmt.Sprintf("enum type %v must be integer", t))
+	}
+
+	set := make(map[int64]bool, len(valid))
+	for _, v := range valid {
+		set[v] = true
+	}
+
+	enums.Lock()
+	defer enums.Unlock()
+
+	enums.valid[t] = set
+}
+
+func validateEnum(rv reflect.Value) error {
+	enums.RLock()
+	set, ok := enums.valid[rv.Type()]
+	enums.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	var v int64
+	switch rv.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+		v = int64(rv.Uint())
+	default:
+		v = rv.Int()
+	}
+
+	if !set[v] {
+		return fmt.Errorf("invalid enum %v value %v", rv.Type(), v)
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package serialization
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testEnum int32
+
+const (
+	testEnumInvalid testEnum = iota
+	testEnumA
+	testEnumB
+)
+
+type testUnsignedEnum uint8
+
+func TestRegisterEnum(t *testing.T) {
+	RegisterEnum(reflect.TypeOf(testEnumA), []int64{int64(testEnumA), int64(testEnumB)})
+	RegisterEnum(reflect.TypeOf(testUnsignedEnum(0)), []int64{1})
+
+	type object struct {
+		Enum     testEnum
+		Unsigned testUnsignedEnum
+		Enums    []testEnum
+	}
+
+	opts := MarshalOptions{ValidateEnums: true}
+
+	t.Run("valid", func(t *testing.T) {
+		from := object{Enum: testEnumB, Unsigned: 1, Enums: []testEnum{testEnumA}}
+		data, err := opts.Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to object
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, from, to)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := opts.Marshal(&object{Enum: 7, Unsigned: 1})
+		assert.EqualError(t, err, "field Enum: invalid enum serialization.testEnum value 7")
+
+		_, err = opts.Marshal(&object{Enum: testEnumA, Unsigned: 2})
+		assert.Error(t, err)
+
+		_, err = opts.Marshal(&object{Enum: testEnumA, Unsigned: 1, Enums: []testEnum{testEnumB, 3}})
+		assert.Error(t, err)
+	})
+
+	t.Run("uninitialized", func(t *testing.T) {
+		_, err := opts.Marshal(&object{Unsigned: 1})
+		assert.EqualError(t, err, "field Enum: invalid enum serialization.testEnum value 0")
+	})
+
+	t.Run("opt-in", func(t *testing.T) {
+		_, err := Marshal(&object{Enum: 7})
+		assert.NoError(t, err)
+	})
+
+	t.Run("unmarshal", func(t *testing.T) {
+		decode := UnmarshalOptions{ValidateEnums: true}
+
+		for _, c := range []struct {
+			from  object
+			valid bool
+		}{
+			{object{Enum: testEnumA, Unsigned: 1, Enums: []testEnum{testEnumB}}, true},
+			{object{Enum: 7, Unsigned: 1}, false},
+			{object{Unsigned: 1}, false}, // empty meta
+			{object{Enum: testEnumA, Unsigned: 1, Enums: []testEnum{3}}, false},
+			{object{Enum: testEnumA, Unsigned: 2}, false},
+		} {
+			data, err := Marshal(&c.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the wire is the same as the integer
+			var plain struct {
+				Enum     int32
+				Unsigned uint8
+				Enums    []int32
+			}
+			assert.NoError(t, Unmarshal(data, &plain))
+
+			var to object
+			err = decode.Unmarshal(data, &to)
+			if c.valid {
+				assert.NoError(t, err)
+				assert.Equal(t, c.from, to)
+			} else if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), "invalid enum")
+			}
+		}
+	})
+
+	t.Run("non integer", func(t *testing.T) {
+		assert.Panics(t, func() {
+			RegisterEnum(reflect.TypeOf(""), nil)
+		})
+	})
+}
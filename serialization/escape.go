@@ -0,0 +1,110 @@
+package serialization
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// EscapeName percent-escapes the ASCII characters reserved in naming uri, e.g. fabric:/app/svc, as Fabric does
+// space, '%', '"', '#', '<', '>', '?', '[', '\', ']', '^', '`', '{', '|', '}' and control characters are escaped as %XX,
+// other characters including non-ASCII are kept as WString carries them as is
+// a string field tagged `fabric:",escape"` is escaped on marshal and unescaped on decode
+func EscapeName(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !isReservedNameChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+
+	return b.String()
+}
+
+// UnescapeName reverses EscapeName, a '%' not followed by two hex digits is kept as is,
+// e.g. fabric:/app/100%
+func UnescapeName(s string) (string, error) {
+	if strings.IndexByte(s, '%') < 0 {
+		return s, nil
+	}
+
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+			continue
+		}
+
+		b.WriteByte(s[i])
+	}
+
+	str := b.String()
+	if !utf8.ValidString(str) {
+		return "", fmt.Errorf("unescaped name %q is not valid UTF-8", str)
+	}
+
+	return str, nil
+}
+
+func isReservedNameChar(c byte) bool {
+	if c < 0x20 || c == 0x7f {
+		return true
+	}
+
+	return strings.IndexByte(" %\"#<>?[\\]^`{|}", c) >= 0
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// escapedValue returns the value written for field
+func escapedValue(f structField) (reflect.Value, error) {
+	if !f.tag.escape {
+		return f.value, nil
+	}
+
+	if f.value.Kind() != reflect.String {
+		return reflect.Value{}, fmt.Errorf("escape field %v must be string", f.name)
+	}
+
+	return reflect.ValueOf(EscapeName(f.value.String())), nil
+}
+
+func (s *decodeState) escapedField(meta FabricSerializationType, f structField) error {
+	if f.value.Kind() != reflect.String {
+		return fmt.Errorf("escape field %v must be string", f.name)
+	}
+
+	var escaped string
+	if err := s.value(meta, reflect.ValueOf(&escaped).Elem()); err != nil {
+		return err
+	}
+
+	str, err := UnescapeName(escaped)
+	if err != nil {
+		return err
+	}
+
+	f.value.SetString(str)
+	return nil
+}
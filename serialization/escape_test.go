@@ -0,0 +1,109 @@
+package serialization
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscapeName(t *testing.T) {
+	cases := map[string]string{
+		"fabric:/app/svc":          "fabric:/app/svc",
+		"fabric:/app/my svc":       "fabric:/app/my%20svc",
+		"fabric:/app/a?b#c":        "fabric:/app/a%3Fb%23c",
+		"fabric:/app/100%":         "fabric:/app/100%25",
+		"fabric:/app/[x]":          "fabric:/app/%5Bx%5D",
+		"fabric:/app/é":            "fabric:/app/é",
+		"fabric:/アプリ/svc|v1":       "fabric:/アプリ/svc%7Cv1",
+		"fabric:/app/a\\b\tc":      "fabric:/app/a%5Cb%09c",
+		"fabric:/app/a+b=c;d,e~f":  "fabric:/app/a+b=c;d,e~f",
+		"fabric:/app/%20 is kept?": "fabric:/app/%2520%20is%20kept%3F",
+	}
+
+	for name, escaped := range cases {
+		assert.Equal(t, escaped, EscapeName(name))
+
+		unescaped, err := UnescapeName(escaped)
+		assert.NoError(t, err)
+		assert.Equal(t, name, unescaped)
+	}
+
+	// names escaped by Fabric, a stray '%' is not an escape
+	unescaped := map[string]string{
+		"fabric:/app/my%20svc%3Fv%3D1": "fabric:/app/my svc?v=1",
+		"fabric:/app/100%":             "fabric:/app/100%",
+		"fabric:/app/%zz%2":            "fabric:/app/%zz%2",
+		"fabric:/app/%c3%a9":           "fabric:/app/é",
+	}
+
+	for escaped, name := range unescaped {
+		str, err := UnescapeName(escaped)
+		assert.NoError(t, err)
+		assert.Equal(t, name, str)
+	}
+
+	_, err := UnescapeName("fabric:/app/%FF")
+	assert.Error(t, err)
+}
+
+func TestEscapedField(t *testing.T) {
+	type object struct {
+		Name  string `fabric:",escape"`
+		Plain string
+	}
+
+	from := object{Name: "fabric:/app/a b?#%", Plain: "a b?#%"}
+
+	for _, opts := range []MarshalOptions{{}, {OrdinalFields: true}} {
+		data, err := opts.Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wire struct {
+			Name  string
+			Plain string
+		}
+		if err := Unmarshal(data, &wire); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "fabric:/app/a%20b%3F%23%25", wire.Name)
+		assert.Equal(t, from.Plain, wire.Plain, "default strings are not escaped")
+
+		var to object
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, from, to)
+	}
+
+	t.Run("stray percent", func(t *testing.T) {
+		data, err := Marshal(&struct{ Name string }{Name: "fabric:/app/100%"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to object
+		assert.NoError(t, Unmarshal(data, &to))
+		assert.Equal(t, "fabric:/app/100%", to.Name)
+	})
+
+	t.Run("invalid utf8", func(t *testing.T) {
+		data, err := Marshal(&struct{ Name string }{Name: "fabric:/%FF"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to object
+		assert.Error(t, Unmarshal(data, &to))
+	})
+
+	t.Run("not string", func(t *testing.T) {
+		_, err := Marshal(&struct {
+			N int32 `fabric:",escape"`
+		}{N: 1})
+		assert.Error(t, err)
+	})
+}
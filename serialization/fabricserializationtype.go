@@ -53,6 +53,7 @@ func IsBaseMeta(meta, base FabricSerializationType) bool {
 	return (meta & FabricSerializationTypeBaseTypeMask) == base
 }
 
+// kindToFabricSerializationType returns the base meta of kind, int and uint are always 64 bit as on 64 bit platforms
 func kindToFabricSerializationType(kind reflect.Kind) FabricSerializationType {
 	switch kind {
 	case reflect.Uint8:
@@ -63,13 +64,13 @@ func kindToFabricSerializationType(kind reflect.Kind) FabricSerializationType {
 		return FabricSerializationTypeUShort
 	case reflect.Uint32:
 		return FabricSerializationTypeUInt32
-	case reflect.Uint64:
+	case reflect.Uint64, reflect.Uint:
 		return FabricSerializationTypeUInt64
 	case reflect.Int16:
 		return FabricSerializationTypeShort
 	case reflect.Int32:
 		return FabricSerializationTypeInt32
-	case reflect.Int64:
+	case reflect.Int64, reflect.Int:
 		return FabricSerializationTypeInt64
 	case reflect.Float32, reflect.Float64:
 		return FabricSerializationTypeDouble
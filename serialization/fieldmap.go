@@ -0,0 +1,30 @@
+package serialization
+
+import (
+	"reflect"
+)
+
+// FieldMap returns the ordinal of each field to its name in the field name table,
+// the C++ name tagged `fabric:",cpp=name_"` if any, e.g. to match a struct against the Fabric IDL
+// embedded structs are flattened as on the wire, nil for non struct types
+func FieldMap(t reflect.Type) map[int]string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields, _, err := splitRest(allFields(reflect.New(t).Elem(), false))
+	if err != nil {
+		return nil
+	}
+
+	m := make(map[int]string, len(fields))
+	for i, f := range fields {
+		m[i] = f.wireName()
+	}
+
+	return m
+}
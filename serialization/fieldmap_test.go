@@ -0,0 +1,54 @@
+package serialization
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFieldMap(t *testing.T) {
+	type object struct {
+		EmbeddedBase
+		PartitionId GUID   `fabric:",cpp=partitionId_"`
+		Version     int64  `fabric:"version,cpp=version_"`
+		Name        string `fabric:"name"`
+		Rest        Raw    `fabric:",rest"`
+	}
+
+	expected := map[int]string{
+		0: "A",
+		1: "B",
+		2: "partitionId_",
+		3: "version_",
+		4: "name",
+	}
+
+	assert.Equal(t, expected, FieldMap(reflect.TypeOf(object{})))
+	assert.Equal(t, expected, FieldMap(reflect.TypeOf(&object{})))
+	assert.Nil(t, FieldMap(reflect.TypeOf(1)))
+
+	t.Run("field name table", func(t *testing.T) {
+		from := object{PartitionId: MustNewGuidV4(), Version: 1, Name: "n"}
+
+		data, err := MarshalOptions{FieldNames: true}.Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dump, err := Dump(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Contains(t, dump, "FieldNames A B partitionId_ version_ name\n")
+
+		// metadata only, the wire is unchanged
+		var to object
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, from, to)
+	})
+}
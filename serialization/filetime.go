@@ -0,0 +1,81 @@
+package serialization
+
+import (
+	"math"
+	"time"
+)
+
+// FileTime is a windows FILETIME, 100ns ticks since 1601-01-01 UTC, marshaled as uint64
+type FileTime uint64
+
+const (
+	// seconds between 1601-01-01 and 1970-01-01
+	fileTimeEpochDelta     = 11644473600
+	fileTimeTicksPerSecond = 10000000
+)
+
+// fileTimeSeconds splits t into seconds since 1601-01-01 and 100ns ticks within the second,
+// computed from t.Unix so it is exact for any t, unlike UnixNano which only covers years 1678 to 2262
+func fileTimeSeconds(t time.Time) (int64, int64, bool) {
+	secs := t.Unix()
+	if secs > math.MaxInt64-fileTimeEpochDelta {
+		return 0, 0, false
+	}
+
+	return secs + fileTimeEpochDelta, int64(t.Nanosecond() / 100), true
+}
+
+// FileTimeFromTime converts t to FileTime truncated to 100ns, times before 1601 are 0 and beyond the FileTime range max
+func FileTimeFromTime(t time.Time) FileTime {
+	secs, ticks, ok := fileTimeSeconds(t)
+	if !ok || secs > math.MaxUint64/fileTimeTicksPerSecond {
+		return math.MaxUint64
+	}
+
+	if secs < 0 {
+		return 0
+	}
+
+	f := uint64(secs) * fileTimeTicksPerSecond
+	if f > math.MaxUint64-uint64(ticks) {
+		return math.MaxUint64
+	}
+
+	return FileTime(f + uint64(ticks))
+}
+
+// Time returns f as UTC time, computed from seconds so dates outside the UnixNano range are exact
+func (f FileTime) Time() time.Time {
+	secs := int64(uint64(f)/fileTimeTicksPerSecond) - fileTimeEpochDelta
+	nsec := int64(uint64(f)%fileTimeTicksPerSecond) * 100
+
+	return time.Unix(secs, nsec).UTC()
+}
+
+// TicksFromTime converts t to Fabric DateTime, int64 100ns ticks since 1601-01-01 UTC, truncated to 100ns
+// ok is false if t is before 1601, e.g. zero time.Time, or beyond the int64 range, ticks is clamped to 0 or max then
+func TicksFromTime(t time.Time) (ticks int64, ok bool) {
+	secs, rem, ok := fileTimeSeconds(t)
+	if !ok || secs > math.MaxInt64/fileTimeTicksPerSecond ||
+		(secs == math.MaxInt64/fileTimeTicksPerSecond && rem > math.MaxInt64%fileTimeTicksPerSecond) {
+		return math.MaxInt64, false
+	}
+
+	if secs < 0 {
+		return 0, false
+	}
+
+	return secs*fileTimeTicksPerSecond + rem, true
+}
+
+// TimeFromTicks is the reverse of TicksFromTime, negative ticks are before 1601
+func TimeFromTicks(ticks int64) time.Time {
+	secs := ticks / fileTimeTicksPerSecond
+	rem := ticks % fileTimeTicksPerSecond
+	if rem < 0 {
+		secs--
+		rem += fileTimeTicksPerSecond
+	}
+
+	return time.Unix(secs-fileTimeEpochDelta, rem*100)
+}
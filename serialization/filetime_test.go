@@ -0,0 +1,97 @@
+package serialization
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileTime(t *testing.T) {
+	cases := []struct {
+		ft FileTime
+		t  time.Time
+	}{
+		{0, time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{864000000000, time.Date(1601, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{116444736000000000, time.Unix(0, 0).UTC()},
+		{132223104000000000, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{132223104000000001, time.Date(2020, 1, 1, 0, 0, 0, 100, time.UTC)},
+		{0xFFFFFFFFFFFFFFFF, time.Date(60056, 5, 28, 5, 36, 10, 955161500, time.UTC)},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.t, c.ft.Time(), "%v", uint64(c.ft))
+		assert.Equal(t, c.ft, FileTimeFromTime(c.t), "%v", c.t)
+	}
+
+	t.Run("truncated to ticks", func(t *testing.T) {
+		assert.Equal(t, FileTime(132223104000000001), FileTimeFromTime(time.Date(2020, 1, 1, 0, 0, 0, 199, time.UTC)))
+	})
+
+	t.Run("before 1601", func(t *testing.T) {
+		assert.Equal(t, FileTime(0), FileTimeFromTime(time.Date(1600, 12, 31, 23, 59, 59, 0, time.UTC)))
+	})
+
+	t.Run("beyond max", func(t *testing.T) {
+		assert.Equal(t, FileTime(math.MaxUint64), FileTimeFromTime(time.Date(60057, 1, 1, 0, 0, 0, 0, time.UTC)))
+	})
+
+	t.Run("marshal as uint64", func(t *testing.T) {
+		type object struct {
+			Time FileTime
+		}
+
+		from := object{Time: FileTimeFromTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))}
+
+		data, err := Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, byte(FabricSerializationTypeUInt64), data[10])
+
+		var to object
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, from, to)
+	})
+}
+
+func TestTicksFromTime(t *testing.T) {
+	for _, c := range []struct {
+		ticks int64
+		t     time.Time
+	}{
+		{0, time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{116444736000000000, time.Unix(0, 0).UTC()},
+		{132223104000000001, time.Date(2020, 1, 1, 0, 0, 0, 100, time.UTC)},
+		// beyond the UnixNano range
+		{283144032000000000, time.Date(2498, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{math.MaxInt64, time.Date(30828, 9, 14, 2, 48, 5, 477580700, time.UTC)},
+	} {
+		ticks, ok := TicksFromTime(c.t)
+		assert.True(t, ok, "%v", c.t)
+		assert.Equal(t, c.ticks, ticks, "%v", c.t)
+		assert.Equal(t, c.t, TimeFromTicks(c.ticks).UTC(), "%v", c.ticks)
+	}
+
+	for _, c := range []struct {
+		ticks int64
+		t     time.Time
+	}{
+		{0, time.Time{}},
+		{0, time.Date(1600, 12, 31, 23, 59, 59, 0, time.UTC)},
+		{math.MaxInt64, time.Date(30828, 9, 14, 2, 48, 5, 477580800, time.UTC)},
+		{math.MaxInt64, time.Unix(math.MaxInt64, 0)},
+	} {
+		ticks, ok := TicksFromTime(c.t)
+		assert.False(t, ok, "%v", c.t)
+		assert.Equal(t, c.ticks, ticks, "%v", c.t)
+	}
+
+	assert.Equal(t, time.Date(1600, 12, 31, 23, 59, 59, 999999900, time.UTC), TimeFromTicks(-1).UTC())
+}
@@ -0,0 +1,193 @@
+package serialization
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// UnmarshalGeneric decodes an object without its Go type into a tree for inspection.
+// Objects are map[string]interface{} keyed by the field name table if present, otherwise fieldN by position or ordinal,
+// arrays are []interface{}, uchar arrays []byte, pointers their target and empty pointers or arrays nil,
+// other values are the Go type of their meta as with interface fields, e.g. int32, string or GUID.
+// Empty fields are omitted with ordinal fields. []string and slices of pointers are a UInt32 count followed by the elements, which appear as separate fields.
+func UnmarshalGeneric(data []byte) (map[string]interface{}, error) {
+	s := &decodeState{inner: bytes.NewReader(data), maxDepth: DefaultMaxDepth, maxAlloc: DefaultMaxAllocBytes}
+
+	meta, err := s.readTypeMeta()
+	if err != nil {
+		return nil, err
+	}
+
+	if meta != FabricSerializationTypeObject {
+		return nil, fmt.Errorf("expect object got %v", meta)
+	}
+
+	return s.genericObject(meta)
+}
+
+func (s *decodeState) genericObject(meta FabricSerializationType) (map[string]interface{}, error) {
+	endPos, flags, names, err := s.readObjectBegin(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := make(map[string]interface{})
+
+	for i := 0; ; i++ {
+		meta, err := s.readTypeMeta()
+		if err != nil {
+			return nil, err
+		}
+
+		if meta == FabricSerializationTypeScopeEnd {
+			break
+		}
+
+		key := "field" + strconv.Itoa(i)
+		if i < len(names) {
+			key = names[i]
+		}
+
+		if flags&headerFlagsOrdinalFields == headerFlagsOrdinalFields {
+			if meta != FabricSerializationTypeUInt32 {
+				return nil, fmt.Errorf("expect field ordinal got %v", meta)
+			}
+
+			ordinal, err := s.readCompressedUInt32()
+			if err != nil {
+				return nil, err
+			}
+
+			size, err := s.readCompressedUInt32()
+			if err != nil {
+				return nil, err
+			}
+
+			start, err := s.inner.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+
+			if meta, err = s.readTypeMeta(); err != nil {
+				return nil, err
+			}
+
+			key = "field" + strconv.Itoa(int(ordinal))
+			if int(ordinal) < len(names) {
+				key = names[ordinal]
+			}
+
+			if obj[key], err = s.generic(meta); err != nil {
+				return nil, err
+			}
+
+			if _, err := s.inner.Seek(start+int64(size), io.SeekStart); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if obj[key], err = s.generic(meta); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.consumeObjectEnd(meta, endPos); err != nil {
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+func (s *decodeState) generic(meta FabricSerializationType) (interface{}, error) {
+	if typ, ok := scalarVariantType(meta); ok {
+		v := reflect.New(typ).Elem()
+		if err := s.value(meta, v); err != nil {
+			return nil, err
+		}
+
+		return v.Interface(), nil
+	}
+
+	if IsBaseMeta(meta, FabricSerializationTypeGuid) {
+		var g GUID
+		if err := g.Unmarshal(meta, s); err != nil {
+			return nil, err
+		}
+
+		return g, nil
+	}
+
+	if IsEmptyMeta(meta) {
+		return nil, nil
+	}
+
+	switch meta {
+	case FabricSerializationTypeObject:
+		return s.genericObject(meta)
+	case FabricSerializationTypePointer:
+		meta, err := s.readTypeMeta()
+		if err != nil {
+			return nil, err
+		}
+
+		return s.generic(meta)
+	}
+
+	if !IsArrayMeta(meta) {
+		return nil, fmt.Errorf("unexpected meta %v", meta)
+	}
+
+	n, err := s.readCompressedUInt32()
+	if err != nil {
+		return nil, err
+	}
+
+	if meta == FabricSerializationTypeByteArrayNoCopy {
+		if int64(n) > int64(s.inner.Len()) {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		b := make([]byte, n)
+		if err := s.ReadBinary(b); err != nil {
+			return nil, err
+		}
+
+		return b, nil
+	}
+
+	var elems []interface{}
+	for i := uint32(0); i < n; i++ {
+		meta, err := s.readTypeMeta()
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := s.generic(meta)
+		if err != nil {
+			return nil, err
+		}
+
+		elems = append(elems, v)
+	}
+
+	if meta == FabricSerializationTypeUChar|FabricSerializationTypeArray {
+		b := make([]byte, len(elems))
+		for i, e := range elems {
+			v, ok := e.(uint8)
+			if !ok {
+				return nil, fmt.Errorf("uchar array element %v expect uint8 got %T", i, e)
+			}
+
+			b[i] = v
+		}
+
+		return b, nil
+	}
+
+	return elems, nil
+}
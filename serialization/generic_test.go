@@ -0,0 +1,145 @@
+package serialization
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnmarshalGeneric(t *testing.T) {
+	type inner struct {
+		X string
+		Y []int32
+	}
+
+	type object struct {
+		A int32
+		B string
+		C bool
+		D []byte
+		E *inner
+		F *inner
+		G GUID
+		H []inner
+		I uint64
+	}
+
+	g := MustNewGuidV4()
+
+	from := object{
+		A: -1,
+		B: "b",
+		C: true,
+		D: []byte{0, 2},
+		E: &inner{X: "x", Y: []int32{3, 4}},
+		G: g,
+		H: []inner{{X: "h"}},
+	}
+
+	innerTree := func(x string, y interface{}) map[string]interface{} {
+		return map[string]interface{}{"field0": x, "field1": y}
+	}
+
+	expected := map[string]interface{}{
+		"field0": int32(-1),
+		"field1": "b",
+		"field2": true,
+		"field3": []byte{0, 2},
+		"field4": innerTree("x", []interface{}{int32(3), int32(4)}),
+		"field5": nil,
+		"field6": g,
+		"field7": []interface{}{innerTree("h", nil)},
+		"field8": uint64(0),
+	}
+
+	data, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := UnmarshalGeneric(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expected, tree)
+
+	t.Run("ordinal fields", func(t *testing.T) {
+		data, err := MarshalOptions{OrdinalFields: true}.Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tree, err := UnmarshalGeneric(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// empty fields are omitted
+		delete(expected, "field5")
+		delete(expected, "field8")
+		expected["field7"] = []interface{}{map[string]interface{}{"field0": "h"}}
+		assert.Equal(t, expected, tree)
+	})
+
+	t.Run("field names", func(t *testing.T) {
+		data, err := MarshalOptions{FieldNames: true}.Marshal(&inner{X: "x"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tree, err := UnmarshalGeneric(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, map[string]interface{}{"X": "x", "Y": nil}, tree)
+	})
+
+	t.Run("not object", func(t *testing.T) {
+		_, err := UnmarshalGeneric([]byte{byte(FabricSerializationTypeInt32), 0x01})
+		assert.Error(t, err)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		data, err := Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = UnmarshalGeneric(data[:len(data)-4])
+		assert.Error(t, err)
+	})
+
+	t.Run("malformed uchar array", func(t *testing.T) {
+		type bytesObject struct {
+			D []byte
+		}
+
+		for _, c := range []struct {
+			name   string
+			d      []byte
+			meta   FabricSerializationType
+			expect string
+		}{
+			{"int32 element", []byte{1, 2}, FabricSerializationTypeInt32, "uchar array element 1 expect uint8 got int32"},
+			{"empty object element", []byte{1, 0}, FabricSerializationTypeObject | FabricSerializationTypeEmptyValueBit, "uchar array element 1 expect uint8 got <nil>"},
+		} {
+			t.Run(c.name, func(t *testing.T) {
+				data, err := Marshal(&bytesObject{D: c.d})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// 0x84 n, 0x04 1, then the second element meta
+				assert.Equal(t, byte(FabricSerializationTypeUChar|FabricSerializationTypeArray), data[10])
+				data[14] = byte(c.meta)
+
+				_, err = UnmarshalGeneric(data)
+				if assert.Error(t, err) {
+					assert.Equal(t, c.expect, err.Error())
+				}
+			})
+		}
+	})
+}
@@ -41,11 +41,7 @@ func NewGuidV4() (GUID, error) {
 		return GUID{}, err
 	}
 
-	var g GUID
-	g.Data1 = binary.LittleEndian.Uint32(b[0:4])
-	g.Data2 = binary.LittleEndian.Uint16(b[4:6])
-	g.Data3 = binary.LittleEndian.Uint16(b[6:8])
-	copy(g.Data4[:], b[8:16])
+	g := GUIDFromBytes(b)
 
 	g.Data3 = (g.Data3 & 0x0fff) | 0x4000   // Version 4 (randomly generated)
 	g.Data4[0] = (g.Data4[0] & 0x3f) | 0x80 // RFC4122 variant
@@ -104,6 +100,26 @@ func GUIDFromString(s string) (GUID, error) {
 	return g, nil
 }
 
+// GUIDFromBytes returns the GUID of b in System.Guid byte layout, Data1, Data2 and Data3 little-endian
+func GUIDFromBytes(b [16]byte) GUID {
+	var g GUID
+	g.Data1 = binary.LittleEndian.Uint32(b[0:4])
+	g.Data2 = binary.LittleEndian.Uint16(b[4:6])
+	g.Data3 = binary.LittleEndian.Uint16(b[6:8])
+	copy(g.Data4[:], b[8:16])
+	return g
+}
+
+// Bytes returns g in System.Guid byte layout, the same 16 bytes written on the wire
+func (g GUID) Bytes() [16]byte {
+	var b [16]byte
+	binary.LittleEndian.PutUint32(b[0:4], g.Data1)
+	binary.LittleEndian.PutUint16(b[4:6], g.Data2)
+	binary.LittleEndian.PutUint16(b[6:8], g.Data3)
+	copy(b[8:16], g.Data4[:])
+	return b
+}
+
 var _ CustomMarshaler = (*GUID)(nil)
 
 func (g *GUID) Marshal(s Encoder) error {
@@ -124,5 +140,10 @@ func (g *GUID) Unmarshal(meta FabricSerializationType, s Decoder) error {
 		return fmt.Errorf("expect guid get %v", meta)
 	}
 
+	if IsEmptyMeta(meta) {
+		*g = GUID{}
+		return nil
+	}
+
 	return s.ReadBinary(g)
 }
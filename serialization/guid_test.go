@@ -0,0 +1,39 @@
+package serialization
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGUIDBytes(t *testing.T) {
+	// System.Guid.Parse("14e4f405-ba48-4b51-8084-0b6c5523f29e").ToByteArray()
+	b := [16]byte{0x05, 0xf4, 0xe4, 0x14, 0x48, 0xba, 0x51, 0x4b, 0x80, 0x84, 0x0b, 0x6c, 0x55, 0x23, 0xf2, 0x9e}
+
+	g := GUIDFromBytes(b)
+	assert.Equal(t, "14e4f405-ba48-4b51-8084-0b6c5523f29e", g.String())
+	assert.Equal(t, b, g.Bytes())
+
+	data, err := Marshal(&struct{ Id GUID }{Id: g})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, append([]byte{byte(FabricSerializationTypeGuid)}, b[:]...), data[10:len(data)-2])
+
+	var to struct{ Id GUID }
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, b, to.Id.Bytes())
+
+	t.Run("empty", func(t *testing.T) {
+		data, err := Marshal(&struct{ Id GUID }{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []byte{byte(FabricSerializationTypeGuid | FabricSerializationTypeEmptyValueBit)}, data[10:len(data)-2])
+	})
+}
@@ -0,0 +1,234 @@
+package serialization
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// FieldError is a field that failed to decode in lenient mode
+type FieldError struct {
+	Path   string // dot separated field names from the root object
+	Offset int64  // offset of the field value in data
+	Err    error
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("field %v at 0x%04x: %v", e.Path, e.Offset, e.Err)
+}
+
+func (e FieldError) Unwrap() error {
+	return e.Err
+}
+
+// FieldErrors is returned by lenient Unmarshal when some fields were skipped, all other fields are decoded
+type FieldErrors []FieldError
+
+func (e FieldErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+
+	return fmt.Sprintf("%v field errors, first %v", len(e), e[0].Error())
+}
+
+// decodeField decodes a struct field, in lenient mode a failure is recorded and the value is skipped
+// skipTo is where the next field starts if known, otherwise the value is skipped by its meta
+func (s *decodeState) decodeField(meta FabricSerializationType, f structField, skipTo int64) error {
+	if !s.lenient {
+		if !s.strict {
+			return s.field(meta, f)
+		}
+
+		s.path = append(s.path, f.name)
+		defer func() { s.path = s.path[:len(s.path)-1] }()
+
+		return s.field(meta, f)
+	}
+
+	start, err := s.inner.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	depth := s.depth
+	s.path = append(s.path, f.name)
+	err = s.field(meta, f)
+	path := strings.Join(s.path, ".")
+	s.path = s.path[:len(s.path)-1]
+
+	if err == nil {
+		return nil
+	}
+
+	s.depth = depth
+	f.value.Set(reflect.Zero(f.value.Type()))
+
+	if skipTo >= 0 {
+		if _, err := s.inner.Seek(skipTo, io.SeekStart); err != nil {
+			return err
+		}
+	} else {
+		if _, err := s.inner.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+
+		// the rest of the message cannot be located if the value is not skipped
+		if serr := s.skipValue(meta, f.value.Type()); serr != nil {
+			return fmt.Errorf("field %v at 0x%04x: %v, cannot skip value: %w", path, start, err, serr)
+		}
+	}
+
+	s.fieldErrors = append(s.fieldErrors, FieldError{Path: path, Offset: start, Err: err})
+	return nil
+}
+
+// skipValue is skip for a value decoded into typ, slices of strings, pointers or interfaces are written
+// as a UInt32 count followed by the elements, which skip alone would take as a bare UInt32
+func (s *decodeState) skipValue(meta FabricSerializationType, typ reflect.Type) error {
+	switch {
+	case meta == FabricSerializationTypePointer && typ.Kind() == reflect.Ptr:
+		elmMeta, err := s.readTypeMeta()
+		if err != nil {
+			return err
+		}
+
+		return s.skipValue(elmMeta, typ.Elem())
+	case meta == FabricSerializationTypeUInt32 && isCountedArray(typ):
+		s.depth++
+		defer func() { s.depth-- }()
+
+		if s.maxDepth > 0 && s.depth > s.maxDepth {
+			return fmt.Errorf("object nesting exceeds max depth %v", s.maxDepth)
+		}
+
+		n, err := s.readCompressedUInt32()
+		if err != nil {
+			return err
+		}
+
+		for i := uint32(0); i < n; i++ {
+			elmMeta, err := s.readTypeMeta()
+			if err != nil {
+				return err
+			}
+
+			if err := s.skipValue(elmMeta, typ.Elem()); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return s.skip(meta)
+}
+
+// isCountedArray reports whether typ is written as a UInt32 count and its elements
+func isCountedArray(typ reflect.Type) bool {
+	if typ == stringSetType {
+		return true
+	}
+
+	if typ.Kind() != reflect.Slice && typ.Kind() != reflect.Array {
+		return false
+	}
+
+	switch elemKind(typ.Elem()) {
+	case reflect.String, reflect.Ptr, reflect.Interface:
+		return true
+	}
+
+	return false
+}
+
+func (s *decodeState) discard(n int64) error {
+	if _, err := io.CopyN(io.Discard, s.inner, n); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// skip consumes the value following meta without decoding it
+func (s *decodeState) skip(meta FabricSerializationType) error {
+	if IsEmptyMeta(meta) {
+		return nil
+	}
+
+	if meta == FabricSerializationTypeObject {
+		var header objectHeader
+		if err := binary.Read(s.inner, binary.LittleEndian, &header); err != nil {
+			return err
+		}
+
+		if header.Size < sizeOfobjectHeader {
+			return fmt.Errorf("object size %v too small", header.Size)
+		}
+
+		return s.discard(int64(header.Size - sizeOfobjectHeader))
+	}
+
+	s.depth++
+	defer func() { s.depth-- }()
+
+	if s.maxDepth > 0 && s.depth > s.maxDepth {
+		return fmt.Errorf("object nesting exceeds max depth %v", s.maxDepth)
+	}
+
+	if IsArrayMeta(meta) {
+		n, err := s.readCompressedUInt32()
+		if err != nil {
+			return err
+		}
+
+		switch meta {
+		case FabricSerializationTypeWString | FabricSerializationTypeArray:
+			return s.discard(int64(n) * 2)
+		case FabricSerializationTypeByteArrayNoCopy:
+			return s.discard(int64(n))
+		}
+
+		for i := uint32(0); i < n; i++ {
+			if err := s.next(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	switch meta {
+	case FabricSerializationTypePointer:
+		return s.next()
+	case FabricSerializationTypeChar, FabricSerializationTypeUChar:
+		return s.discard(1)
+	case FabricSerializationTypeShort, FabricSerializationTypeInt32, FabricSerializationTypeInt64:
+		_, err := s.readCompressedSigned(metaSize(meta))
+		return err
+	case FabricSerializationTypeUShort, FabricSerializationTypeUInt32, FabricSerializationTypeUInt64:
+		_, err := s.readCompressedUnsigned(metaSize(meta))
+		return err
+	case FabricSerializationTypeDouble:
+		return s.discard(8)
+	case FabricSerializationTypeGuid:
+		return s.discard(16)
+	default:
+		return fmt.Errorf("cannot skip meta %v", meta)
+	}
+}
+
+func (s *decodeState) next() error {
+	meta, err := s.readTypeMeta()
+	if err != nil {
+		return err
+	}
+
+	return s.skip(meta)
+}
@@ -0,0 +1,262 @@
+package serialization
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLenientUnmarshal(t *testing.T) {
+	type inner struct {
+		X string
+		Y int32
+	}
+
+	type object struct {
+		A int32
+		B string
+		C []int32
+		D inner
+		E string
+	}
+
+	// same layout as object, but B and D.X carry values of the wrong type
+	type corruptInner struct {
+		X []GUID
+		Y int32
+	}
+
+	type corrupt struct {
+		A int32
+		B int64
+		C []int32
+		D corruptInner
+		E string
+	}
+
+	from := corrupt{
+		A: 1,
+		B: 2,
+		C: []int32{3, 4},
+		D: corruptInner{X: []GUID{MustNewGuidV4()}, Y: 5},
+		E: "6",
+	}
+
+	expected := object{
+		A: 1,
+		C: []int32{3, 4},
+		D: inner{Y: 5},
+		E: "6",
+	}
+
+	for _, opts := range []MarshalOptions{{}, {OrdinalFields: true}} {
+		data, err := opts.Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var strict object
+		assert.Error(t, Unmarshal(data, &strict))
+
+		to := object{B: "stale"}
+		err = UnmarshalOptions{Lenient: true}.Unmarshal(data, &to)
+
+		assert.Equal(t, expected, to)
+
+		fieldErrors, ok := err.(FieldErrors)
+		if !assert.True(t, ok, "%v", err) {
+			continue
+		}
+
+		assert.Len(t, fieldErrors, 2)
+		assert.Equal(t, "B", fieldErrors[0].Path)
+		assert.Equal(t, "D.X", fieldErrors[1].Path)
+		assert.Less(t, fieldErrors[0].Offset, fieldErrors[1].Offset)
+	}
+
+	t.Run("unskippable", func(t *testing.T) {
+		data, err := Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to object
+		err = UnmarshalOptions{Lenient: true}.Unmarshal(data[:len(data)-4], &to)
+		assert.Error(t, err)
+		_, ok := err.(FieldErrors)
+		assert.False(t, ok)
+		assert.Contains(t, err.Error(), "cannot skip value")
+	})
+
+	t.Run("counted array", func(t *testing.T) {
+		type older struct {
+			A int32
+			B []string
+			C string
+		}
+
+		type newer struct {
+			A int32
+			B []*inner
+			C string
+		}
+
+		for _, c := range []struct {
+			from interface{}
+			to   interface{}
+		}{
+			{&older{A: 1, B: []string{"x", "y"}, C: "c"}, &newer{A: 1, C: "c"}},
+			{&newer{A: 1, B: []*inner{{X: "x"}, {Y: 2}}, C: "c"}, &older{A: 1, C: "c"}},
+		} {
+			data, err := Marshal(c.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			to := reflect.New(reflect.TypeOf(c.to).Elem()).Interface()
+			err = UnmarshalOptions{Lenient: true}.Unmarshal(data, to)
+			assert.Equal(t, c.to, to)
+
+			fieldErrors, ok := err.(FieldErrors)
+			if assert.True(t, ok, "%v", err) && assert.Len(t, fieldErrors, 1) {
+				assert.Equal(t, "B", fieldErrors[0].Path)
+			}
+		}
+	})
+
+	t.Run("trailing fields", func(t *testing.T) {
+		type older struct {
+			A int32
+		}
+
+		type newer struct {
+			A int32
+			B []string
+			C *inner
+			D interface{}
+			E map[string]inner
+		}
+
+		data, err := Marshal(&newer{
+			A: 1,
+			B: []string{"b"},
+			C: &inner{X: "c"},
+			D: int64(2),
+			E: map[string]inner{"e": {Y: 3}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, opts := range []UnmarshalOptions{{}, {Lenient: true}} {
+			var to older
+			assert.NoError(t, opts.Unmarshal(data, &to))
+			assert.Equal(t, older{A: 1}, to)
+		}
+	})
+}
+
+func TestOlderMessage(t *testing.T) {
+	type older struct {
+		A int32
+		B string
+	}
+
+	type newer struct {
+		A int32
+		B string
+		C []int32
+		D *older
+	}
+
+	data, err := Marshal(&older{A: 1, B: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range []UnmarshalOptions{{}, {Lenient: true}, {Strict: true}} {
+		var to newer
+		assert.NoError(t, opts.Unmarshal(data, &to))
+		assert.Equal(t, newer{A: 1, B: "b"}, to)
+	}
+
+	// fields the message does not have are left unchanged
+	to := newer{A: 5, C: []int32{3}}
+	assert.NoError(t, Unmarshal(data, &to))
+	assert.Equal(t, newer{A: 1, B: "b", C: []int32{3}}, to)
+}
+
+func TestStrictUnmarshal(t *testing.T) {
+	type inner struct {
+		X int32
+	}
+
+	type object struct {
+		A bool
+		B inner
+	}
+
+	type wide struct {
+		X int64
+	}
+
+	type wire struct {
+		A bool
+		B wide
+	}
+
+	type text struct {
+		X string
+	}
+
+	type textWire struct {
+		A bool
+		B text
+	}
+
+	strict := UnmarshalOptions{Strict: true}
+
+	t.Run("match", func(t *testing.T) {
+		data, err := Marshal(&object{A: false, B: inner{X: 1}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to object
+		assert.NoError(t, strict.Unmarshal(data, &to))
+		assert.Equal(t, object{B: inner{X: 1}}, to)
+	})
+
+	for _, c := range []struct {
+		name   string
+		from   interface{}
+		expect string
+	}{
+		{"wider int", &wire{B: wide{X: 1}}, "field B.X int32 expect Int32 got Int64"},
+		{"empty wstring", &textWire{}, "field B.X int32 expect Int32 got WString|Array|Empty"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := Marshal(c.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var to object
+			err = strict.Unmarshal(data, &to)
+			if assert.Error(t, err) {
+				assert.Equal(t, c.expect, err.Error())
+			}
+		})
+	}
+
+	t.Run("not strict", func(t *testing.T) {
+		data, err := Marshal(&textWire{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to object
+		assert.NoError(t, Unmarshal(data, &to))
+	})
+}
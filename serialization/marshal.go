@@ -4,13 +4,99 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"unicode/utf16"
 )
 
 type encodeState struct {
 	bufStack []*bytes.Buffer
 	buf      *bytes.Buffer
+	opts     MarshalOptions
+
+	// pointers being marshaled on the current path, a pointer seen again is a cycle
+	visiting map[pointerKey]struct{}
+
+	// popped buffers reused by pushBuffer
+	free []*bytes.Buffer
+
+	// depth of objects being marshaled, limited by maxDepth if > 0
+	depth    int
+	maxDepth int
+
+	fieldStack
+}
+
+// buffers grown beyond this by a large message are dropped instead of kept in the pool
+const maxPooledBufferSize = 64 * 1024
+
+var encodeStatePool = sync.Pool{
+	New: func() interface{} {
+		return &encodeState{}
+	},
+}
+
+func newEncodeState(opts MarshalOptions) *encodeState {
+	s := encodeStatePool.Get().(*encodeState)
+	s.opts = opts
+
+	s.maxDepth = opts.MaxDepth
+	if s.maxDepth == 0 {
+		s.maxDepth = DefaultMaxDepth
+	}
+
+	return s
+}
+
+// release resets s and returns it to the pool, s must not be used after
+func (s *encodeState) release() {
+	for i := range s.bufStack {
+		s.bufStack[i] = nil
+	}
+
+	s.bufStack = s.bufStack[:0]
+	s.buf = nil
+	s.opts = MarshalOptions{}
+	s.depth = 0
+
+	for k := range s.visiting {
+		delete(s.visiting, k)
+	}
+
+	encodeStatePool.Put(s)
+}
+
+// pointerKey includes the type, a struct and its first field share an address
+type pointerKey struct {
+	typ reflect.Type
+	ptr uintptr
+}
+
+// enter marks ptr as being marshaled until leave is called, and fails if it already is
+func (s *encodeState) enter(ptr reflect.Value) (leave func(), err error) {
+	key := pointerKey{typ: ptr.Type(), ptr: ptr.Pointer()}
+	if _, ok := s.visiting[key]; ok {
+		return nil, fmt.Errorf("cycle detected at %v %#x", ptr.Type(), key.ptr)
+	}
+
+	if s.visiting == nil {
+		s.visiting = make(map[pointerKey]struct{})
+	}
+
+	s.visiting[key] = struct{}{}
+	return func() { delete(s.visiting, key) }, nil
+}
+
+// byteOrder of fixed size values, little endian unless MarshalOptions.BigEndian
+func (s *encodeState) byteOrder() binary.ByteOrder {
+	if s.opts.BigEndian {
+		return binary.BigEndian
+	}
+
+	return binary.LittleEndian
 }
 
 func (s *encodeState) WriteTypeMeta(t FabricSerializationType) error {
@@ -18,7 +104,7 @@ func (s *encodeState) WriteTypeMeta(t FabricSerializationType) error {
 }
 
 func (s *encodeState) WriteBinary(v interface{}) error {
-	return binary.Write(s.buf, binary.LittleEndian, v)
+	return binary.Write(s.buf, s.byteOrder(), v)
 }
 
 func (s *encodeState) WriteCompressedUInt32(v uint32) error {
@@ -26,11 +112,29 @@ func (s *encodeState) WriteCompressedUInt32(v uint32) error {
 }
 
 func (s *encodeState) pushBuffer() {
-	buf := bytes.NewBuffer(nil)
+	var buf *bytes.Buffer
+	if n := len(s.free); n > 0 {
+		buf = s.free[n-1]
+		s.free[n-1] = nil
+		s.free = s.free[:n-1]
+	} else {
+		buf = bytes.NewBuffer(nil)
+	}
+
 	s.bufStack = append(s.bufStack, buf)
 	s.buf = buf
 }
 
+// releaseBuffer makes a popped buffer available to pushBuffer once its bytes are copied out
+func (s *encodeState) releaseBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+
+	buf.Reset()
+	s.free = append(s.free, buf)
+}
+
 func (s *encodeState) popBuffer() *bytes.Buffer {
 	n := len(s.bufStack) - 1
 	top := s.bufStack[n]
@@ -46,7 +150,9 @@ func (s *encodeState) objectScopeBegin() error {
 	return nil
 }
 
-func (s *encodeState) objectScopeEnd() error {
+// objectScopeEnd writes the object, with type information in header if typeinfo is not empty
+// and field name table if fieldNames is not nil
+func (s *encodeState) objectScopeEnd(typeinfo []byte, fieldNames []string) error {
 	objbuf := s.popBuffer()
 
 	err := s.writeTypeMeta(FabricSerializationTypeObject)
@@ -55,10 +161,47 @@ func (s *encodeState) objectScopeEnd() error {
 	}
 
 	var objectheader objectHeader
-	objectheader.Size = uint32(objbuf.Len()) + 3 + sizeOfobjectHeader
+
+	// type information and field name table follow the header
+	s.pushBuffer()
+
+	if len(typeinfo) > 0 {
+		objectheader.Flag |= headerFlagsContainsTypeInformation
+
+		if err := s.writeCompressedUint32(uint32(len(typeinfo))); err != nil {
+			s.popBuffer()
+			return err
+		}
+
+		s.buf.Write(typeinfo)
+	}
+
+	if s.opts.OrdinalFields {
+		objectheader.Flag |= headerFlagsOrdinalFields
+	}
+
+	if fieldNames != nil {
+		objectheader.Flag |= headerFlagsContainsExtensionData
+
+		if err := s.writeFieldNames(fieldNames); err != nil {
+			s.popBuffer()
+			return err
+		}
+	}
+
+	hs := s.popBuffer()
+	defer s.releaseBuffer(hs)
+	defer s.releaseBuffer(objbuf)
+
+	objectheader.Size = uint32(objbuf.Len()) + 3 + sizeOfobjectHeader + uint32(hs.Len())
 	// 3 == FabricSerializationTypeScopeBegin + FabricSerializationTypeScopeEnd + FabricSerializationTypeObjectEnd
 
-	err = binary.Write(s.buf, binary.LittleEndian, &objectheader)
+	err = binary.Write(s.buf, s.byteOrder(), &objectheader)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.buf.Write(hs.Bytes())
 	if err != nil {
 		return err
 	}
@@ -101,7 +244,7 @@ func (s *encodeState) writeEmpty(rv reflect.Value) error {
 
 	case reflect.Uint8, reflect.Int8, reflect.Uint16, reflect.Uint32,
 		reflect.Uint64, reflect.Int16, reflect.Int32, reflect.Int64,
-		reflect.Float32, reflect.Float64:
+		reflect.Uint, reflect.Int, reflect.Float32, reflect.Float64:
 
 		basetyp := kindToFabricSerializationType(rv.Kind())
 
@@ -114,36 +257,258 @@ func (s *encodeState) writeEmpty(rv reflect.Value) error {
 		return s.writeTypeMeta(FabricSerializationTypeEmptyValueBit | FabricSerializationTypeArray | FabricSerializationTypeWString)
 	case reflect.Ptr:
 		return s.writeTypeMeta(FabricSerializationTypeEmptyValueBit | FabricSerializationTypePointer)
-	case reflect.Slice:
+	case reflect.Slice, reflect.Array:
 		elmTyp := rv.Type().Elem()
-		switch elmTyp.Kind() {
-		case reflect.String, reflect.Ptr:
+		switch elemKind(elmTyp) {
+		case reflect.String, reflect.Ptr, reflect.Interface:
 			return s.writeTypeMeta(FabricSerializationTypeEmptyValueBit | FabricSerializationTypeUInt32)
 		case reflect.Struct:
 			return s.writeTypeMeta(FabricSerializationTypeEmptyValueBit | FabricSerializationTypeObject | FabricSerializationTypeArray)
 		default:
-			basetyp := kindToFabricSerializationType(elmTyp.Kind())
+			basetyp := kindToFabricSerializationType(elemKind(elmTyp))
 
 			if basetyp == FabricSerializationTypeNotAMeta {
-				return fmt.Errorf("unsupported marshal empty slice type %v", elmTyp)
+				return &UnsupportedTypeError{Type: elmTyp}
 			}
 
 			return s.writeTypeMeta(FabricSerializationTypeEmptyValueBit | basetyp | FabricSerializationTypeArray)
 		}
 	case reflect.Map:
-		return s.writeTypeMeta(FabricSerializationTypeEmptyValueBit | FabricSerializationTypeArray)
+		if rv.Type() == stringSetType {
+			return s.writeEmpty(reflect.ValueOf([]string(nil)))
+		}
+
+		// same element meta as entries written by mapEntries
+		return s.writeTypeMeta(FabricSerializationTypeEmptyValueBit | FabricSerializationTypeObject | FabricSerializationTypeArray)
+	case reflect.Struct:
+		return s.writeTypeMeta(FabricSerializationTypeEmptyValueBit | FabricSerializationTypeObject)
 	default:
 	}
 
-	return fmt.Errorf("unsupported marshal empty type %v", rv.String())
+	return &UnsupportedTypeError{Type: rv.Type()}
 }
 
 func (s *encodeState) writeCompressedUint32(value uint32) error {
 	return s.writeCompressedUnsigned(binary.Size(uint32(1)), uint64(value))
 }
 
+// writeCompressedCount is writeCompressedUint32 but writes 0x00 for zero,
+// for values not preceded by a type meta which would carry the empty bit
+func (s *encodeState) writeCompressedCount(value uint32) error {
+	if value == 0 {
+		return s.buf.WriteByte(0)
+	}
+
+	return s.writeCompressedUint32(value)
+}
+
+func (s *encodeState) object(rv reflect.Value, typeinfo []byte) error {
+	if err := s.objectScopeBegin(); err != nil {
+		return err
+	}
+
+	fields, start := s.push(rv, false)
+	defer s.pop(start)
+
+	return s.objectFields(fields, typeinfo)
+}
+
+// objectFields writes fields as an object, scope is begun by caller
+func (s *encodeState) objectFields(fields []structField, typeinfo []byte) error {
+	s.depth++
+	defer func() { s.depth-- }()
+
+	if s.maxDepth > 0 && s.depth > s.maxDepth {
+		return fmt.Errorf("object nesting exceeds max depth %v", s.maxDepth)
+	}
+
+	fields, rest, err := splitRest(fields)
+	if err != nil {
+		return err
+	}
+
+	var fieldNames []string
+	if s.opts.FieldNames {
+		fieldNames = make([]string, 0, len(fields))
+	}
+
+	for i, field := range fields {
+		if fieldNames != nil {
+			fieldNames = append(fieldNames, field.wireName())
+		}
+
+		if err := s.field(i, field); err != nil {
+			return marshalErrorAt(err, field.name)
+		}
+	}
+
+	if rest != nil {
+		if _, err := s.buf.Write(rest.value.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return s.objectScopeEnd(typeinfo, fieldNames)
+}
+
+func (s *encodeState) field(ordinal int, field structField) error {
+	if field.tag.required && (field.value.Kind() == reflect.Ptr || field.value.Kind() == reflect.Interface) && field.value.IsNil() {
+		return fmt.Errorf("required %v is nil", field.value.Type())
+	}
+
+	if s.opts.OrdinalFields {
+		return s.ordinalField(ordinal, field)
+	}
+
+	v, err := escapedValue(field)
+	if err != nil {
+		return err
+	}
+
+	if field.tag.present && v.Kind() == reflect.String && v.Len() == 0 {
+		if err := s.writeTypeMeta(FabricSerializationTypeWString | FabricSerializationTypeArray); err != nil {
+			return err
+		}
+
+		return s.writeCompressedCount(0)
+	}
+
+	return s.value(v)
+}
+
+// ordinalField writes UInt32 meta, ordinal and byte length before the field value, zero value is omitted
+func (s *encodeState) ordinalField(ordinal int, field structField) error {
+	if field.value.IsZero() {
+		return nil
+	}
+
+	v, err := escapedValue(field)
+	if err != nil {
+		return err
+	}
+
+	s.pushBuffer()
+	err = s.value(v)
+	buf := s.popBuffer()
+	defer s.releaseBuffer(buf)
+
+	if err != nil {
+		return err
+	}
+
+	if err := s.writeTypeMeta(FabricSerializationTypeUInt32); err != nil {
+		return err
+	}
+
+	if err := s.writeCompressedCount(uint32(ordinal)); err != nil {
+		return err
+	}
+
+	if err := s.writeCompressedCount(uint32(buf.Len())); err != nil {
+		return err
+	}
+
+	_, err = s.buf.Write(buf.Bytes())
+	return err
+}
+
+// writeFieldNames writes name table, count followed by each name as wchar count and wchars
+func (s *encodeState) writeFieldNames(names []string) error {
+	if err := s.writeCompressedCount(uint32(len(names))); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		str := utf16.Encode([]rune(name))
+		if err := s.writeCompressedCount(uint32(len(str))); err != nil {
+			return err
+		}
+
+		if err := binary.Write(s.buf, s.byteOrder(), str); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// variant writes an interface value as pointer to object carrying its registered type id
+// scalars, e.g. int32 or string, are written as plain values and Raw verbatim, other unregistered types fail
+func (s *encodeState) variant(rv reflect.Value) error {
+	if rv.IsNil() {
+		return s.writeTypeMeta(FabricSerializationTypeEmptyValueBit | FabricSerializationTypePointer)
+	}
+
+	if elem := rv.Elem(); elem.Type() == rawType || isScalarVariant(elem.Type()) {
+		return s.value(elem)
+	}
+
+	if elem := rv.Elem(); elem.Kind() == reflect.Ptr && !elem.IsNil() {
+		leave, err := s.enter(elem)
+		if err != nil {
+			return err
+		}
+		defer leave()
+	}
+
+	obj := reflect.Indirect(rv.Elem())
+	typeinfo, ok := registeredTypeInfo(obj.Type())
+	if !ok {
+		return fmt.Errorf("type %v not registered", obj.Type())
+	}
+
+	if !obj.CanAddr() {
+		// value held by interface, copy to make fields settable
+		cp := reflect.New(obj.Type()).Elem()
+		cp.Set(obj)
+		obj = cp
+	}
+
+	if err := s.writeTypeMeta(FabricSerializationTypePointer); err != nil {
+		return err
+	}
+
+	return s.object(obj, typeinfo)
+}
+
 func (s *encodeState) value(rv reflect.Value) error {
+	if s.opts.ValidateEnums {
+		if err := validateEnum(rv); err != nil {
+			return err
+		}
+	}
+
+	if isTicksType(rv.Type()) {
+		ticks, err := toTicks(rv)
+		if err != nil {
+			return err
+		}
+
+		rv = reflect.ValueOf(ticks)
+	}
 
+	if rv.Type() == rawType {
+		return s.raw(rv)
+	}
+
+	if s.opts.Canonical && (rv.Kind() == reflect.Float32 || rv.Kind() == reflect.Float64) {
+		f := rv.Float()
+		if math.IsNaN(f) {
+			return fmt.Errorf("canonical marshal does not accept NaN")
+		}
+
+		// -0 is written as 0
+		if f == 0 {
+			return s.writeEmpty(rv)
+		}
+	}
+
+	// interface, e.g. slice element or map value, is written with the type information of its registered type
+	if rv.Kind() == reflect.Interface {
+		return s.variant(rv)
+	}
+
+	// bool is always compact in any position, true or false is carried by the meta and no value byte follows
 	if rv.Kind() != reflect.Struct && (rv.IsZero() || rv.Kind() == reflect.Bool) {
 		return s.writeEmpty(rv)
 	}
@@ -154,16 +519,16 @@ func (s *encodeState) value(rv reflect.Value) error {
 		if err != nil {
 			return err
 		}
-		return binary.Write(s.buf, binary.LittleEndian, int8(rv.Int()))
+		return binary.Write(s.buf, s.byteOrder(), int8(rv.Int()))
 
 	case reflect.Uint8:
 		err := s.writeTypeMeta(FabricSerializationTypeUChar)
 		if err != nil {
 			return err
 		}
-		return binary.Write(s.buf, binary.LittleEndian, uint8(rv.Uint()))
+		return binary.Write(s.buf, s.byteOrder(), uint8(rv.Uint()))
 
-	case reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	case reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
 		basetyp := kindToFabricSerializationType(rv.Kind())
 
 		if basetyp == FabricSerializationTypeNotAMeta {
@@ -175,8 +540,8 @@ func (s *encodeState) value(rv reflect.Value) error {
 			return err
 		}
 
-		return s.writeCompressedUnsigned(int(rv.Type().Size()), rv.Uint())
-	case reflect.Int16, reflect.Int32, reflect.Int64:
+		return s.writeCompressedUnsigned(metaSize(basetyp), rv.Uint())
+	case reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
 		basetyp := kindToFabricSerializationType(rv.Kind())
 
 		if basetyp == FabricSerializationTypeNotAMeta {
@@ -188,12 +553,12 @@ func (s *encodeState) value(rv reflect.Value) error {
 			return err
 		}
 
-		return s.writeCompressedSigned(int(rv.Type().Size()), rv.Int())
+		return s.writeCompressedSigned(metaSize(basetyp), rv.Int())
 	case reflect.Float32, reflect.Float64:
 		if err := s.writeTypeMeta(FabricSerializationTypeDouble); err != nil {
 			return err
 		}
-		return binary.Write(s.buf, binary.LittleEndian, rv.Float())
+		return binary.Write(s.buf, s.byteOrder(), rv.Float())
 
 	case reflect.String:
 
@@ -206,8 +571,14 @@ func (s *encodeState) value(rv reflect.Value) error {
 			return err
 		}
 
-		return binary.Write(s.buf, binary.LittleEndian, str)
+		return binary.Write(s.buf, s.byteOrder(), str)
 	case reflect.Ptr:
+		leave, err := s.enter(rv)
+		if err != nil {
+			return err
+		}
+		defer leave()
+
 		if err := s.writeTypeMeta(FabricSerializationTypePointer); err != nil {
 			return err
 		}
@@ -220,28 +591,34 @@ func (s *encodeState) value(rv reflect.Value) error {
 			return cm.Marshal(s)
 		}
 
-		if err := s.objectScopeBegin(); err != nil {
+		u, err := unionOf(rv, false)
+		if err != nil {
 			return err
 		}
 
-		for _, field := range allFields(rv) {
-			if err := s.value(field); err != nil {
-				return err
-			}
+		if u != nil {
+			return s.union(u)
 		}
 
-		if err := s.objectScopeEnd(); err != nil {
+		// depth 0 is the root object, which is always written in full
+		if s.opts.EmptyStructs && s.depth > 0 && rv.IsZero() {
+			return s.writeEmpty(rv)
+		}
+
+		if err := s.object(rv, nil); err != nil {
 			return err
 		}
-	case reflect.Slice:
+	case reflect.Slice, reflect.Array:
+		// fixed array [N]T is written as slice of N elements
+		// []rune is []int32 and written as int32 array of code points, only string is written as wstring
 		len := rv.Len()
 		if len == 0 {
 			return s.writeEmpty(rv)
 		}
 
-		elmTyp := rv.Type().Elem().Kind()
+		elmTyp := elemKind(rv.Type().Elem())
 		switch elmTyp {
-		case reflect.String, reflect.Ptr:
+		case reflect.String, reflect.Ptr, reflect.Interface:
 			if err := s.writeTypeMeta(FabricSerializationTypeUInt32); err != nil {
 				return err
 			}
@@ -249,7 +626,7 @@ func (s *encodeState) value(rv reflect.Value) error {
 			baseTyp := kindToFabricSerializationType(elmTyp)
 
 			if baseTyp == FabricSerializationTypeNotAMeta {
-				return fmt.Errorf("unsupported slice type %v", elmTyp)
+				return &UnsupportedTypeError{Type: rv.Type().Elem()}
 			}
 			if err := s.writeTypeMeta(baseTyp | FabricSerializationTypeArray); err != nil {
 				return err
@@ -260,65 +637,331 @@ func (s *encodeState) value(rv reflect.Value) error {
 			return err
 		}
 
+		if t := rv.Type().Elem(); t == byteType || t == int8Type {
+			s.byteElems(rv)
+			return nil
+		}
+
 		for i := 0; i < rv.Len(); i++ {
 			if err := s.value(rv.Index(i)); err != nil {
-				return err
+				return marshalErrorAt(err, fmt.Sprintf("[%v]", i))
 			}
 		}
 	case reflect.Map:
-		keytyp := rv.Type().Key()
-		valtyp := rv.Type().Elem()
-		sliceTyp := reflect.StructOf([]reflect.StructField{
-			{
-				Name: "Key",
-				Type: keytyp,
-			},
-			{
-				Name: "Value",
-				Type: valtyp,
-			},
-		})
-
-		entries := reflect.Indirect(reflect.New(reflect.SliceOf(sliceTyp)))
-		iter := rv.MapRange()
-		for iter.Next() {
-			entry := reflect.Indirect(reflect.New(sliceTyp))
-			entry.Field(0).Set(iter.Key())
-			entry.Field(1).Set(iter.Value())
-			entries = reflect.Append(entries, entry)
-		}
-
-		if err := s.value(entries); err != nil {
+		if rv.Type() == stringSetType {
+			return s.value(reflect.ValueOf(rv.Interface().(StringSet).Slice()))
+		}
+
+		return s.mapEntries(rv)
+	default:
+		return &UnsupportedTypeError{Type: rv.Type()}
+	}
+
+	return nil
+}
+
+var (
+	byteType = reflect.TypeOf(byte(0))
+	int8Type = reflect.TypeOf(int8(0))
+)
+
+// byteElems writes the elements of a byte or int8 slice or array the same as value of each element,
+// uchar or char meta and the byte, or the empty meta for 0, without reflecting on each element
+func (s *encodeState) byteElems(rv reflect.Value) {
+	meta := byte(FabricSerializationTypeUChar)
+	if rv.Type().Elem() == int8Type {
+		meta = byte(FabricSerializationTypeChar)
+	}
+
+	var b []byte
+	if rv.Kind() == reflect.Slice && meta == byte(FabricSerializationTypeUChar) {
+		b = rv.Bytes()
+	}
+
+	s.buf.Grow(2 * rv.Len())
+
+	for i := 0; i < rv.Len(); i++ {
+		var c byte
+		if b != nil {
+			c = b[i]
+		} else if meta == byte(FabricSerializationTypeUChar) {
+			c = byte(rv.Index(i).Uint())
+		} else {
+			c = byte(rv.Index(i).Int())
+		}
+
+		if c == 0 {
+			s.buf.WriteByte(meta | byte(FabricSerializationTypeEmptyValueBit))
+			continue
+		}
+
+		s.buf.WriteByte(meta)
+		s.buf.WriteByte(c)
+	}
+}
+
+// mapEntries writes map as an array of {Key, Value} objects, encoded straight from the map without an intermediate slice
+// entries are in map iteration order unless Deterministic or Canonical is set
+func (s *encodeState) mapEntries(rv reflect.Value) error {
+	if rv.Len() == 0 {
+		return s.writeEmpty(rv)
+	}
+
+	if err := s.writeTypeMeta(FabricSerializationTypeObject | FabricSerializationTypeArray); err != nil {
+		return err
+	}
+
+	if err := s.writeCompressedUint32(uint32(rv.Len())); err != nil {
+		return err
+	}
+
+	// addressable copies reused by every entry
+	key := reflect.New(rv.Type().Key()).Elem()
+	val := reflect.New(rv.Type().Elem()).Elem()
+	entry := []structField{
+		{value: key, name: "Key"},
+		{value: val, name: "Value"},
+	}
+
+	writeEntry := func(k, v reflect.Value) error {
+		key.Set(k)
+		val.Set(v)
+
+		if err := s.objectScopeBegin(); err != nil {
+			return err
+		}
+
+		if err := s.objectFields(entry, nil); err != nil {
+			// path is the entry key rather than the Key or Value field
+			if me, ok := err.(*MarshalError); ok {
+				me.Path = strings.TrimPrefix(me.Path, "Value")
+				me.Path = strings.TrimPrefix(me.Path, "Key")
+				me.Path = strings.TrimPrefix(me.Path, ".")
+			}
+
+			return marshalErrorAt(err, fmt.Sprintf("[%v]", k.Interface()))
+		}
+
+		return nil
+	}
+
+	if s.opts.Deterministic || s.opts.Canonical {
+		keys := rv.MapKeys()
+		if err := sortMapKeys(keys); err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			if err := writeEntry(k, rv.MapIndex(k)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	iter := rv.MapRange()
+	for iter.Next() {
+		if err := writeEntry(iter.Key(), iter.Value()); err != nil {
 			return err
 		}
+	}
+
+	return nil
+}
+
+func sortMapKeys(keys []reflect.Value) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	var less func(a, b reflect.Value) bool
+
+	switch keys[0].Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		less = func(a, b reflect.Value) bool { return a.Int() < b.Int() }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		less = func(a, b reflect.Value) bool { return a.Uint() < b.Uint() }
+	case reflect.Float32, reflect.Float64:
+		less = func(a, b reflect.Value) bool { return a.Float() < b.Float() }
+	case reflect.String:
+		less = func(a, b reflect.Value) bool { return a.String() < b.String() }
+	case reflect.Bool:
+		less = func(a, b reflect.Value) bool { return !a.Bool() && b.Bool() }
 	default:
-		return fmt.Errorf("unsupported marshal type %v", rv.String())
+		return fmt.Errorf("unsupported deterministic map key type %v", keys[0].Type())
 	}
 
+	sort.Slice(keys, func(i, j int) bool { return less(keys[i], keys[j]) })
 	return nil
 }
 
+// MarshalError is a marshal failure of the value at Path, e.g. Cluster.Nodes[3].Endpoint, from the root object
+type MarshalError struct {
+	Path string // field names, slice indexes and map keys
+	Err  error
+}
+
+func (e *MarshalError) Error() string {
+	return fmt.Sprintf("field %v: %v", e.Path, e.Err)
+}
+
+func (e *MarshalError) Unwrap() error {
+	return e.Err
+}
+
+// UnsupportedTypeError is returned for a value whose type has no Fabric encoding, e.g. chan, func or complex
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("unsupported marshal type %v", e.Type)
+}
+
+// marshalErrorAt prepends elem, a field name or [index], to the path of err
+func marshalErrorAt(err error, elem string) error {
+	me, ok := err.(*MarshalError)
+	if !ok {
+		return &MarshalError{Path: elem, Err: err}
+	}
+
+	switch {
+	case me.Path == "":
+		me.Path = elem
+	case me.Path[0] != '[':
+		me.Path = elem + "." + me.Path
+	default:
+		me.Path = elem + me.Path
+	}
+
+	return me
+}
+
+// MarshalOptions configures marshaling, the zero value is the same as Marshal
+type MarshalOptions struct {
+	// ValidateEnums rejects values of types registered with RegisterEnum that are not known constants
+	ValidateEnums bool
+
+	// FieldNames emits a table of field names in each object header for tooling, flagged as extension data.
+	// It is an extended format Unmarshal and Dump understand, standard Fabric decoders may not.
+	// Unmarshal places fields by name in any order, unknown names are skipped and missing fields left unchanged.
+	FieldNames bool
+
+	// OrdinalFields writes self-describing objects for phabrik peers, not standard Fabric.
+	// Each field is written as UInt32 meta, compressed ordinal, compressed byte length and the value,
+	// zero value fields are omitted. Unmarshal places fields by ordinal in any order and skips unknown ordinals.
+	OrdinalFields bool
+
+	// BigEndian writes fixed size values, e.g. object header sizes, doubles, wchars and GUID fields, big endian,
+	// e.g. for golden files compared across architectures. Compressed integers have no byte order and are unchanged.
+	// It is not the Fabric wire format, Unmarshal and Dump only read little endian.
+	BigEndian bool
+
+	// MaxDepth limits nesting of objects so a deep acyclic value cannot exhaust the stack,
+	// DefaultMaxDepth is used if 0 as with UnmarshalOptions, negative disables the limit
+	MaxDepth int
+
+	// EmptyStructs writes a struct field or element whose fields are all zero as the empty Object meta without a scope,
+	// as Fabric does for some default sub-objects. Unmarshal decodes the empty meta as the zero struct.
+	// The root object, custom marshalers and unions are always written in full.
+	EmptyStructs bool
+
+	// Deterministic writes map entries sorted by key, so equal maps marshal to equal bytes.
+	// Keys must be integers, floats, strings or bools, maps with other key types, e.g. structs, fail to marshal.
+	Deterministic bool
+
+	// Canonical produces bytes stable enough to sign or hash, equal values always marshal to equal bytes:
+	// map entries are sorted by key as with Deterministic and maps with keys that cannot be ordered are rejected,
+	// fields are always in declaration order, nil and empty slices, maps and strings are the same empty meta,
+	// float -0 is written as 0 and NaN is rejected.
+	// The bytes are only stable for the same Go types and options, and are still decoded by Unmarshal.
+	Canonical bool
+}
+
+// Marshal encodes the struct v points to, only exported fields are written
+// a []byte is taken as already marshaled and returned verbatim without framing, Unmarshal into *[]byte is the reverse
 func Marshal(v interface{}) ([]byte, error) {
-	if b, ok := v.([]byte); ok {
+	return MarshalOptions{}.Marshal(v)
+}
+
+func (o MarshalOptions) Marshal(v interface{}) ([]byte, error) {
+	switch b := v.(type) {
+	case []byte:
 		return b, nil
+	case *Precompiled:
+		if b != nil {
+			return b.Bytes()
+		}
 	}
 
-	s := &encodeState{}
+	var buf bytes.Buffer
+	if err := o.marshal(&buf, v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// MarshalTo appends the encoding of v to dst and returns the extended slice, dst is grown if needed
+func MarshalTo(dst []byte, v interface{}) ([]byte, error) {
+	return MarshalOptions{}.MarshalTo(dst, v)
+}
+
+// MarshalTo appends the encoding of v to dst, on error dst is returned with its length unchanged
+func (o MarshalOptions) MarshalTo(dst []byte, v interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	if err := o.marshal(buf, v); err != nil {
+		return dst, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// marshal appends v to root buffer, root is left with partial bytes on error
+func (o MarshalOptions) marshal(root *bytes.Buffer, v interface{}) error {
+	if v == nil {
+		return fmt.Errorf("cannot marshal nil")
+	}
+
+	if p, ok := v.(*Precompiled); ok && p != nil {
+		b, err := p.Bytes()
+		if err != nil {
+			return err
+		}
+
+		_, err = root.Write(b)
+		return err
+	}
+
+	if b, ok := v.([]byte); ok {
+		_, err := root.Write(b)
+		return err
+	}
+
+	s := newEncodeState(o)
+	defer s.release()
+
 	pv := reflect.ValueOf(v)
-	if pv.Kind() != reflect.Ptr || pv.IsNil() {
-		return nil, fmt.Errorf("marshal type must be ptr")
+	if pv.Kind() != reflect.Ptr {
+		return fmt.Errorf("marshal type must be ptr")
+	}
+
+	if pv.IsNil() {
+		return fmt.Errorf("cannot marshal nil %v", pv.Type())
 	}
 
 	rv := reflect.Indirect(pv)
 	if rv.Kind() != reflect.Struct {
-		return nil, fmt.Errorf("marshal type must be ptr to struct")
+		return fmt.Errorf("marshal type must be ptr to struct")
 	}
 
-	s.pushBuffer() // root buf
+	s.bufStack = append(s.bufStack, root)
+	s.buf = root
 
-	if err := s.value(rv); err != nil {
-		return nil, err
+	if _, err := s.enter(pv); err != nil {
+		return err
 	}
 
-	return s.buf.Bytes(), nil
+	return s.value(rv)
 }
@@ -0,0 +1,124 @@
+package serialization
+
+import (
+	"fmt"
+	"reflect"
+)
+
+type PartitionKeyKind int64
+
+const (
+	PartitionKeyKindInvalid   PartitionKeyKind = 0x0
+	PartitionKeyKindSingleton PartitionKeyKind = 0x1
+	PartitionKeyKindInt64     PartitionKeyKind = 0x2
+	PartitionKeyKindNamed     PartitionKeyKind = 0x3
+)
+
+func (k PartitionKeyKind) String() string {
+	switch k {
+	case PartitionKeyKindSingleton:
+		return "Singleton"
+	case PartitionKeyKindInt64:
+		return "Int64"
+	case PartitionKeyKindNamed:
+		return "Named"
+	default:
+		return fmt.Sprintf("PartitionKeyKind(%d)", int64(k))
+	}
+}
+
+// PartitionKey targets a partition of a service, a union of singleton, int64 or named key
+type PartitionKey struct {
+	Kind     PartitionKeyKind
+	Int64Key int64
+	Name     string
+}
+
+// wire layout, kind followed by both values
+type partitionKeyWire struct {
+	Kind      PartitionKeyKind
+	Int64Key  int64
+	StringKey string
+}
+
+func SingletonPartitionKey() PartitionKey {
+	return PartitionKey{Kind: PartitionKeyKindSingleton}
+}
+
+func Int64PartitionKey(key int64) PartitionKey {
+	return PartitionKey{Kind: PartitionKeyKindInt64, Int64Key: key}
+}
+
+func NamedPartitionKey(name string) PartitionKey {
+	return PartitionKey{Kind: PartitionKeyKindNamed, Name: name}
+}
+
+// Validate checks only the value of the kind is set
+func (k *PartitionKey) Validate() error {
+	switch k.Kind {
+	case PartitionKeyKindSingleton:
+		if k.Int64Key != 0 || k.Name != "" {
+			return fmt.Errorf("singleton partition key must not have value")
+		}
+	case PartitionKeyKindInt64:
+		if k.Name != "" {
+			return fmt.Errorf("int64 partition key must not have name")
+		}
+	case PartitionKeyKindNamed:
+		if k.Name == "" {
+			return fmt.Errorf("named partition key must have name")
+		}
+
+		if k.Int64Key != 0 {
+			return fmt.Errorf("named partition key must not have int64 key")
+		}
+	default:
+		return fmt.Errorf("invalid partition key kind %v", k.Kind)
+	}
+
+	return nil
+}
+
+func (k *PartitionKey) Marshal(e Encoder) error {
+	if err := k.Validate(); err != nil {
+		return err
+	}
+
+	s, ok := e.(*encodeState)
+	if !ok {
+		return fmt.Errorf("unsupported encoder %T", e)
+	}
+
+	w := partitionKeyWire{
+		Kind:      k.Kind,
+		Int64Key:  k.Int64Key,
+		StringKey: k.Name,
+	}
+
+	return s.object(reflect.ValueOf(&w).Elem(), nil)
+}
+
+func (k *PartitionKey) Unmarshal(meta FabricSerializationType, d Decoder) error {
+	s, ok := d.(*decodeState)
+	if !ok {
+		return fmt.Errorf("unsupported decoder %T", d)
+	}
+
+	var w partitionKeyWire
+	if err := s.value(meta, reflect.ValueOf(&w).Elem()); err != nil {
+		return err
+	}
+
+	key := PartitionKey{
+		Kind:     w.Kind,
+		Int64Key: w.Int64Key,
+		Name:     w.StringKey,
+	}
+
+	if err := key.Validate(); err != nil {
+		return err
+	}
+
+	*k = key
+	return nil
+}
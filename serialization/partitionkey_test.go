@@ -0,0 +1,77 @@
+package serialization
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionKey(t *testing.T) {
+	type request struct {
+		Key PartitionKey
+		N   int32
+	}
+
+	for _, key := range []PartitionKey{
+		SingletonPartitionKey(),
+		Int64PartitionKey(-42),
+		Int64PartitionKey(0),
+		NamedPartitionKey("p1"),
+	} {
+		t.Run(key.Kind.String(), func(t *testing.T) {
+			from := request{Key: key, N: 1}
+
+			data, err := Marshal(&from)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var to request
+			if err := Unmarshal(data, &to); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, from, to)
+		})
+	}
+
+	t.Run("wire layout", func(t *testing.T) {
+		data, err := Marshal(&request{Key: NamedPartitionKey("p1")})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, err := Marshal(&struct {
+			Key partitionKeyWire
+			N   int32
+		}{Key: partitionKeyWire{Kind: PartitionKeyKindNamed, StringKey: "p1"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, expected, data)
+	})
+
+	t.Run("inconsistent", func(t *testing.T) {
+		for _, key := range []PartitionKey{
+			{},
+			{Kind: PartitionKeyKindSingleton, Int64Key: 1},
+			{Kind: PartitionKeyKindInt64, Name: "p1"},
+			{Kind: PartitionKeyKindNamed},
+			{Kind: PartitionKeyKindNamed, Name: "p1", Int64Key: 1},
+		} {
+			_, err := Marshal(&request{Key: key})
+			assert.Error(t, err, "%+v", key)
+		}
+
+		data, err := Marshal(&struct {
+			Key partitionKeyWire
+		}{partitionKeyWire{Kind: PartitionKeyKindSingleton, StringKey: "p1"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to request
+		assert.Error(t, Unmarshal(data, &to))
+	})
+}
@@ -0,0 +1,136 @@
+package serialization
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// MessagePool is a size bounded pool of decoded messages of the same type
+// values are reused by Unmarshal after the handler Release them
+type MessagePool struct {
+	// Debug keeps track of released messages to catch double release and use after release
+	Debug bool
+
+	// OnViolation is called in Debug mode when a pooled message is found modified after release while it is reused,
+	// the message is dropped and the call detecting it continues with a new one. A nil OnViolation panics there instead.
+	OnViolation func(err error)
+
+	typ  reflect.Type
+	size int
+
+	lock     sync.Mutex
+	free     []reflect.Value
+	released map[uintptr]bool
+}
+
+// NewMessagePool creates a pool for the type of proto, which must be a ptr to struct
+// at most size released messages are kept for reuse
+func NewMessagePool(proto interface{}, size int) (*MessagePool, error) {
+	pv := reflect.ValueOf(proto)
+	if pv.Kind() != reflect.Ptr || pv.Type().Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("pool type must be ptr to struct")
+	}
+
+	if size <= 0 {
+		return nil, fmt.Errorf("pool size must > 0")
+	}
+
+	return &MessagePool{
+		typ:      pv.Type().Elem(),
+		size:     size,
+		released: make(map[uintptr]bool),
+	}, nil
+}
+
+func (p *MessagePool) get() (reflect.Value, error) {
+	pv, err := p.take()
+	if err != nil {
+		// reported without lock, OnViolation may use the pool
+		p.violation(err)
+		return reflect.New(p.typ), nil
+	}
+
+	return pv, nil
+}
+
+// take pops a free message or allocates one, a message modified after release is dropped and returned as error
+func (p *MessagePool) take() (reflect.Value, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	n := len(p.free)
+	if n == 0 {
+		return reflect.New(p.typ), nil
+	}
+
+	pv := p.free[n-1]
+	p.free[n-1] = reflect.Value{}
+	p.free = p.free[:n-1]
+
+	if p.Debug {
+		delete(p.released, pv.Pointer())
+
+		// released message was zeroed, any change means it was used after release
+		if !pv.Elem().IsZero() {
+			return reflect.Value{}, fmt.Errorf("pooled %v at %#x was modified after release", p.typ, pv.Pointer())
+		}
+	}
+
+	pv.Elem().Set(reflect.Zero(p.typ))
+	return pv, nil
+}
+
+func (p *MessagePool) violation(err error) {
+	if p.OnViolation == nil {
+		panic(err)
+	}
+
+	p.OnViolation(err)
+}
+
+// Unmarshal decodes data into a message taken from the pool
+// the returned value is a ptr to the pool type
+func (p *MessagePool) Unmarshal(data []byte) (interface{}, error) {
+	pv, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+
+	v := pv.Interface()
+	if err := Unmarshal(data, v); err != nil {
+		p.Release(v)
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Release returns a message to the pool, the message must not be used after release
+func (p *MessagePool) Release(v interface{}) error {
+	pv := reflect.ValueOf(v)
+	if pv.Kind() != reflect.Ptr || pv.IsNil() || pv.Type().Elem() != p.typ {
+		return fmt.Errorf("release type must be *%v", p.typ)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.Debug && p.released[pv.Pointer()] {
+		return fmt.Errorf("pooled %v released twice", p.typ)
+	}
+
+	if len(p.free) >= p.size {
+		// drop, pool is full and the message is left to gc as is
+		return nil
+	}
+
+	if p.Debug {
+		// zeroed to catch a later change by get
+		pv.Elem().Set(reflect.Zero(p.typ))
+		p.released[pv.Pointer()] = true
+	}
+
+	p.free = append(p.free, pv)
+	return nil
+}
@@ -0,0 +1,196 @@
+package serialization
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type pooledObject struct {
+	Ulong   uint32
+	String  string
+	Array   []uint64
+	Version BasicObjectVersion
+}
+
+func TestMessagePool(t *testing.T) {
+	object := pooledObject{
+		Ulong:  42,
+		String: "pooled",
+		Array:  []uint64{1, 2, 3},
+	}
+
+	data, err := Marshal(&object)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := NewMessagePool(&pooledObject{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := pool.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, &object, v)
+	assert.NoError(t, pool.Release(v))
+
+	empty, err := Marshal(&pooledObject{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v2, err := pool.Unmarshal(empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reused and zeroed
+	assert.Same(t, v, v2)
+	assert.Equal(t, &pooledObject{}, v2)
+
+	t.Run("bad type", func(t *testing.T) {
+		assert.Error(t, pool.Release(&BasicObject{}))
+
+		_, err := NewMessagePool(pooledObject{}, 1)
+		assert.Error(t, err)
+	})
+}
+
+func TestMessagePoolDebug(t *testing.T) {
+	data, err := Marshal(&pooledObject{Ulong: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := NewMessagePool(&pooledObject{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Debug = true
+
+	t.Run("double release", func(t *testing.T) {
+		v, err := pool.Unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.NoError(t, pool.Release(v))
+		assert.Error(t, pool.Release(v))
+
+		// take it back
+		_, err = pool.Unmarshal(data)
+		assert.NoError(t, err)
+	})
+
+	t.Run("use after release", func(t *testing.T) {
+		var violations []error
+		pool.OnViolation = func(err error) {
+			violations = append(violations, err)
+
+			// called without lock, the pool can be used
+			v, err := pool.Unmarshal(data)
+			assert.NoError(t, err)
+			assert.NoError(t, pool.Release(v))
+		}
+		defer func() { pool.OnViolation = nil }()
+
+		v, err := pool.Unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.NoError(t, pool.Release(v))
+		assert.Equal(t, uint32(0), v.(*pooledObject).Ulong)
+
+		v.(*pooledObject).Ulong = 100
+
+		// reported by the reuse, which decodes into a new message
+		v2, err := pool.Unmarshal(data)
+		assert.NoError(t, err)
+		assert.NotSame(t, v, v2)
+		assert.Equal(t, &pooledObject{Ulong: 1}, v2)
+		assert.Len(t, violations, 1)
+		assert.Equal(t, uint32(100), v.(*pooledObject).Ulong)
+	})
+
+	t.Run("panic without OnViolation", func(t *testing.T) {
+		v, err := pool.Unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.NoError(t, pool.Release(v))
+		v.(*pooledObject).Ulong = 100
+
+		assert.Panics(t, func() {
+			pool.Unmarshal(data)
+		})
+	})
+
+	t.Run("dropped when full", func(t *testing.T) {
+		pool, err := NewMessagePool(&pooledObject{}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool.Debug = true
+
+		v1, err := pool.Unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		v2, err := pool.Unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.NoError(t, pool.Release(v1))
+		assert.NoError(t, pool.Release(v2))
+
+		// only the pooled message is zeroed and tracked
+		assert.Equal(t, &pooledObject{}, v1)
+		assert.Equal(t, &pooledObject{Ulong: 1}, v2)
+		assert.NoError(t, pool.Release(v2), "not tracked, not a double release")
+	})
+}
+
+func BenchmarkUnmarshalWithoutPool(b *testing.B) {
+	data, err := Marshal(&pooledObject{Ulong: 1, String: "bench", Array: []uint64{1, 2, 3}})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var v pooledObject
+		if err := Unmarshal(data, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalWithPool(b *testing.B) {
+	data, err := Marshal(&pooledObject{Ulong: 1, String: "bench", Array: []uint64{1, 2, 3}})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	pool, err := NewMessagePool(&pooledObject{}, 16)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		v, err := pool.Unmarshal(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		pool.Release(v)
+	}
+}
@@ -0,0 +1,72 @@
+package serialization
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Precompiled caches the marshaled bytes of a message sent repeatedly unchanged, e.g. a keepalive
+// Marshal of a *Precompiled returns the cached bytes, re-marshaling only after MarkDirty
+// it is safe to marshal the same Precompiled concurrently
+type Precompiled struct {
+	opts MarshalOptions
+	v    interface{}
+
+	lock  sync.Mutex
+	data  []byte
+	dirty bool
+}
+
+func NewPrecompiled(v interface{}) (*Precompiled, error) {
+	return MarshalOptions{}.Precompile(v)
+}
+
+// Precompile marshals v with o now and caches the bytes
+func (o MarshalOptions) Precompile(v interface{}) (*Precompiled, error) {
+	pv := reflect.ValueOf(v)
+	if pv.Kind() != reflect.Ptr || pv.IsNil() || pv.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("precompiled type must be ptr to struct")
+	}
+
+	p := &Precompiled{
+		opts:  o,
+		v:     v,
+		dirty: true,
+	}
+
+	if _, err := p.Bytes(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// MarkDirty makes the next Marshal pick up changes to the message
+func (p *Precompiled) MarkDirty() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.dirty = true
+}
+
+// Bytes returns the cached bytes, which are shared and must not be modified
+func (p *Precompiled) Bytes() ([]byte, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !p.dirty {
+		return p.data, nil
+	}
+
+	var buf bytes.Buffer
+	if err := p.opts.marshal(&buf, p.v); err != nil {
+		return nil, err
+	}
+
+	p.data = buf.Bytes()
+	p.dirty = false
+
+	return p.data, nil
+}
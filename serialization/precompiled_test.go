@@ -0,0 +1,126 @@
+package serialization
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrecompiled(t *testing.T) {
+	object := BasicObject{Long64_1: 1, String: "keepalive"}
+
+	p, err := NewPrecompiled(&object)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := Marshal(&object)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expected, data)
+
+	t.Run("cached until dirty", func(t *testing.T) {
+		object.Long64_1 = 2
+
+		data2, err := Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// same backing array, not re-marshaled
+		assert.Equal(t, &data[0], &data2[0])
+
+		p.MarkDirty()
+
+		data3, err := Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to BasicObject
+		if err := Unmarshal(data3, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, int64(2), to.Long64_1)
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		var buf bytes.Buffer
+		e := NewStreamEncoder(&buf)
+		assert.NoError(t, e.Encode(p))
+		assert.NoError(t, e.Encode(p))
+		assert.NoError(t, e.Flush())
+
+		b, _ := p.Bytes()
+		assert.Equal(t, append(append([]byte{}, b...), b...), buf.Bytes())
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				if i%2 == 0 {
+					p.MarkDirty()
+				}
+
+				data, err := Marshal(p)
+				assert.NoError(t, err)
+				assert.NotEmpty(t, data)
+			}(i)
+		}
+		wg.Wait()
+	})
+
+	t.Run("bad type", func(t *testing.T) {
+		_, err := NewPrecompiled(object)
+		assert.Error(t, err)
+
+		_, err = NewPrecompiled((*BasicObject)(nil))
+		assert.Error(t, err)
+
+		_, err = Marshal((*Precompiled)(nil))
+		assert.Error(t, err)
+	})
+}
+
+func BenchmarkPrecompiled(b *testing.B) {
+	object := BasicObject{Long64_1: 1, String: "keepalive", Ulong64_1: 0xFFFFFFFF}
+
+	b.Run("remarshal", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			if _, err := Marshal(&object); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("precompiled", func(b *testing.B) {
+		p, err := NewPrecompiled(&object)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			if _, err := Marshal(p); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package serialization
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Raw is serialized bytes kept as is, e.g. a sub-object passed through by a proxy
+// a Raw field captures the exact bytes of one value, meta and object header included, and is written verbatim,
+// nil is an empty pointer on the wire
+// the last field of a struct tagged `fabric:",rest"` of type Raw captures the fields beyond the known ones on decode,
+// and is written verbatim after the known fields on marshal, so fields unknown to this version round-trip
+type Raw []byte
+
+var rawType = reflect.TypeOf(Raw(nil))
+
+// splitRest separates the rest field from fields, rest is nil if none
+func splitRest(fields []structField) ([]structField, *structField, error) {
+	for i, f := range fields {
+		if !f.tag.rest {
+			continue
+		}
+
+		if i != len(fields)-1 {
+			return nil, nil, fmt.Errorf("rest field %v must be the last field", f.name)
+		}
+
+		if f.value.Type() != rawType {
+			return nil, nil, fmt.Errorf("rest field %v must be serialization.Raw", f.name)
+		}
+
+		return fields[:i], &fields[i], nil
+	}
+
+	return fields, nil, nil
+}
+
+// rest captures bytes from current position to endpos, where the scope end of the object is
+func (s *decodeState) rest(f *structField, endpos int64) error {
+	pos, err := s.inner.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if endpos < pos {
+		return fmt.Errorf("object fields exceed object size")
+	}
+
+	if endpos == pos {
+		return nil
+	}
+
+	b := make(Raw, endpos-pos)
+	if _, err := io.ReadFull(s.inner, b); err != nil {
+		return err
+	}
+
+	f.value.Set(reflect.ValueOf(b))
+	return nil
+}
+
+func (s *encodeState) raw(rv reflect.Value) error {
+	if rv.Len() == 0 {
+		return s.writeTypeMeta(FabricSerializationTypeEmptyValueBit | FabricSerializationTypePointer)
+	}
+
+	_, err := s.buf.Write(rv.Bytes())
+	return err
+}
+
+// raw captures the value following meta, which is already read
+func (s *decodeState) raw(meta FabricSerializationType, rv reflect.Value) error {
+	if meta == FabricSerializationTypeEmptyValueBit|FabricSerializationTypePointer {
+		rv.Set(reflect.Zero(rawType))
+		return nil
+	}
+
+	start, err := s.inner.Seek(-1, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.inner.Seek(1, io.SeekCurrent); err != nil {
+		return err
+	}
+
+	if err := s.skip(meta); err != nil {
+		return err
+	}
+
+	end, err := s.inner.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.inner.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	b := make(Raw, end-start)
+	if _, err := io.ReadFull(s.inner, b); err != nil {
+		return err
+	}
+
+	rv.Set(reflect.ValueOf(b))
+	return nil
+}
@@ -0,0 +1,177 @@
+package serialization
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestField(t *testing.T) {
+	type inner struct {
+		X string
+	}
+
+	type v2 struct {
+		A int32
+		B string
+		C []int32
+		D inner
+		E *inner
+	}
+
+	type v1 struct {
+		A    int32
+		B    string
+		Rest Raw `fabric:",rest"`
+	}
+
+	from := v2{A: 1, B: "b", C: []int32{2, 3}, D: inner{X: "x"}, E: &inner{X: "y"}}
+
+	for _, opts := range []MarshalOptions{{}, {OrdinalFields: true}} {
+		data, err := opts.Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var old v1
+		if err := Unmarshal(data, &old); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, int32(1), old.A)
+		assert.Equal(t, "b", old.B)
+		assert.NotEmpty(t, old.Rest)
+
+		again, err := opts.Marshal(&old)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, data, again, "%+v", opts)
+
+		var to v2
+		if err := Unmarshal(again, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, from, to)
+	}
+
+	t.Run("nothing beyond", func(t *testing.T) {
+		data, err := Marshal(&v1{A: 1, B: "b"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		to := v1{Rest: Raw{1}}
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, v1{A: 1, B: "b"}, to)
+	})
+
+	t.Run("bad rest field", func(t *testing.T) {
+		_, err := Marshal(&struct {
+			Rest Raw `fabric:",rest"`
+			A    int32
+		}{})
+		assert.Error(t, err)
+
+		_, err = Marshal(&struct {
+			A    int32
+			Rest []byte `fabric:",rest"`
+		}{})
+		assert.Error(t, err)
+	})
+}
+
+func TestRawField(t *testing.T) {
+	type leaf struct {
+		X string
+		Y []int32
+	}
+
+	type sub struct {
+		A    int64
+		Leaf *leaf
+		Map  map[string]int32
+	}
+
+	type original struct {
+		Before int32
+		Sub    *sub
+		Subs   []sub
+		After  string
+	}
+
+	type proxy struct {
+		Before int32
+		Sub    Raw
+		Subs   Raw
+		After  string
+	}
+
+	cases := []original{
+		{
+			Before: 1,
+			Sub:    &sub{A: 2, Leaf: &leaf{X: "x", Y: []int32{3}}, Map: map[string]int32{"k": 4}},
+			Subs:   []sub{{A: 5}, {Leaf: &leaf{}}},
+			After:  "6",
+		},
+		{Before: 1, After: "nil sub"},
+	}
+
+	for _, opts := range []MarshalOptions{{}, {OrdinalFields: true}} {
+		for _, c := range cases {
+			data, err := opts.Marshal(&c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var p proxy
+			if err := Unmarshal(data, &p); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, c.Before, p.Before)
+			assert.Equal(t, c.After, p.After)
+			assert.Equal(t, c.Sub == nil, p.Sub == nil)
+
+			again, err := opts.Marshal(&p)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, data, again)
+
+			var to original
+			if err := Unmarshal(again, &to); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, c, to)
+		}
+	}
+
+	t.Run("captured object", func(t *testing.T) {
+		s := sub{A: 7}
+		data, err := Marshal(&struct{ Sub *sub }{Sub: &s})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var p struct{ Sub Raw }
+		if err := Unmarshal(data, &p); err != nil {
+			t.Fatal(err)
+		}
+
+		// pointer meta, then the object as marshaled on its own
+		standalone, err := Marshal(&s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, append(Raw{byte(FabricSerializationTypePointer)}, standalone...), p.Sub)
+	})
+}
@@ -0,0 +1,119 @@
+package serialization
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var types = struct {
+	sync.RWMutex
+	byId   map[uint32]reflect.Type // registered type, T or *T
+	byType map[reflect.Type]uint32 // struct type T
+}{
+	byId:   make(map[uint32]reflect.Type),
+	byType: make(map[reflect.Type]uint32),
+}
+
+// RegisterType registers a struct type for polymorphic values, e.g. elements of []SomeInterface or values of map[K]SomeInterface
+// the id is written as the type information of the object header, proto is either T or *T
+// values are decoded as the same form as proto
+//
+// values of other types held by interface, e.g. map[string]interface{} property bags:
+// scalars (integers, floats, bool and string) are written as plain values and decoded as the Go type of their meta,
+// e.g. int32 or int64, float64, bool and string, Raw is written verbatim,
+// marshaling other unregistered types fails, and decoding an unregistered or untyped object fails
+// unless UnmarshalOptions.RawUnknownTypes is set, which decodes it as Raw
+func RegisterType(id uint32, proto interface{}) {
+	typ := reflect.TypeOf(proto)
+	if typ == nil {
+		panic("register nil type")
+	}
+
+	structTyp := typ
+	if structTyp.Kind() == reflect.Ptr {
+		structTyp = structTyp.Elem()
+	}
+
+	if structTyp.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("register type %v must be struct or pointer to struct", typ))
+	}
+
+	types.Lock()
+	defer types.Unlock()
+
+	if t, ok := types.byId[id]; ok && t != typ {
+		panic(fmt.Sprintf("type id %v already registered for %v", id, t))
+	}
+
+	if i, ok := types.byType[structTyp]; ok && i != id {
+		panic(fmt.Sprintf("type %v already registered with id %v", structTyp, i))
+	}
+
+	types.byId[id] = typ
+	types.byType[structTyp] = id
+}
+
+func registeredTypeInfo(structTyp reflect.Type) ([]byte, bool) {
+	types.RLock()
+	id, ok := types.byType[structTyp]
+	types.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	typeinfo := make([]byte, 4)
+	binary.LittleEndian.PutUint32(typeinfo, id)
+	return typeinfo, true
+}
+
+func registeredType(typeinfo []byte) (reflect.Type, error) {
+	if len(typeinfo) != 4 {
+		return nil, fmt.Errorf("bad type information %x", typeinfo)
+	}
+
+	id := binary.LittleEndian.Uint32(typeinfo)
+
+	types.RLock()
+	typ, ok := types.byId[id]
+	types.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("type id %v not registered", id)
+	}
+
+	return typ, nil
+}
+
+// scalar values held by interface are written as plain values and decoded as the Go type of their meta
+var scalarVariantTypes = map[FabricSerializationType]reflect.Type{
+	FabricSerializationTypeChar:                                   reflect.TypeOf(int8(0)),
+	FabricSerializationTypeUChar:                                  reflect.TypeOf(uint8(0)),
+	FabricSerializationTypeShort:                                  reflect.TypeOf(int16(0)),
+	FabricSerializationTypeUShort:                                 reflect.TypeOf(uint16(0)),
+	FabricSerializationTypeInt32:                                  reflect.TypeOf(int32(0)),
+	FabricSerializationTypeUInt32:                                 reflect.TypeOf(uint32(0)),
+	FabricSerializationTypeInt64:                                  reflect.TypeOf(int64(0)),
+	FabricSerializationTypeUInt64:                                 reflect.TypeOf(uint64(0)),
+	FabricSerializationTypeDouble:                                 reflect.TypeOf(float64(0)),
+	FabricSerializationTypeBool:                                   reflect.TypeOf(false),
+	FabricSerializationTypeBoolFalse:                              reflect.TypeOf(false),
+	FabricSerializationTypeWString | FabricSerializationTypeArray: reflect.TypeOf(""),
+}
+
+func scalarVariantType(meta FabricSerializationType) (reflect.Type, bool) {
+	typ, ok := scalarVariantTypes[meta&^FabricSerializationTypeEmptyValueBit]
+	return typ, ok
+}
+
+func isScalarVariant(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Int8, reflect.Uint8, reflect.Int16, reflect.Uint16, reflect.Int32, reflect.Uint32,
+		reflect.Int64, reflect.Uint64, reflect.Int, reflect.Uint, reflect.Float32, reflect.Float64, reflect.Bool, reflect.String:
+		return true
+	}
+
+	return false
+}
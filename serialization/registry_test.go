@@ -0,0 +1,296 @@
+package serialization
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testShape interface {
+	Area() float64
+}
+
+type testCircle struct {
+	R float64
+}
+
+func (c *testCircle) Area() float64 {
+	return 3 * c.R * c.R
+}
+
+type testSquare struct {
+	S int32
+}
+
+func (s testSquare) Area() float64 {
+	return float64(s.S * s.S)
+}
+
+// testNode links through an interface, for cycles via variants
+type testNode struct {
+	Next interface{}
+}
+
+func init() {
+	RegisterType(1, &testCircle{})
+	RegisterType(2, testSquare{})
+	RegisterType(5, &testNode{})
+}
+
+func TestVariantArray(t *testing.T) {
+	type object struct {
+		Shapes []testShape
+		N      int32
+	}
+
+	from := object{
+		Shapes: []testShape{&testCircle{R: 1}, testSquare{S: 2}, nil},
+		N:      3,
+	}
+
+	data, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var to object
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, from, to)
+
+	t.Run("object array with type information", func(t *testing.T) {
+		// elements of a uniform object array carrying type information
+		var w encodeState
+		w.pushBuffer()
+		assert.NoError(t, w.objectScopeBegin())
+		assert.NoError(t, w.writeTypeMeta(FabricSerializationTypeObject|FabricSerializationTypeArray))
+		assert.NoError(t, w.writeCompressedUint32(2))
+		assert.NoError(t, w.object(reflect.ValueOf(&testSquare{S: 1}).Elem(), []byte{2, 0, 0, 0}))
+		assert.NoError(t, w.object(reflect.ValueOf(&testSquare{S: 2}).Elem(), []byte{2, 0, 0, 0}))
+		assert.NoError(t, w.objectScopeEnd(nil, nil))
+
+		var to struct {
+			Shapes []testShape
+		}
+
+		if err := Unmarshal(w.buf.Bytes(), &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []testShape{testSquare{S: 1}, testSquare{S: 2}}, to.Shapes)
+
+		// uniform path still decodes into concrete types, type information discarded
+		var squares struct {
+			Shapes []testSquare
+		}
+
+		if err := Unmarshal(w.buf.Bytes(), &squares); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []testSquare{{S: 1}, {S: 2}}, squares.Shapes)
+	})
+
+	t.Run("unregistered", func(t *testing.T) {
+		type unknown struct {
+			testSquare
+		}
+
+		_, err := Marshal(&struct {
+			Shapes []testShape
+		}{[]testShape{unknown{}}})
+		assert.Error(t, err)
+
+		var w encodeState
+		w.pushBuffer()
+		assert.NoError(t, w.objectScopeBegin())
+		assert.NoError(t, w.writeTypeMeta(FabricSerializationTypeUInt32))
+		assert.NoError(t, w.writeCompressedUint32(1))
+		assert.NoError(t, w.writeTypeMeta(FabricSerializationTypePointer))
+		assert.NoError(t, w.object(reflect.ValueOf(&testSquare{S: 1}).Elem(), []byte{99, 0, 0, 0}))
+		assert.NoError(t, w.objectScopeEnd(nil, nil))
+
+		var to struct {
+			Shapes []testShape
+		}
+
+		assert.EqualError(t, Unmarshal(w.buf.Bytes(), &to), "type id 99 not registered")
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		assert.Panics(t, func() { RegisterType(1, testSquare{}) })
+		assert.Panics(t, func() { RegisterType(3, testSquare{}) })
+		assert.Panics(t, func() { RegisterType(4, 1) })
+		assert.NotPanics(t, func() { RegisterType(2, testSquare{}) })
+	})
+}
+
+func TestVariantMap(t *testing.T) {
+	type object struct {
+		Shapes map[string]testShape
+	}
+
+	from := object{
+		Shapes: map[string]testShape{
+			"circle": &testCircle{R: 1},
+			"square": testSquare{S: 2},
+			"none":   nil,
+		},
+	}
+
+	data, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var to object
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, from, to)
+	assert.IsType(t, &testCircle{}, to.Shapes["circle"])
+	assert.IsType(t, testSquare{}, to.Shapes["square"])
+
+	t.Run("unregistered", func(t *testing.T) {
+		var w encodeState
+		w.pushBuffer()
+		assert.NoError(t, w.objectScopeBegin())
+		assert.NoError(t, w.writeTypeMeta(FabricSerializationTypeObject|FabricSerializationTypeArray))
+		assert.NoError(t, w.writeCompressedUint32(1))
+		assert.NoError(t, w.objectScopeBegin())
+		assert.NoError(t, w.value(reflect.ValueOf("square")))
+		assert.NoError(t, w.writeTypeMeta(FabricSerializationTypePointer))
+		assert.NoError(t, w.object(reflect.ValueOf(&testSquare{S: 1}).Elem(), []byte{99, 0, 0, 0}))
+		assert.NoError(t, w.objectScopeEnd(nil, nil))
+		assert.NoError(t, w.objectScopeEnd(nil, nil))
+
+		var to object
+		assert.EqualError(t, Unmarshal(w.buf.Bytes(), &to), "type id 99 not registered")
+	})
+}
+
+func TestVariantField(t *testing.T) {
+	type message struct {
+		Id   int32
+		Body testShape
+		Next testShape
+	}
+
+	for _, body := range []testShape{&testCircle{R: 2}, testSquare{S: 3}, nil} {
+		from := message{Id: 1, Body: body, Next: testSquare{S: 4}}
+
+		data, err := Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to message
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, from, to)
+	}
+
+	t.Run("type information", func(t *testing.T) {
+		data, err := Marshal(&message{Body: testSquare{S: 3}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dump, err := Dump(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the registered id precedes the object scope
+		assert.Contains(t, dump, "  Pointer\n000c       Object size=18 flags=0x01\n0015         TypeInformation len=4 02000000\n")
+	})
+}
+
+func TestVariantPropertyBag(t *testing.T) {
+	type unregistered struct {
+		X string
+	}
+
+	type object struct {
+		Properties map[string]interface{}
+	}
+
+	from := object{
+		Properties: map[string]interface{}{
+			"circle": &testCircle{R: 1},
+			"count":  int32(3),
+			"zero":   int64(0),
+			"name":   "svc",
+			"ok":     true,
+			"none":   nil,
+		},
+	}
+
+	data, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var to object
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, from, to)
+
+	t.Run("unregistered", func(t *testing.T) {
+		_, err := Marshal(&object{Properties: map[string]interface{}{"x": unregistered{X: "x"}}})
+		assert.Error(t, err)
+
+		// written by a peer that knows the type
+		data, err := Marshal(&struct {
+			Properties []struct {
+				Key   string
+				Value *unregistered
+			}
+		}{
+			Properties: []struct {
+				Key   string
+				Value *unregistered
+			}{{Key: "x", Value: &unregistered{X: "x"}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to object
+		assert.Error(t, Unmarshal(data, &to))
+
+		if err := (UnmarshalOptions{RawUnknownTypes: true}).Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.IsType(t, Raw{}, to.Properties["x"])
+
+		// passed through verbatim
+		again, err := Marshal(&to)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, data, again)
+	})
+
+	t.Run("scalar into typed interface", func(t *testing.T) {
+		data, err := Marshal(&object{Properties: map[string]interface{}{"count": int32(3)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to struct {
+			Properties map[string]testShape
+		}
+		assert.Error(t, Unmarshal(data, &to))
+	})
+}
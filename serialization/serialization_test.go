@@ -1,10 +1,17 @@
 package serialization
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -53,6 +60,105 @@ func TestCustomMarshaler(t *testing.T) {
 	assert.Equal(t, object.F.v, object2.F.v)
 }
 
+// defaultPort writes the default port 80 as empty, so empty is not the zero value
+type defaultPort struct {
+	port uint16
+}
+
+func (p *defaultPort) Marshal(s Encoder) error {
+	if p.port == 80 {
+		return s.WriteTypeMeta(FabricSerializationTypeUShort | FabricSerializationTypeEmptyValueBit)
+	}
+
+	if err := s.WriteTypeMeta(FabricSerializationTypeUShort); err != nil {
+		return err
+	}
+
+	return s.WriteBinary(p.port)
+}
+
+func (p *defaultPort) Unmarshal(meta FabricSerializationType, s Decoder) error {
+	if !IsBaseMeta(meta, FabricSerializationTypeUShort) {
+		return fmt.Errorf("expect ushort got %v", meta)
+	}
+
+	if IsEmptyMeta(meta) {
+		p.port = 80
+		return nil
+	}
+
+	return s.ReadBinary(&p.port)
+}
+
+func TestCustomUnmarshalerEmpty(t *testing.T) {
+	type S struct {
+		Ports []defaultPort
+		Port  defaultPort
+	}
+
+	from := S{Ports: []defaultPort{{80}, {0}, {443}}, Port: defaultPort{80}}
+
+	data, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var to S
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, from, to)
+}
+
+// valuePort marshals with a value receiver, unmarshal needs the pointer
+type valuePort struct {
+	port uint16
+}
+
+func (p valuePort) Marshal(s Encoder) error {
+	return (&defaultPort{port: p.port}).Marshal(s)
+}
+
+func (p *valuePort) Unmarshal(meta FabricSerializationType, s Decoder) error {
+	d := defaultPort{}
+	if err := d.Unmarshal(meta, s); err != nil {
+		return err
+	}
+
+	p.port = d.port
+	return nil
+}
+
+func TestCustomMarshalerNonAddressable(t *testing.T) {
+	type S struct {
+		Pointer map[string]defaultPort
+		Value   map[string]valuePort
+		Arrays  map[string][2]valuePort
+	}
+
+	from := S{
+		Pointer: map[string]defaultPort{"a": {80}},
+		Value:   map[string]valuePort{"b": {80}},
+		Arrays:  map[string][2]valuePort{"c": {{80}, {443}}},
+	}
+
+	data, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// map values are not addressable, the custom empty meta is still written
+	assert.Equal(t, 3, bytes.Count(data, []byte{byte(FabricSerializationTypeUShort | FabricSerializationTypeEmptyValueBit)}))
+
+	var to S
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, from, to)
+}
+
 type BasicObject struct {
 	Char1     int8
 	Uchar1    uint8
@@ -117,6 +223,251 @@ func TestMapSerialization(t *testing.T) {
 	}
 }
 
+func TestNestedMapSerialization(t *testing.T) {
+	type nestedMapObj struct {
+		Map map[string]map[int32]string
+	}
+
+	from := nestedMapObj{
+		Map: map[string]map[int32]string{
+			"a": {1: "x", 2: "y"},
+			"b": {},
+			"c": nil,
+		},
+	}
+
+	data, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var to nestedMapObj
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	// empty inner map has no entries on the wire, decoded as nil
+	assert.Len(t, to.Map, 3)
+	assert.Equal(t, from.Map["a"], to.Map["a"])
+	assert.Nil(t, to.Map["b"])
+	assert.Nil(t, to.Map["c"])
+
+	t.Run("layout", func(t *testing.T) {
+		data, err := Marshal(&nestedMapObj{Map: map[string]map[int32]string{"b": {}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []byte{
+			0x00,                                           // object
+			0x1e, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // object header
+			0x1f,       // scope begin
+			0x80, 0x01, // Map, object array of 1 entry
+			0x00,                                           // entry object
+			0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // entry object header
+			0x1f,                   // scope begin
+			0x8d, 0x01, 0x62, 0x00, // Key
+			0xc0,       // Value, empty object array
+			0x2f, 0x3f, // entry scope end, object end
+			0x2f, 0x3f, // scope end, object end
+		}, data)
+
+		// inner map with entries is an object array inside the entry
+		data, err = Marshal(&nestedMapObj{Map: map[string]map[int32]string{"a": {1: "x"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dump, err := Dump(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []string{
+			"Object",
+			"ScopeBegin",
+			"Object|Array",
+			"Object",
+			"ScopeBegin",
+			"WString|Array",
+			"Object|Array",
+			"Object",
+			"ScopeBegin",
+			"Int32",
+			"WString|Array",
+			"ScopeEnd",
+			"ObjectEnd",
+			"ScopeEnd",
+			"ObjectEnd",
+			"ScopeEnd",
+			"ObjectEnd",
+		}, dumpMetas(dump))
+	})
+}
+
+// dumpMetas returns the meta name of each Dump line
+func dumpMetas(dump string) []string {
+	var metas []string
+	for _, line := range strings.Split(strings.TrimSpace(dump), "\n") {
+		metas = append(metas, strings.Fields(line)[1])
+	}
+	return metas
+}
+
+func TestMapDeterministic(t *testing.T) {
+	type mapObj struct {
+		Map map[string]int32
+	}
+
+	type sliceObj struct {
+		Map []struct {
+			Key   string
+			Value int32
+		}
+	}
+
+	object := mapObj{Map: map[string]int32{"d": 4, "b": 2, "a": 1, "c": 3}}
+
+	var sorted sliceObj
+	for _, k := range []string{"a", "b", "c", "d"} {
+		sorted.Map = append(sorted.Map, struct {
+			Key   string
+			Value int32
+		}{k, object.Map[k]})
+	}
+
+	expected, err := Marshal(&sorted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		data, err := MarshalOptions{Deterministic: true}.Marshal(&object)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, expected, data)
+	}
+
+	t.Run("unordered map", func(t *testing.T) {
+		data, err := Marshal(&object)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Len(t, data, len(expected))
+
+		var object2 mapObj
+		if err := Unmarshal(data, &object2); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, object, object2)
+	})
+
+	t.Run("struct values", func(t *testing.T) {
+		type structMap struct {
+			Map map[int32]EmbeddedBase
+		}
+
+		from := structMap{Map: map[int32]EmbeddedBase{1: {A: 1, B: "1"}, 2: {A: 2, B: "2"}}}
+
+		var to structMap
+		marshalAndUnmarshal(t, &from, &to)
+		assert.Equal(t, from, to)
+	})
+
+	t.Run("unsupported key", func(t *testing.T) {
+		type keyObj struct {
+			Map map[GUID]int32
+		}
+
+		_, err := MarshalOptions{Deterministic: true}.Marshal(&keyObj{Map: map[GUID]int32{{}: 1}})
+		assert.Error(t, err)
+	})
+}
+
+func TestEmptyMap(t *testing.T) {
+	type object struct {
+		Map map[string]int32
+		N   int32
+	}
+
+	data, err := Marshal(&object{Map: map[string]int32{}, N: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	one, err := Marshal(&object{Map: map[string]int32{"a": 1}, N: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// empty and populated maps announce the same object array
+	assert.Equal(t, byte(FabricSerializationTypeEmptyValueBit|FabricSerializationTypeObject|FabricSerializationTypeArray), data[10])
+	assert.Equal(t, byte(FabricSerializationTypeObject|FabricSerializationTypeArray), one[10])
+
+	to := object{Map: map[string]int32{"stale": 1}}
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	// nil and empty maps are the same empty meta, both decode as nil
+	assert.Empty(t, to.Map)
+	assert.Equal(t, int32(1), to.N)
+}
+
+func TestStructKeyMap(t *testing.T) {
+	type key struct {
+		A, B int32
+	}
+
+	type object struct {
+		Map map[key]string
+		N   int32
+	}
+
+	// zero key and empty value are written as empty metas inside their entry object
+	from := object{Map: map[key]string{{1, 2}: "a", {}: "zero", {3, 0}: ""}, N: 1}
+
+	for _, opts := range []MarshalOptions{{}, {OrdinalFields: true}, {FieldNames: true}} {
+		data, err := opts.Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to object
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, from, to)
+	}
+}
+
+func BenchmarkMarshalSmallMap(b *testing.B) {
+	type mapObj struct {
+		Map map[string]int32
+	}
+
+	object := mapObj{Map: map[string]int32{"a": 1, "b": 2, "c": 3, "d": 4}}
+
+	for _, deterministic := range []bool{false, true} {
+		opts := MarshalOptions{Deterministic: deterministic}
+
+		b.Run(fmt.Sprintf("deterministic=%v", deterministic), func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := opts.Marshal(&object); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 func TestBasicSerialization(t *testing.T) {
 	var object BasicObject
 
@@ -544,3 +895,2374 @@ func TestObjectWithArraysVersioningV2ToV1(t *testing.T) {
 		assert.Equal(t, object1.Long64, object2.Long64)
 	}
 }
+
+func TestFixedArrayMarshal(t *testing.T) {
+	type array struct {
+		Hash    [16]byte
+		Words   [4]uint32
+		Names   [2]string
+		Flags   [2]bool
+		Objects [2]EmbeddedBase
+		Zero    [4]uint32
+	}
+
+	type slice struct {
+		Hash    []byte
+		Words   []uint32
+		Names   []string
+		Flags   []bool
+		Objects []EmbeddedBase
+		Zero    []uint32
+	}
+
+	from := array{
+		Words:   [4]uint32{1, 0, 3, 4},
+		Names:   [2]string{"a", "b"},
+		Flags:   [2]bool{true, false},
+		Objects: [2]EmbeddedBase{{A: 1}, {B: "b"}},
+	}
+	for i := range from.Hash {
+		from.Hash[i] = byte(i + 1)
+	}
+
+	data, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// same as slices of the elements, all-zero array is empty
+	expected, err := Marshal(&slice{
+		Hash:    from.Hash[:],
+		Words:   from.Words[:],
+		Names:   from.Names[:],
+		Flags:   from.Flags[:],
+		Objects: from.Objects[:],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expected, data)
+
+	var to array
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, from, to)
+}
+
+func TestFixedArrayUnmarshal(t *testing.T) {
+	type wire struct {
+		UlongArray  []uint32
+		StringArray []string
+	}
+
+	type exact struct {
+		UlongArray  [3]uint32
+		StringArray [2]string
+	}
+
+	type truncated struct {
+		UlongArray  [3]uint32 `fabric:",truncate"`
+		StringArray [2]string `fabric:",truncate"`
+	}
+
+	t.Run("exact", func(t *testing.T) {
+		var object exact
+		marshalAndUnmarshal(t, &wire{
+			UlongArray:  []uint32{1, 2, 3},
+			StringArray: []string{"a", "b"},
+		}, &object)
+
+		assert.Equal(t, exact{[3]uint32{1, 2, 3}, [2]string{"a", "b"}}, object)
+	})
+
+	t.Run("shorter", func(t *testing.T) {
+		data, err := Marshal(&wire{
+			UlongArray:  []uint32{1, 2},
+			StringArray: []string{"a", "b"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Error(t, Unmarshal(data, &exact{}))
+
+		var object truncated
+		if err := Unmarshal(data, &object); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, truncated{[3]uint32{1, 2, 0}, [2]string{"a", "b"}}, object)
+	})
+
+	t.Run("longer", func(t *testing.T) {
+		data, err := Marshal(&wire{
+			UlongArray:  []uint32{1, 2, 3, 4},
+			StringArray: []string{"a", "b", "c"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Error(t, Unmarshal(data, &exact{}))
+
+		var object truncated
+		if err := Unmarshal(data, &object); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, truncated{[3]uint32{1, 2, 3}, [2]string{"a", "b"}}, object)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		object := exact{UlongArray: [3]uint32{1, 2, 3}}
+		marshalAndUnmarshal(t, &wire{}, &object)
+		assert.Equal(t, exact{}, object)
+	})
+}
+
+func TestNilPointerBetweenFields(t *testing.T) {
+	type object struct {
+		Before string
+		Ptr    *BasicObjectVersion
+		After  uint32
+		Ptr2   *BasicObjectVersion
+		Last   int16
+	}
+
+	object1 := object{
+		Before: "before",
+		After:  0xbeef,
+		Ptr2:   &BasicObjectVersion{Ulong: 1},
+		Last:   -3,
+	}
+
+	// stale data must be reset to typed nil
+	object2 := object{
+		Ptr: &BasicObjectVersion{Ulong: 100},
+	}
+	marshalAndUnmarshal(t, &object1, &object2)
+
+	assert.Equal(t, object1, object2)
+	assert.Nil(t, object2.Ptr)
+
+	t.Run("empty meta mismatch", func(t *testing.T) {
+		data, err := Marshal(&struct {
+			Before string
+			Ptr    uint32
+		}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Error(t, Unmarshal(data, &struct {
+			Before string
+			Ptr    *BasicObjectVersion
+		}{}))
+	})
+}
+
+func TestCustomMarshalerSlice(t *testing.T) {
+	type object struct {
+		Guids []GUID
+	}
+
+	object1 := object{
+		Guids: []GUID{MustNewGuidV4(), {}, MustNewGuidV4()},
+	}
+
+	data, err := Marshal(&object1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 10 bytes object header, array meta, count, then each element by GUID.Marshal
+	assert.Equal(t, byte(FabricSerializationTypeObject|FabricSerializationTypeArray), data[10])
+	assert.Equal(t, byte(3), data[11])
+	assert.Equal(t, byte(FabricSerializationTypeGuid), data[12])
+	assert.Equal(t, byte(FabricSerializationTypeGuid|FabricSerializationTypeEmptyValueBit), data[12+1+16])
+	assert.Equal(t, byte(FabricSerializationTypeGuid), data[12+1+16+1])
+
+	var object2 object
+	if err := Unmarshal(data, &object2); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, object1, object2)
+
+	t.Run("non-addressable", func(t *testing.T) {
+		g := MustNewGuidV4()
+		cm, ok := castToMarshaler(reflect.ValueOf([1]GUID{g}).Index(0))
+		assert.True(t, ok)
+		assert.Equal(t, &g, cm)
+	})
+}
+
+type structArrayElement struct {
+	Id    int64
+	Name  string
+	Flags uint32
+}
+
+type structArrayObject struct {
+	Elements []structArrayElement
+}
+
+func mustMarshalStructArray(tb testing.TB, n int) []byte {
+	v := structArrayObject{Elements: make([]structArrayElement, n)}
+	for i := range v.Elements {
+		v.Elements[i] = structArrayElement{Id: int64(i + 1), Name: "element", Flags: uint32(i)}
+	}
+
+	data, err := Marshal(&v)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return data
+}
+
+func TestStructArrayPreallocated(t *testing.T) {
+	data := mustMarshalStructArray(t, 3)
+
+	backing := make([]structArrayElement, 4)
+	backing[2].Name = "stale"
+	backing[3].Id = 100
+
+	v := structArrayObject{Elements: backing[:0]}
+	if err := Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Len(t, v.Elements, 3)
+	assert.Equal(t, &backing[0], &v.Elements[0], "backing array should be reused")
+	assert.Equal(t, structArrayElement{Id: 3, Name: "element", Flags: 2}, v.Elements[2])
+	assert.Equal(t, int64(100), backing[3].Id, "beyond len is untouched")
+
+	t.Run("too small", func(t *testing.T) {
+		backing := make([]structArrayElement, 1)
+
+		v := structArrayObject{Elements: backing}
+		if err := Unmarshal(data, &v); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Len(t, v.Elements, 3)
+		assert.Equal(t, structArrayElement{}, backing[0])
+	})
+}
+
+func BenchmarkUnmarshalStructArray(b *testing.B) {
+	data := mustMarshalStructArray(b, 10000)
+
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var v structArrayObject
+			if err := Unmarshal(data, &v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("preallocated", func(b *testing.B) {
+		v := structArrayObject{Elements: make([]structArrayElement, 0, 10000)}
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := Unmarshal(data, &v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// only the Name string of each element is allocated, the slice once and fields and object headers not at all
+func TestUnmarshalStructArrayAllocs(t *testing.T) {
+	const n = 1000
+	data := mustMarshalStructArray(t, n)
+
+	v := structArrayObject{Elements: make([]structArrayElement, 0, n)}
+	allocs := testing.AllocsPerRun(10, func() {
+		if err := Unmarshal(data, &v); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	assert.Len(t, v.Elements, n)
+	assert.LessOrEqual(t, allocs, float64(n+10))
+}
+
+func mustMarshalStringArray(tb testing.TB, n int) ([]string, []byte) {
+	v := struct {
+		Strings []string
+	}{make([]string, n)}
+
+	for i := range v.Strings {
+		v.Strings[i] = fmt.Sprintf("string-%v", i)
+	}
+
+	data, err := Marshal(&v)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return v.Strings, data
+}
+
+func TestLargeStringArray(t *testing.T) {
+	strs, data := mustMarshalStringArray(t, 10000)
+
+	// []string count uses UInt32 meta instead of WString|Array
+	assert.Equal(t, FabricSerializationTypeUInt32, FabricSerializationType(data[1+sizeOfobjectHeader+1]))
+
+	var v struct {
+		Strings []string
+	}
+
+	if err := Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, strs, v.Strings)
+	assert.Equal(t, len(strs), cap(v.Strings))
+
+	t.Run("non ascii", func(t *testing.T) {
+		from := struct {
+			Strings []string
+		}{[]string{"héllo", "\U0001F600 smile", "中文"}}
+
+		var to struct {
+			Strings []string
+		}
+
+		marshalAndUnmarshal(t, &from, &to)
+		assert.Equal(t, from, to)
+	})
+}
+
+func TestSurrogatePairs(t *testing.T) {
+	type object struct {
+		S string `fabric:"\U0001F600"`
+	}
+
+	from := object{S: "a\U0001F600b"}
+
+	data, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// length is in code units, U+1F600 is the pair D83D DE00
+	assert.Equal(t, []byte{0x8d, 0x04, 0x61, 0x00, 0x3d, 0xd8, 0x00, 0xde, 0x62, 0x00}, data[10:20])
+
+	for _, opts := range []MarshalOptions{{}, {FieldNames: true}} {
+		data, err := opts.Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to object
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, from, to)
+
+		dump, err := Dump(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Contains(t, dump, "\"a\U0001F600b\"")
+	}
+
+	t.Run("lone surrogate", func(t *testing.T) {
+		broken := append([]byte{}, data...)
+		broken[17] = 0x00 // low surrogate DE00 becomes 0000
+
+		var to object
+		if err := Unmarshal(broken, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "a\uFFFD\x00b", to.S)
+	})
+}
+
+func TestPresentEmptyString(t *testing.T) {
+	type object struct {
+		Sentinel string
+		Present  string `fabric:",present"`
+		Named    string `fabric:"name,present"`
+	}
+
+	data, err := Marshal(&object{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []byte{
+		0xcd,       // Sentinel, empty meta
+		0x8d, 0x00, // Present, zero length wstring
+		0x8d, 0x00, // Named
+	}, data[10:15])
+
+	to := object{Sentinel: "x", Present: "y", Named: "z"}
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, object{}, to)
+
+	from := object{Sentinel: "a", Present: "b", Named: "c"}
+	data, err = Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to = object{}
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, from, to)
+
+	t.Run("untagged decoder", func(t *testing.T) {
+		data, err := Marshal(&object{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		to := struct {
+			Sentinel string
+			Present  string
+			Named    string
+		}{"x", "y", "z"}
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "", to.Present)
+		assert.Equal(t, "", to.Named)
+	})
+}
+
+func BenchmarkUnmarshalStringArray(b *testing.B) {
+	_, data := mustMarshalStringArray(b, 10000)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var v struct {
+			Strings []string
+		}
+
+		if err := Unmarshal(data, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type EmbeddedBase struct {
+	A int32
+	B string
+}
+
+type embeddedValue struct {
+	EmbeddedBase
+	C int32
+}
+
+type embeddedPointer struct {
+	*EmbeddedBase
+	C int32
+}
+
+func TestEmbeddedPointer(t *testing.T) {
+	t.Run("non-nil flattened", func(t *testing.T) {
+		data, err := Marshal(&embeddedPointer{&EmbeddedBase{1, "b"}, 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, err := Marshal(&embeddedValue{EmbeddedBase{1, "b"}, 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, expected, data)
+
+		var v embeddedPointer
+		if err := Unmarshal(data, &v); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, embeddedPointer{&EmbeddedBase{1, "b"}, 2}, v)
+	})
+
+	t.Run("nil as zero base", func(t *testing.T) {
+		from := embeddedPointer{nil, 2}
+		data, err := Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Nil(t, from.EmbeddedBase)
+
+		expected, err := Marshal(&embeddedValue{C: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, expected, data)
+
+		var v embeddedPointer
+		if err := Unmarshal(data, &v); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, embeddedPointer{&EmbeddedBase{}, 2}, v)
+	})
+}
+
+type embeddedName string
+
+func TestEmbeddedNonStruct(t *testing.T) {
+	type object struct {
+		embeddedName
+		EmbeddedBase
+		time.Time
+		N int32
+	}
+
+	// unexported non struct embed is skipped like other unexported fields
+	type wire struct {
+		A    int32
+		B    string
+		Time time.Time
+		N    int32
+	}
+
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	from := object{embeddedName: "n", EmbeddedBase: EmbeddedBase{1, "b"}, Time: at, N: 2}
+
+	data, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := Marshal(&wire{A: 1, B: "b", Time: at, N: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expected, data)
+
+	var to object
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	from.embeddedName = ""
+	assert.Equal(t, from, to)
+
+	t.Run("exported", func(t *testing.T) {
+		type Name string
+		type object struct {
+			Name
+			N int32
+		}
+
+		data, err := Marshal(&object{Name: "n", N: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, err := Marshal(&struct {
+			Name string
+			N    int32
+		}{Name: "n", N: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, expected, data)
+
+		var to object
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, object{Name: "n", N: 2}, to)
+	})
+}
+
+func TestFieldNames(t *testing.T) {
+	type child struct {
+		Name string `fabric:"name"`
+	}
+
+	type object struct {
+		EmbeddedBase
+		Child child
+		N     int32
+	}
+
+	from := object{EmbeddedBase{1, "b"}, child{"c"}, 2}
+
+	data, err := MarshalOptions{FieldNames: true}.Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var to object
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, from, to)
+
+	dump, err := Dump(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Contains(t, dump, "FieldNames A B Child N\n")
+	assert.Contains(t, dump, "FieldNames name\n")
+
+	t.Run("disabled", func(t *testing.T) {
+		data2, err := Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Less(t, len(data2), len(data))
+		assert.Equal(t, byte(headerFlagsEmpty), data2[5])
+		assert.Equal(t, byte(headerFlagsContainsExtensionData), data[5])
+	})
+}
+
+func TestFieldNamesOrder(t *testing.T) {
+	type child struct {
+		X int32
+		Y string
+	}
+
+	type object struct {
+		A       int32
+		B       string
+		Child   child
+		Removed []int32
+		C       uint64
+	}
+
+	// reordered, Removed is missing and Added is new
+	type reordered struct {
+		C     uint64
+		Added string
+		Child struct {
+			Y string
+			X int32
+		}
+		A int32
+		B string
+	}
+
+	from := object{A: 1, B: "b", Child: child{X: 2, Y: "y"}, Removed: []int32{4, 5}, C: 3}
+
+	data, err := MarshalOptions{FieldNames: true}.Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to := reordered{Added: "kept"}
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, uint64(3), to.C)
+	assert.Equal(t, "kept", to.Added)
+	assert.Equal(t, int32(2), to.Child.X)
+	assert.Equal(t, "y", to.Child.Y)
+	assert.Equal(t, int32(1), to.A)
+	assert.Equal(t, "b", to.B)
+
+	t.Run("rest", func(t *testing.T) {
+		var to struct {
+			C    uint64
+			A    int32
+			Rest Raw `fabric:",rest"`
+		}
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, uint64(3), to.C)
+		assert.Equal(t, int32(1), to.A)
+		assert.NotEmpty(t, to.Rest)
+	})
+
+	t.Run("positional without names", func(t *testing.T) {
+		data, err := Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to reordered
+		assert.Error(t, Unmarshal(data, &to))
+	})
+}
+
+func TestMarshalCycle(t *testing.T) {
+	type node struct {
+		N     int32
+		Next  *node
+		Nodes []*node
+	}
+
+	t.Run("self", func(t *testing.T) {
+		n := &node{N: 1}
+		n.Next = n
+
+		_, err := Marshal(n)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "cycle detected at *serialization.node")
+		}
+	})
+
+	t.Run("indirect", func(t *testing.T) {
+		a := &node{N: 1}
+		b := &node{N: 2, Nodes: []*node{{N: 3}, a}}
+		a.Next = b
+
+		_, err := Marshal(&node{Next: a})
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "cycle detected")
+		}
+	})
+
+	t.Run("variant", func(t *testing.T) {
+		n := &testNode{}
+		n.Next = n
+
+		_, err := Marshal(&struct{ V interface{} }{V: n})
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "cycle detected at *serialization.testNode")
+		}
+	})
+
+	t.Run("shared", func(t *testing.T) {
+		shared := &node{N: 2}
+		from := node{N: 1, Next: shared, Nodes: []*node{shared, shared}}
+
+		data, err := Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to node
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, from, to)
+		assert.NotSame(t, to.Nodes[0], to.Nodes[1])
+	})
+
+	t.Run("first field", func(t *testing.T) {
+		type inner struct{ N int32 }
+		type outer struct {
+			In  inner
+			Ptr *inner
+		}
+
+		from := &outer{In: inner{N: 1}}
+		from.Ptr = &from.In
+
+		_, err := Marshal(from)
+		assert.NoError(t, err)
+	})
+}
+
+func TestMarshalErrorPath(t *testing.T) {
+	type node struct {
+		Endpoint interface{}
+	}
+
+	type cluster struct {
+		Nodes []node
+		Props map[string]*node
+	}
+
+	type object struct {
+		Cluster cluster
+	}
+
+	unsupported := func(v interface{}) string {
+		return fmt.Sprintf("type %v not registered", reflect.TypeOf(v))
+	}
+
+	cases := []struct {
+		from object
+		path string
+	}{
+		{
+			from: object{Cluster: cluster{Nodes: []node{{}, {}, {}, {Endpoint: struct{}{}}}}},
+			path: "Cluster.Nodes[3].Endpoint",
+		},
+		{
+			from: object{Cluster: cluster{Props: map[string]*node{"a": {Endpoint: struct{}{}}}}},
+			path: "Cluster.Props[a].Endpoint",
+		},
+	}
+
+	for _, opts := range []MarshalOptions{{}, {OrdinalFields: true}} {
+		for _, c := range cases {
+			_, err := opts.Marshal(&c.from)
+
+			me, ok := err.(*MarshalError)
+			if !assert.True(t, ok, "%v", err) {
+				continue
+			}
+
+			assert.Equal(t, c.path, me.Path)
+			assert.EqualError(t, me.Err, unsupported(struct{}{}))
+			assert.EqualError(t, err, "field "+c.path+": "+unsupported(struct{}{}))
+		}
+	}
+}
+
+func TestSkipField(t *testing.T) {
+	type Cache struct {
+		Hits int32
+	}
+
+	type object struct {
+		A     int32
+		Cache `fabric:"-"`
+		Sum   int64  `fabric:"-"`
+		Dash  string `fabric:"-,"`
+		B     string
+	}
+
+	type wire struct {
+		A    int32
+		Dash string `fabric:"-,"`
+		B    string
+	}
+
+	from := object{A: 1, Cache: Cache{Hits: 2}, Sum: 3, Dash: "d", B: "b"}
+
+	for _, opts := range []MarshalOptions{{}, {OrdinalFields: true}, {FieldNames: true}} {
+		data, err := opts.Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, err := opts.Marshal(&wire{A: 1, Dash: "d", B: "b"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, expected, data)
+
+		to := object{Sum: 4}
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, object{A: 1, Sum: 4, Dash: "d", B: "b"}, to)
+	}
+}
+
+type unexportedBase struct {
+	Base   int32
+	hidden int32
+}
+
+func TestUnexportedFields(t *testing.T) {
+	type object struct {
+		unexportedBase
+		A      int32
+		b      string
+		c      *object
+		guid   GUID
+		D      string
+		closer func()
+	}
+
+	type wire struct {
+		Base int32
+		A    int32
+		D    string
+	}
+
+	from := object{
+		unexportedBase: unexportedBase{Base: 1, hidden: 2},
+		A:              3,
+		b:              "b",
+		guid:           MustNewGuidV4(),
+		D:              "d",
+		closer:         func() {},
+	}
+	from.c = &from
+
+	for _, opts := range []MarshalOptions{{}, {OrdinalFields: true}} {
+		data, err := opts.Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, err := opts.Marshal(&wire{Base: 1, A: 3, D: "d"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, expected, data)
+
+		var to object
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, object{unexportedBase: unexportedBase{Base: 1}, A: 3, D: "d"}, to)
+	}
+}
+
+func TestMarshalTo(t *testing.T) {
+	a := &BasicObject{Bool1: true, Ulong64_1: 1, String: "a"}
+	b := &BasicObject{Ulong64_1: 2, String: "b"}
+
+	expectedA, err := Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedB, err := Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := make([]byte, 0, 1024)
+	data, err := MarshalTo(dst, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expectedA, data)
+	assert.Same(t, &dst[:1][0], &data[0], "fits in dst without growing")
+
+	data, err = MarshalTo(data, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, append(append([]byte{}, expectedA...), expectedB...), data)
+
+	t.Run("grow", func(t *testing.T) {
+		data, err := MarshalTo([]byte{1, 2}, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, append([]byte{1, 2}, expectedA...), data)
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		data, err := MarshalTo([]byte{1}, []byte{2, 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []byte{1, 2, 3}, data)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		data, err := MarshalTo([]byte{1}, &struct{ C chan int }{make(chan int)})
+		assert.Error(t, err)
+		assert.Equal(t, []byte{1}, data)
+	})
+}
+
+func BenchmarkMarshalTo(b *testing.B) {
+	object := &BasicObject{Bool1: true, Ulong64_1: 1, String: "a"}
+
+	b.Run("Marshal", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			if _, err := Marshal(object); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("MarshalTo", func(b *testing.B) {
+		b.ReportAllocs()
+
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			var err error
+			if buf, err = MarshalTo(buf[:0], object); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestEncodeStatePool(t *testing.T) {
+	type child struct {
+		N    int32
+		Next *child
+	}
+
+	type object struct {
+		Children []child
+		Name     string
+	}
+
+	from := &object{Children: []child{{N: 1}, {N: 2, Next: &child{N: 3}}}, Name: "n"}
+
+	expected, err := Marshal(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cycle := &child{N: 1}
+	cycle.Next = cycle
+
+	for i := 0; i < 3; i++ {
+		// failures leave no state behind for the next use of a pooled state
+		_, err := Marshal(cycle)
+		assert.Error(t, err)
+
+		_, err = Marshal(&struct{ C chan int }{make(chan int)})
+		assert.Error(t, err)
+
+		data, err := MarshalOptions{OrdinalFields: i%2 == 0}.Marshal(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i%2 != 0 {
+			assert.Equal(t, expected, data)
+		}
+
+		var to object
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, from, &to)
+	}
+
+	t.Run("large buffer dropped", func(t *testing.T) {
+		s := &encodeState{}
+		s.releaseBuffer(bytes.NewBuffer(make([]byte, 0, maxPooledBufferSize+1)))
+		assert.Empty(t, s.free)
+
+		s.releaseBuffer(bytes.NewBuffer([]byte{1}))
+		assert.Len(t, s.free, 1)
+		assert.Equal(t, 0, s.free[0].Len())
+	})
+}
+
+func TestStructPlanCache(t *testing.T) {
+	type object struct {
+		EmbeddedBase
+		N      int32 `fabric:"n"`
+		hidden int32
+		Skip   int32 `fabric:"-"`
+	}
+
+	typ := reflect.TypeOf(object{})
+	plan := planOf(typ)
+	assert.Equal(t, []fieldPlan{
+		{index: 0, flatten: true},
+		{index: 1, tag: fieldTag{name: "n"}, name: "n"},
+	}, plan)
+	assert.Equal(t, reflect.ValueOf(plan).Pointer(), reflect.ValueOf(planOf(typ)).Pointer())
+
+	from := object{EmbeddedBase: EmbeddedBase{1, "b"}, N: 2}
+	expected, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			data, err := Marshal(&from)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, data)
+
+			var to object
+			assert.NoError(t, Unmarshal(data, &to))
+			assert.Equal(t, from, to)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func BenchmarkMarshalNested(b *testing.B) {
+	type child struct {
+		N    int32
+		Name string
+	}
+
+	type object struct {
+		Children []child
+		Child    *child
+	}
+
+	from := &object{Children: []child{{1, "a"}, {2, "b"}, {3, "c"}}, Child: &child{4, "d"}}
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := Marshal(from); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMarshalNil(t *testing.T) {
+	_, err := Marshal(nil)
+	assert.EqualError(t, err, "cannot marshal nil")
+
+	var i interface{}
+	_, err = Marshal(i)
+	assert.EqualError(t, err, "cannot marshal nil")
+
+	_, err = Marshal((*BasicObject)(nil))
+	assert.EqualError(t, err, "cannot marshal nil *serialization.BasicObject")
+
+	i = (*BasicObject)(nil)
+	_, err = Marshal(i)
+	assert.EqualError(t, err, "cannot marshal nil *serialization.BasicObject")
+
+	assert.EqualError(t, NewStreamEncoder(nil).Encode(nil), "cannot marshal nil")
+}
+
+func TestOrdinalFields(t *testing.T) {
+	type child struct {
+		Strings []string
+		N       int64
+	}
+
+	type object struct {
+		A     int32
+		B     string
+		Child *child
+		C     []uint64
+	}
+
+	opts := MarshalOptions{OrdinalFields: true}
+
+	from := object{A: 1, B: "b", Child: &child{Strings: []string{"x", "y"}, N: -1}, C: []uint64{1, 2}}
+	data, err := opts.Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var to object
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, from, to)
+
+	// writes fields of v by given ordinals in that order
+	craft := func(v interface{}, ordinals ...int) []byte {
+		s := &encodeState{opts: opts}
+		s.pushBuffer()
+		assert.NoError(t, s.objectScopeBegin())
+
+		fields := allFields(reflect.ValueOf(v).Elem(), false)
+		for _, i := range ordinals {
+			assert.NoError(t, s.ordinalField(i, fields[i]))
+		}
+
+		assert.NoError(t, s.objectScopeEnd(nil, nil))
+		return s.buf.Bytes()
+	}
+
+	t.Run("reordered", func(t *testing.T) {
+		var to object
+		if err := Unmarshal(craft(&from, 3, 1, 2, 0), &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, from, to)
+	})
+
+	t.Run("sparse", func(t *testing.T) {
+		sparse := object{B: "b"}
+
+		data, err := opts.Marshal(&sparse)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wide := BasicObject{String: "b"}
+
+		sparseWide, err := opts.Marshal(&wide)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		denseWide, err := Marshal(&wide)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Less(t, len(sparseWide), len(denseWide))
+
+		var to object
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, sparse, to)
+
+		dump, err := Dump(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Contains(t, dump, "Field ordinal=1 len=4\n")
+	})
+
+	t.Run("unknown ordinals", func(t *testing.T) {
+		type newer struct {
+			A       int32
+			Strings []string
+			B       string
+		}
+
+		type older struct {
+			A int32
+		}
+
+		var to older
+		if err := Unmarshal(craft(&newer{1, []string{"x"}, "b"}, 2, 1, 0), &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, older{1}, to)
+	})
+}
+
+type nestedNode struct {
+	Value int32
+	Next  *nestedNode
+}
+
+func mustMarshalNested(t *testing.T, depth int) []byte {
+	root := &nestedNode{Value: 1}
+	for n, i := root, 1; i < depth; i++ {
+		n.Next = &nestedNode{Value: int32(i + 1)}
+		n = n.Next
+	}
+
+	data, err := MarshalOptions{MaxDepth: -1}.Marshal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestUnmarshalMaxDepth(t *testing.T) {
+	data := mustMarshalNested(t, 20)
+
+	t.Run("within limit", func(t *testing.T) {
+		var n nestedNode
+		assert.NoError(t, UnmarshalOptions{MaxDepth: 20}.Unmarshal(data, &n))
+
+		depth := 0
+		for p := &n; p != nil; p = p.Next {
+			depth++
+		}
+		assert.Equal(t, 20, depth)
+	})
+
+	t.Run("beyond limit", func(t *testing.T) {
+		var n nestedNode
+		assert.Error(t, UnmarshalOptions{MaxDepth: 19}.Unmarshal(data, &n))
+	})
+
+	t.Run("default limit", func(t *testing.T) {
+		data := mustMarshalNested(t, DefaultMaxDepth+1)
+
+		var n nestedNode
+		assert.Error(t, Unmarshal(data, &n))
+		assert.NoError(t, UnmarshalOptions{MaxDepth: -1}.Unmarshal(data, &n))
+	})
+}
+
+func TestPointerToSliceAndMap(t *testing.T) {
+	type node struct {
+		V int32
+	}
+
+	type object struct {
+		A *[]int32
+		B *map[string]int32
+		C *[]node
+		D *[]int32
+		E *map[string]int32
+		F *[]node
+	}
+
+	a := []int32{1, 2}
+	b := map[string]int32{"x": 1}
+	c := []node{{V: 1}}
+
+	from := object{A: &a, B: &b, C: &c}
+
+	data, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nil pointers are the empty pointer meta
+	assert.Equal(t, []byte{
+		byte(FabricSerializationTypePointer | FabricSerializationTypeEmptyValueBit),
+		byte(FabricSerializationTypePointer | FabricSerializationTypeEmptyValueBit),
+		byte(FabricSerializationTypePointer | FabricSerializationTypeEmptyValueBit),
+		byte(FabricSerializationTypeScopeEnd),
+		byte(FabricSerializationTypeObjectEnd),
+	}, data[len(data)-5:])
+
+	var to object
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, from, to)
+
+	t.Run("empty", func(t *testing.T) {
+		a := []int32{}
+		b := map[string]int32{}
+
+		data, err := Marshal(&object{A: &a, B: &b})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to object
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		// pointer is kept, empty is written the same as nil
+		if assert.NotNil(t, to.A) && assert.NotNil(t, to.B) {
+			assert.Nil(t, *to.A)
+			assert.Nil(t, *to.B)
+		}
+	})
+}
+
+func TestDoublePointers(t *testing.T) {
+	type object struct {
+		A **int32
+		B **int32
+		C **int32
+		D []**int32
+	}
+
+	v := int32(3)
+	pv := &v
+	var nilInner *int32
+
+	from := object{A: &pv, B: &nilInner, D: []**int32{&pv, &nilInner, nil}}
+
+	data, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one pointer meta per level, the first nil level is the empty pointer meta
+	assert.Equal(t, []byte{
+		0x01, 0x01, 0x07, 0x03, // A
+		0x01, 0x41, // B, inner nil
+		0x41, // C, both nil
+	}, data[10:17])
+
+	var to object
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, from, to)
+	if assert.NotNil(t, to.B) {
+		assert.Nil(t, *to.B)
+	}
+	assert.Nil(t, to.C)
+}
+
+func TestNativeInt(t *testing.T) {
+	type native struct {
+		A int
+		B uint
+		C int
+		D []int
+		E map[int]uint
+	}
+
+	type wide struct {
+		A int64
+		B uint64
+		C int64
+		D []int64
+		E map[int64]uint64
+	}
+
+	from := native{A: -1 << 40, B: 1 << 63, D: []int{0, 7}, E: map[int]uint{1: 0}}
+
+	data, err := MarshalOptions{Deterministic: true}.Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// int and uint are the 64 bit metas
+	expected, err := MarshalOptions{Deterministic: true}.Marshal(&wide{A: -1 << 40, B: 1 << 63, D: []int64{0, 7}, E: map[int64]uint64{1: 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expected, data)
+
+	var to native
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, from, to)
+
+	t.Run("variant", func(t *testing.T) {
+		data, err := Marshal(&struct{ V interface{} }{int(5)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to struct{ V interface{} }
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, int64(5), to.V)
+	})
+}
+
+func TestBigEndian(t *testing.T) {
+	type object struct {
+		A float64
+		B string
+		C GUID
+		D uint32
+	}
+
+	g := GUID{0x01020304, 0x0506, 0x0708, [8]byte{9, 10, 11, 12, 13, 14, 15, 16}}
+	from := object{A: 1, B: "a", C: g, D: 300}
+
+	little, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	big, err := MarshalOptions{BigEndian: true}.Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(little), len(big))
+	assert.Equal(t, []byte{0x00, 0x00, 0x00, byte(len(big) - 1)}, big[1:5], "object size")
+	assert.Equal(t, []byte{0x3f, 0xf0, 0, 0, 0, 0, 0, 0}, big[11:19], "double")
+	assert.Equal(t, []byte{0x8d, 0x01, 0x00, 0x61}, big[19:23], "wstring")
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, big[24:40], "guid")
+	assert.Equal(t, little[40:], big[40:], "compressed integers have no byte order")
+
+	// the default stays little endian
+	var to object
+	if err := Unmarshal(little, &to); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, from, to)
+}
+
+func TestNestedObjectSizes(t *testing.T) {
+	type leaf struct {
+		A int32
+		B string
+	}
+
+	type middle struct {
+		Leaf  leaf
+		Leafs []leaf
+		C     uint16
+	}
+
+	type root struct {
+		Middle    middle
+		MiddlePtr *middle
+		Variant   interface{}
+	}
+
+	m := middle{Leaf: leaf{A: 1, B: "b"}, Leafs: []leaf{{A: 2}, {B: "cc"}}, C: 3}
+	from := root{Middle: m, MiddlePtr: &m, Variant: &testNode{}}
+
+	for _, opts := range []MarshalOptions{{}, {FieldNames: true}, {OrdinalFields: true}} {
+		data, err := opts.Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dump, err := Dump(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// each Object line is matched by the next ObjectEnd at its indent
+		type open struct {
+			offset, size int
+			indent       int
+		}
+
+		var stack []open
+		objects := 0
+		for _, line := range strings.Split(strings.TrimSpace(dump), "\n") {
+			var offset int
+			if _, err := fmt.Sscanf(line[:4], "%x", &offset); err != nil {
+				t.Fatal(err)
+			}
+
+			text := line[5:]
+			indent := len(text) - len(strings.TrimLeft(text, " "))
+			text = strings.TrimSpace(text)
+
+			if strings.HasPrefix(text, "Object size=") {
+				var size int
+				if _, err := fmt.Sscanf(text, "Object size=%d", &size); err != nil {
+					t.Fatal(err)
+				}
+
+				stack = append(stack, open{offset: offset, size: size, indent: indent})
+				objects++
+			} else if text == "ObjectEnd" {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+
+				assert.Equal(t, indent, top.indent)
+				// size spans the header through ObjectEnd, the object meta before the header excluded
+				assert.Equal(t, offset-top.offset, top.size, "object at 0x%04x with %+v", top.offset, opts)
+			}
+		}
+
+		assert.Empty(t, stack)
+		assert.Equal(t, 10, objects)
+		assert.Equal(t, uint32(len(data)-1), binary.LittleEndian.Uint32(data[1:5]), "root object size")
+	}
+}
+
+type testByte uint8
+
+type testChar int8
+
+func TestByteSliceElements(t *testing.T) {
+	type fast struct {
+		A []byte
+		B []int8
+		C [4]byte
+	}
+
+	// named element types are written by value per element
+	type slow struct {
+		A []testByte
+		B []testChar
+		C [4]testByte
+	}
+
+	data, err := Marshal(&fast{
+		A: []byte{0, 1, 0xff},
+		B: []int8{-1, 0, 1},
+		C: [4]byte{1, 0, 2, 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := Marshal(&slow{
+		A: []testByte{0, 1, 0xff},
+		B: []testChar{-1, 0, 1},
+		C: [4]testByte{1, 0, 2, 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, expected, data)
+	assert.Equal(t, []byte{0x84, 0x03, 0x44, 0x04, 0x01, 0x04, 0xff}, data[10:17])
+
+	var to fast
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, fast{A: []byte{0, 1, 0xff}, B: []int8{-1, 0, 1}, C: [4]byte{1, 0, 2, 0}}, to)
+}
+
+func BenchmarkMarshalBytes(b *testing.B) {
+	content := make([]byte, 64*1024)
+	for i := range content {
+		content[i] = byte(i)
+	}
+
+	b.Run("bytes", func(b *testing.B) {
+		v := &struct{ A []byte }{content}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := Marshal(v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("per element", func(b *testing.B) {
+		named := make([]testByte, len(content))
+		for i, c := range content {
+			named[i] = testByte(c)
+		}
+
+		v := &struct{ A []testByte }{named}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := Marshal(v); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestBytesPassthrough(t *testing.T) {
+	type object struct {
+		A int32
+	}
+
+	data, err := Marshal(&object{A: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data, b)
+
+	var raw []byte
+	assert.NoError(t, Unmarshal(b, &raw))
+	assert.Equal(t, data, raw)
+
+	raw[0] = 0xff
+	assert.Equal(t, byte(FabricSerializationTypeObject), data[0], "unmarshal copies")
+
+	var buf bytes.Buffer
+	e := NewStreamEncoder(&buf)
+	assert.NoError(t, e.Encode(data))
+	assert.NoError(t, e.Flush())
+	assert.Equal(t, data, buf.Bytes())
+
+	var to object
+	assert.NoError(t, Unmarshal(buf.Bytes(), &to))
+	assert.Equal(t, object{A: 1}, to)
+}
+
+func TestMarshalMaxDepth(t *testing.T) {
+	root := &nestedNode{Value: 1}
+	for n, i := root, 1; i < 100000; i++ {
+		n.Next = &nestedNode{Value: int32(i + 1)}
+		n = n.Next
+	}
+
+	_, err := Marshal(root)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "exceeds max depth 1000")
+	}
+
+	_, err = MarshalOptions{MaxDepth: 20}.Marshal(&nestedNode{Next: &nestedNode{}})
+	assert.NoError(t, err)
+
+	_, err = MarshalOptions{MaxDepth: 1}.Marshal(&nestedNode{Next: &nestedNode{}})
+	assert.Error(t, err)
+
+	// a failed marshal leaves no depth behind in the pooled state
+	_, err = MarshalOptions{MaxDepth: 2}.Marshal(&nestedNode{Next: &nestedNode{}})
+	assert.NoError(t, err)
+}
+
+func TestUnmarshalMaxAllocBytes(t *testing.T) {
+	type object struct {
+		A []int64
+		B string
+	}
+
+	data, err := Marshal(&object{A: []int64{1}, B: "abcde"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("within limit", func(t *testing.T) {
+		var to object
+		assert.NoError(t, UnmarshalOptions{MaxAllocBytes: 10}.Unmarshal(data, &to))
+		assert.Equal(t, object{A: []int64{1}, B: "abcde"}, to)
+	})
+
+	t.Run("string beyond limit", func(t *testing.T) {
+		var to object
+		assert.Error(t, UnmarshalOptions{MaxAllocBytes: 9}.Unmarshal(data, &to))
+	})
+
+	t.Run("huge count", func(t *testing.T) {
+		type count struct {
+			N uint32
+		}
+
+		n, err := Marshal(&count{N: 0xffffffff})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// object, header, scope begin, int64 array meta, then the count 1
+		assert.Equal(t, byte(0x01), data[11])
+		huge := append(append(append([]byte{}, data[:11]...), n[11:len(n)-2]...), data[12:]...)
+
+		var to object
+		err = Unmarshal(huge, &to)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "exceed max alloc")
+		}
+	})
+}
+
+func TestBoolPositions(t *testing.T) {
+	type boolObj struct {
+		Field  bool
+		Slice  []bool
+		Map    map[int32]bool
+		Ptr    *bool
+		Nested struct {
+			Field bool
+		}
+	}
+
+	tr := true
+
+	from := boolObj{
+		Field: true,
+		Slice: []bool{true, false, true},
+		Map:   map[int32]bool{1: true, 2: false},
+		Ptr:   &tr,
+	}
+	from.Nested.Field = true
+
+	data, err := MarshalOptions{Deterministic: true}.Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bool never carries a value byte, the value is in the meta in every position
+	dump, err := Dump(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NotContains(t, dump, "Bool|Empty")
+	assert.Equal(t, 1+3+2+1+1, strings.Count(dump, "Bool true")+strings.Count(dump, "Bool false"))
+
+	var to boolObj
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, from, to)
+
+	t.Run("layout", func(t *testing.T) {
+		type twoBools struct {
+			A bool
+			B bool
+		}
+
+		data, err := Marshal(&twoBools{A: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []byte{
+			0x00,                                           // object
+			0x0d, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // object header
+			0x1f,       // scope begin
+			0x42,       // A, true
+			0x72,       // B, false
+			0x2f, 0x3f, // scope end, object end
+		}, data)
+
+		var to twoBools
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, twoBools{A: true}, to)
+	})
+
+	t.Run("value byte rejected", func(t *testing.T) {
+		type oneBool struct {
+			A bool
+		}
+
+		// non-empty bool meta followed by a value byte is not a valid encoding
+		data := []byte{
+			0x00,
+			0x0d, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x1f,
+			0x02, 0x01,
+			0x2f, 0x3f,
+		}
+
+		var to oneBool
+		assert.Error(t, Unmarshal(data, &to))
+	})
+}
+
+func TestCanonical(t *testing.T) {
+	type entry struct {
+		Tags map[string]string
+	}
+
+	type canonicalObj struct {
+		Map     map[string]int32
+		Entries []entry
+		Double  float64
+		Slice   []int32
+	}
+
+	from := canonicalObj{
+		Map: make(map[string]int32),
+		Entries: []entry{
+			{Tags: map[string]string{"z": "1", "y": "2", "x": "3"}},
+			{Tags: map[string]string{"b": "1", "a": "2"}},
+		},
+	}
+
+	for i := 0; i < 50; i++ {
+		from.Map[strconv.Itoa(i)] = int32(i)
+	}
+
+	opts := MarshalOptions{Canonical: true}
+
+	expected, err := opts.Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		data, err := opts.Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, expected, data)
+	}
+
+	var to canonicalObj
+	if err := Unmarshal(expected, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, from.Map, to.Map)
+	assert.Equal(t, from.Entries, to.Entries)
+
+	t.Run("empty vs nil", func(t *testing.T) {
+		a, err := opts.Marshal(&canonicalObj{Map: map[string]int32{}, Slice: []int32{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := opts.Marshal(&canonicalObj{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, a, b)
+	})
+
+	t.Run("negative zero", func(t *testing.T) {
+		a, err := opts.Marshal(&canonicalObj{Double: math.Copysign(0, -1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := opts.Marshal(&canonicalObj{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, a, b)
+	})
+
+	t.Run("NaN", func(t *testing.T) {
+		_, err := opts.Marshal(&canonicalObj{Double: math.NaN()})
+		assert.Error(t, err)
+	})
+
+	t.Run("unordered key", func(t *testing.T) {
+		type keyObj struct {
+			Map map[GUID]int32
+		}
+
+		_, err := opts.Marshal(&keyObj{Map: map[GUID]int32{{}: 1}})
+		assert.Error(t, err)
+	})
+}
+
+// extendHeader grows the header of the object whose meta is at offset at by n unknown bytes
+func extendHeader(data []byte, at int, n int) []byte {
+	extended := append([]byte{}, data[:at+1+int(sizeOfobjectHeader)]...)
+	for i := 0; i < n; i++ {
+		extended = append(extended, 0xEE)
+	}
+	extended = append(extended, data[at+1+int(sizeOfobjectHeader):]...)
+
+	header := extended[at+1:]
+	binary.LittleEndian.PutUint32(header, binary.LittleEndian.Uint32(header)+uint32(n))
+	header[4] |= byte(headerFlagsExtendedHeader)
+	header[5] = byte(int(sizeOfobjectHeader) + n)
+
+	return extended
+}
+
+func TestExtendedObjectHeader(t *testing.T) {
+	type object struct {
+		A int32
+		B string
+	}
+
+	from := object{A: 1, B: "b"}
+
+	data, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extended := extendHeader(data, 0, 5)
+
+	var to object
+	if err := Unmarshal(extended, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, from, to)
+
+	dump, err := Dump(extended)
+	assert.NoError(t, err)
+	assert.Contains(t, dump, "ExtendedHeader len=5")
+
+	t.Run("with type information", func(t *testing.T) {
+		type variantObj struct {
+			Shape testShape
+			N     int32
+		}
+
+		from := variantObj{Shape: testSquare{S: 2}, N: 3}
+
+		data, err := Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// outer object, then pointer meta at 10 and the registered object at 11
+		extended := extendHeader(data, 11, 3)
+		binary.LittleEndian.PutUint32(extended[1:], binary.LittleEndian.Uint32(extended[1:])+3)
+
+		var to variantObj
+		if err := Unmarshal(extended, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, from, to)
+	})
+
+	t.Run("declared size too small", func(t *testing.T) {
+		bad := extendHeader(data, 0, 0)
+		bad[6] = 4
+
+		var to object
+		assert.Error(t, Unmarshal(bad, &to))
+	})
+}
+
+func TestDecodeN(t *testing.T) {
+	type first struct {
+		A int32
+		B string
+	}
+
+	type second struct {
+		C []int64
+		D *first
+	}
+
+	f := first{A: 1, B: "b"}
+	s := second{C: []int64{2, 3}, D: &first{A: 4}}
+
+	var data []byte
+	for _, v := range []interface{}{&f, &s} {
+		b, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data = append(data, b...)
+	}
+
+	var f2 first
+	n, err := DecodeN(data, &f2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, f, f2)
+	// meta byte and the size in object header
+	assert.Equal(t, 1+int(binary.LittleEndian.Uint32(data[1:])), n)
+
+	var s2 second
+	m, err := DecodeN(data[n:], &s2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, s, s2)
+	assert.Equal(t, len(data), n+m)
+
+	t.Run("truncated", func(t *testing.T) {
+		var s2 second
+		n, err := DecodeN(data[len(data)-m:len(data)-1], &s2)
+		assert.Error(t, err)
+		assert.Equal(t, 0, n)
+	})
+}
+
+func TestFloatSerialization(t *testing.T) {
+	type object struct {
+		F64  float64
+		F32  float32
+		Zero float64
+	}
+
+	from := object{F64: 1.5, F32: -2.25}
+
+	data, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bits := func(f float64) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, math.Float64bits(f))
+		return b
+	}
+
+	// float32 is written as double, there is no single precision meta
+	var body []byte
+	body = append(body, byte(FabricSerializationTypeDouble))
+	body = append(body, bits(1.5)...)
+	body = append(body, byte(FabricSerializationTypeDouble))
+	body = append(body, bits(-2.25)...)
+	body = append(body, byte(FabricSerializationTypeEmptyValueBit|FabricSerializationTypeDouble))
+
+	assert.Equal(t, body, data[10:len(data)-2])
+
+	var to object
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, from, to)
+}
+
+func TestNumericSliceMeta(t *testing.T) {
+	type object struct {
+		I32 []int32
+		U64 []uint64
+		U16 []uint16
+	}
+
+	from := object{I32: []int32{1, 2, 3}, U64: []uint64{4}, U16: []uint16{5}}
+
+	data, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// element type meta with array bit, count, then each element with its own meta
+	assert.Equal(t, []byte{
+		0x87, 0x03, 0x07, 0x01, 0x07, 0x02, 0x07, 0x03,
+		0x8a, 0x01, 0x0a, 0x04,
+		0x86, 0x01, 0x06, 0x05,
+	}, data[10:len(data)-2])
+
+	var to object
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, from, to)
+}
+
+func TestRuneSlice(t *testing.T) {
+	type object struct {
+		Runes []rune
+	}
+
+	from := object{Runes: []rune("aé中\U0001F600")}
+
+	data, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, byte(FabricSerializationTypeInt32|FabricSerializationTypeArray), data[10])
+
+	var to object
+	if err := Unmarshal(data, &to); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, from, to)
+	assert.Equal(t, "aé中\U0001F600", string(to.Runes))
+
+	t.Run("not wstring", func(t *testing.T) {
+		var str struct {
+			Runes string
+		}
+		assert.Error(t, Unmarshal(data, &str))
+
+		data, err := Marshal(&struct{ Runes string }{Runes: "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to object
+		assert.Error(t, Unmarshal(data, &to))
+	})
+}
+
+func TestRequiredField(t *testing.T) {
+	type child struct {
+		N int32
+	}
+
+	type object struct {
+		Optional *child
+		Required *child `fabric:",required"`
+		Name     string
+	}
+
+	for _, opts := range []MarshalOptions{{}, {OrdinalFields: true}} {
+		_, err := opts.Marshal(&object{Name: "n"})
+		assert.EqualError(t, err, "field Required: required *serialization.child is nil")
+
+		from := object{Required: &child{N: 1}}
+		data, err := opts.Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to object
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, from, to)
+	}
+
+	t.Run("nested", func(t *testing.T) {
+		type parent struct {
+			Children []object
+		}
+
+		_, err := Marshal(&parent{Children: []object{{Required: &child{}}, {}}})
+		assert.Error(t, err)
+	})
+
+	t.Run("without comma", func(t *testing.T) {
+		_, err := Marshal(&struct {
+			Required *child `fabric:"required"`
+		}{})
+		assert.Error(t, err)
+	})
+}
+
+func TestEmptyStructs(t *testing.T) {
+	type inner struct {
+		X int32
+		Y string
+	}
+
+	type object struct {
+		A inner
+		B inner
+		C []inner
+		D *inner
+		E GUID
+	}
+
+	from := object{
+		B: inner{X: 1},
+		C: []inner{{}, {Y: "c"}},
+		D: &inner{},
+	}
+
+	full, err := Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := MarshalOptions{EmptyStructs: true}.Marshal(&from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Less(t, len(data), len(full))
+	assert.Equal(t, byte(FabricSerializationTypeObject), data[0], "root is always an object")
+	assert.Equal(t, byte(FabricSerializationTypeObject|FabricSerializationTypeEmptyValueBit), data[10], "A")
+	assert.Equal(t, byte(FabricSerializationTypeObject), data[11], "B")
+	assert.Equal(t, []byte{0x01, 0x40, 0x4c, 0x2f, 0x3f}, data[len(data)-5:], "D pointer to empty struct, E empty guid, scope end, object end")
+
+	for _, opts := range []UnmarshalOptions{{}, {Lenient: true}, {Strict: true}} {
+		to := object{A: inner{X: 5, Y: "stale"}}
+		if err := opts.Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, from, to)
+	}
+
+	t.Run("zero root", func(t *testing.T) {
+		full, err := Marshal(&inner{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := MarshalOptions{EmptyStructs: true}.Marshal(&inner{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, full, data)
+
+		to := inner{X: 5}
+		assert.NoError(t, Unmarshal(data, &to))
+		assert.Equal(t, inner{}, to)
+	})
+}
+
+func TestUnsupportedTypeError(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		v      interface{}
+		typ    reflect.Type
+		expect string
+	}{
+		{"value", &struct{ C chan int }{C: make(chan int)}, reflect.TypeOf(make(chan int)), "field C: unsupported marshal type chan int"},
+		{"empty", &struct{ C complex64 }{}, reflect.TypeOf(complex64(0)), "field C: unsupported marshal type complex64"},
+		{"empty slice", &struct{ C []func() }{}, reflect.TypeOf(func() {}), "field C: unsupported marshal type func()"},
+		{"slice", &struct{ C []func() }{C: []func(){nil}}, reflect.TypeOf(func() {}), "field C: unsupported marshal type func()"},
+		{"nested slice", &struct{ C [][]int32 }{C: [][]int32{{1}}}, reflect.TypeOf([]int32{}), "field C: unsupported marshal type []int32"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := Marshal(c.v)
+			if !assert.Error(t, err) {
+				return
+			}
+
+			assert.Equal(t, c.expect, err.Error())
+
+			var ute *UnsupportedTypeError
+			if assert.True(t, errors.As(err, &ute), "%v", err) {
+				assert.Equal(t, c.typ, ute.Type)
+			}
+		})
+	}
+
+	t.Run("can marshal", func(t *testing.T) {
+		for _, c := range []struct {
+			v   interface{}
+			typ reflect.Type
+		}{
+			{&struct{ C chan int }{}, reflect.TypeOf(make(chan int))},
+			{&struct{ C []func() }{}, reflect.TypeOf(func() {})},
+		} {
+			var ute *UnsupportedTypeError
+			if assert.True(t, errors.As(CanMarshal(c.v), &ute)) {
+				assert.Equal(t, c.typ, ute.Type)
+			}
+		}
+	})
+}
+
+func TestFieldStack(t *testing.T) {
+	type inner struct {
+		X int32
+		Y string
+	}
+
+	type Base struct {
+		B int32
+	}
+
+	type outer struct {
+		Base
+		A inner
+		C []inner
+	}
+
+	from := outer{Base: Base{B: 1}, A: inner{X: 2, Y: "a"}, C: []inner{{X: 3}, {Y: "c"}}}
+
+	var st fieldStack
+	fields, start := st.push(reflect.ValueOf(&from).Elem(), false)
+	assert.Equal(t, 0, start)
+	assert.Equal(t, []string{"B", "A", "C"}, []string{fields[0].name, fields[1].name, fields[2].name})
+
+	// nested fields do not overwrite the parent's
+	nested, nestedStart := st.push(fields[1].value, false)
+	assert.Equal(t, 3, nestedStart)
+	assert.Equal(t, "X", nested[0].name)
+	assert.Equal(t, "B", fields[0].name)
+
+	st.pop(nestedStart)
+	st.pop(start)
+	assert.Empty(t, st.fields)
+	assert.Equal(t, structField{}, st.fields[:3][1], "popped values are cleared")
+
+	// the stack is reused across objects of both directions
+	for _, opts := range []MarshalOptions{{}, {OrdinalFields: true}, {FieldNames: true}} {
+		data, err := opts.Marshal(&from)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to outer
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, from, to)
+	}
+}
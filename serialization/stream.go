@@ -0,0 +1,95 @@
+package serialization
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// StreamEncoder writes marshaled objects to an io.Writer
+// an object is buffered until complete as its header carries the size, and written to w on Flush
+type StreamEncoder struct {
+	Options MarshalOptions
+
+	// AutoFlush writes each object to w once encoded, so only one object is buffered at a time
+	AutoFlush bool
+
+	w       io.Writer
+	pending bytes.Buffer
+	err     error
+}
+
+func NewStreamEncoder(w io.Writer) *StreamEncoder {
+	return &StreamEncoder{
+		w: w,
+	}
+}
+
+// Encode marshals v, a pointer to struct or raw []byte, into pending buffer, and flushes it with AutoFlush
+// nothing of v is buffered if marshal fails
+func (e *StreamEncoder) Encode(v interface{}) error {
+	if e.err != nil {
+		return e.err
+	}
+
+	if b, ok := v.([]byte); ok {
+		e.pending.Write(b)
+	} else {
+		mark := e.pending.Len()
+		if err := e.Options.marshal(&e.pending, v); err != nil {
+			e.pending.Truncate(mark)
+			return err
+		}
+	}
+
+	if e.AutoFlush {
+		return e.Flush()
+	}
+
+	return nil
+}
+
+// Flush writes pending objects to w
+// a write failure is sticky, pending bytes are dropped and any later call returns the error
+// so that no more bytes follow a partially written object
+func (e *StreamEncoder) Flush() error {
+	if e.err != nil {
+		return e.err
+	}
+
+	if e.pending.Len() == 0 {
+		return nil
+	}
+
+	n, err := e.w.Write(e.pending.Bytes())
+	if err == nil && n < e.pending.Len() {
+		err = io.ErrShortWrite
+	}
+
+	e.pending.Reset()
+
+	if err != nil {
+		e.err = fmt.Errorf("stream encoder write failure: %w", err)
+		return e.err
+	}
+
+	return nil
+}
+
+// Buffered returns the number of bytes pending flush
+func (e *StreamEncoder) Buffered() int {
+	return e.pending.Len()
+}
+
+// Reset discards pending bytes and any sticky write failure, and makes e write to w, Options and AutoFlush are kept
+// so one encoder can be reused across messages, the pending buffer is dropped if a large message grew it beyond maxPooledBufferSize
+func (e *StreamEncoder) Reset(w io.Writer) {
+	if e.pending.Cap() > maxPooledBufferSize {
+		e.pending = bytes.Buffer{}
+	} else {
+		e.pending.Reset()
+	}
+
+	e.w = w
+	e.err = nil
+}
@@ -0,0 +1,148 @@
+package serialization
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingWriter struct {
+	limit int
+	buf   bytes.Buffer
+}
+
+var errWriterFailure = fmt.Errorf("writer failure")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.buf.Len()+len(p) > w.limit {
+		n := w.limit - w.buf.Len()
+		w.buf.Write(p[:n])
+		return n, errWriterFailure
+	}
+
+	return w.buf.Write(p)
+}
+
+func TestStreamEncoder(t *testing.T) {
+	a := &BasicObject{Bool1: true, Ulong64_1: 1, String: "a"}
+	b := &BasicObject{Ulong64_1: 2, String: "b"}
+
+	expectedA, err := Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedB, err := Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("flush", func(t *testing.T) {
+		var buf bytes.Buffer
+		e := NewStreamEncoder(&buf)
+
+		assert.NoError(t, e.Encode(a))
+		assert.Equal(t, 0, buf.Len(), "nothing written before flush")
+		assert.Equal(t, len(expectedA), e.Buffered())
+
+		assert.NoError(t, e.Encode(b))
+		assert.NoError(t, e.Flush())
+		assert.Equal(t, append(expectedA, expectedB...), buf.Bytes())
+		assert.Equal(t, 0, e.Buffered())
+
+		// bad value does not leave partial bytes
+		assert.Error(t, e.Encode(&struct{ C chan int }{make(chan int)}))
+		assert.Equal(t, 0, e.Buffered())
+	})
+
+	t.Run("auto flush", func(t *testing.T) {
+		var buf bytes.Buffer
+		e := NewStreamEncoder(&buf)
+		e.AutoFlush = true
+
+		assert.NoError(t, e.Encode(a))
+		assert.Equal(t, expectedA, buf.Bytes())
+		assert.Equal(t, 0, e.Buffered())
+
+		assert.NoError(t, e.Encode(expectedB))
+		assert.Equal(t, append(expectedA, expectedB...), buf.Bytes())
+		assert.Equal(t, 0, e.Buffered())
+
+		assert.Error(t, e.Encode(&struct{ C chan int }{make(chan int)}))
+		assert.Equal(t, len(expectedA)+len(expectedB), buf.Len())
+	})
+
+	t.Run("deterministic", func(t *testing.T) {
+		type mapObj struct {
+			Map map[int32]string
+		}
+
+		object := &mapObj{Map: map[int32]string{3: "c", 1: "a", 2: "b", 4: "d"}}
+
+		var first []byte
+		for i := 0; i < 10; i++ {
+			var buf bytes.Buffer
+			e := NewStreamEncoder(&buf)
+			e.Options.Deterministic = true
+
+			assert.NoError(t, e.Encode(object))
+			assert.NoError(t, e.Flush())
+
+			if first == nil {
+				first = buf.Bytes()
+			}
+
+			assert.Equal(t, first, buf.Bytes())
+		}
+	})
+
+	t.Run("failing writer", func(t *testing.T) {
+		w := &failingWriter{limit: len(expectedA) + 3}
+		e := NewStreamEncoder(w)
+
+		assert.NoError(t, e.Encode(a))
+		assert.NoError(t, e.Flush())
+
+		assert.NoError(t, e.Encode(b))
+		err := e.Flush()
+		assert.True(t, errors.Is(err, errWriterFailure))
+
+		// sticky, nothing more written after the partial object
+		assert.Equal(t, err, e.Encode(a))
+		assert.Equal(t, err, e.Flush())
+		assert.Equal(t, len(expectedA)+3, w.buf.Len())
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		w := &failingWriter{limit: 3}
+		e := NewStreamEncoder(w)
+		e.Options.Deterministic = true
+
+		assert.NoError(t, e.Encode(a))
+		assert.Error(t, e.Flush())
+
+		var buf bytes.Buffer
+		e.Reset(&buf)
+
+		assert.NoError(t, e.Encode(b))
+		assert.Equal(t, len(expectedB), e.Buffered(), "write failure cleared")
+		assert.NoError(t, e.Flush())
+		assert.Equal(t, expectedB, buf.Bytes())
+		assert.True(t, e.Options.Deterministic)
+
+		// a small buffer is kept for the next message
+		assert.NoError(t, e.Encode(a))
+		c := e.pending.Cap()
+		e.Reset(&buf)
+		assert.Equal(t, 0, e.Buffered())
+		assert.Equal(t, c, e.pending.Cap())
+
+		// a large one is not retained
+		assert.NoError(t, e.Encode(make([]byte, maxPooledBufferSize+1)))
+		e.Reset(&buf)
+		assert.Equal(t, 0, e.pending.Cap())
+	})
+}
@@ -0,0 +1,41 @@
+package serialization
+
+import (
+	"reflect"
+	"sort"
+)
+
+// StringSet is a set of strings, marshaled as plain []string in sorted order
+// rather than map entries with empty object values
+type StringSet map[string]struct{}
+
+var stringSetType = reflect.TypeOf(StringSet{})
+
+func NewStringSet(v ...string) StringSet {
+	s := make(StringSet, len(v))
+	for _, k := range v {
+		s.Add(k)
+	}
+
+	return s
+}
+
+func (s StringSet) Add(k string) {
+	s[k] = struct{}{}
+}
+
+func (s StringSet) Has(k string) bool {
+	_, ok := s[k]
+	return ok
+}
+
+// Slice returns the sorted members
+func (s StringSet) Slice() []string {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,75 @@
+package serialization
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStringSet(t *testing.T) {
+	type compact struct {
+		Set StringSet
+		N   int32
+	}
+
+	type naive struct {
+		Set map[string]struct{}
+		N   int32
+	}
+
+	keys := []string{"c", "a", "b"}
+
+	data, err := Marshal(&compact{Set: NewStringSet(keys...), N: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v compact
+	if err := Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, NewStringSet(keys...), v.Set)
+	assert.Equal(t, []string{"a", "b", "c"}, v.Set.Slice())
+	assert.True(t, v.Set.Has("a"))
+	assert.False(t, v.Set.Has("d"))
+	assert.Equal(t, int32(1), v.N)
+
+	n := naive{Set: map[string]struct{}{}, N: 1}
+	for _, k := range keys {
+		n.Set[k] = struct{}{}
+	}
+
+	naivedata, err := Marshal(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Less(t, len(data), len(naivedata))
+
+	t.Run("same wire as []string", func(t *testing.T) {
+		data2, err := Marshal(&struct {
+			Set []string
+			N   int32
+		}{[]string{"a", "b", "c"}, 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, data2, data)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		data, err := Marshal(&compact{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var v compact
+		if err := Unmarshal(data, &v); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Empty(t, v.Set)
+	})
+}
@@ -0,0 +1,81 @@
+package serialization
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// time.Time and time.Duration are marshaled as int64 100ns ticks, the same as Fabric DateTime and TimeSpan
+// zero time.Time is 0 ticks and decoded as 1601-01-01, max and min time.Duration are TimeSpan max, which Fabric uses as infinite, and min
+func isTicksType(t reflect.Type) bool {
+	return t == timeType || t == durationType
+}
+
+// elemKind is the kind slice elements are marshaled as
+func elemKind(t reflect.Type) reflect.Kind {
+	if isTicksType(t) {
+		return reflect.Int64
+	}
+
+	return t.Kind()
+}
+
+// toTicks fails for non zero times outside the DateTime range instead of wrapping
+func toTicks(rv reflect.Value) (int64, error) {
+	if rv.Type() == timeType {
+		t := rv.Interface().(time.Time)
+		if t.IsZero() {
+			return 0, nil
+		}
+
+		ticks, ok := TicksFromTime(t)
+		if !ok {
+			return 0, fmt.Errorf("time %v out of DateTime range", t)
+		}
+
+		return ticks, nil
+	}
+
+	d := time.Duration(rv.Int())
+	switch d {
+	case math.MaxInt64:
+		return math.MaxInt64, nil
+	case math.MinInt64:
+		return math.MinInt64, nil
+	}
+
+	return int64(d / 100), nil
+}
+
+// setTicks fails for negative DateTime ticks, 0 ticks is 1601-01-01 UTC as in Fabric, not zero time.Time
+func setTicks(rv reflect.Value, ticks int64) error {
+	if rv.Type() == timeType {
+		if ticks < 0 {
+			return fmt.Errorf("ticks %v out of DateTime range", ticks)
+		}
+
+		rv.Set(reflect.ValueOf(TimeFromTicks(ticks).UTC()))
+		return nil
+	}
+
+	// clamped, ticks range is 100 times wider
+	var d time.Duration
+	switch {
+	case ticks > math.MaxInt64/100:
+		d = math.MaxInt64
+	case ticks < math.MinInt64/100:
+		d = math.MinInt64
+	default:
+		d = time.Duration(ticks) * 100
+	}
+
+	rv.SetInt(int64(d))
+	return nil
+}
@@ -0,0 +1,182 @@
+package serialization
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeSerialization(t *testing.T) {
+	type object struct {
+		At        time.Time
+		Timeout   time.Duration
+		Events    []time.Time
+		Schedule  []time.Duration
+		Empty     []time.Duration
+		LastEvent *time.Time
+	}
+
+	at := time.Date(2020, 1, 1, 0, 0, 0, 100, time.UTC)
+	fileTimeEpoch := time.Date(1601, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []object{
+		{},
+		{
+			At:        at,
+			Timeout:   2 * time.Second,
+			Events:    []time.Time{at, {}, at.Add(time.Hour)},
+			Schedule:  []time.Duration{time.Millisecond, 0, -time.Minute, math.MaxInt64},
+			Empty:     []time.Duration{},
+			LastEvent: &at,
+		},
+	}
+
+	for _, opts := range []MarshalOptions{{}, {OrdinalFields: true}} {
+		for _, c := range cases {
+			data, err := opts.Marshal(&c)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var to object
+			if err := Unmarshal(data, &to); err != nil {
+				t.Fatal(err)
+			}
+
+			// empty slice is written as empty and decoded as nil
+			if len(c.Empty) == 0 {
+				c.Empty = nil
+			}
+
+			// zero time is written as 0 ticks and decoded as 1601-01-01, unless the field is omitted by OrdinalFields
+			if c.At.IsZero() && !opts.OrdinalFields {
+				c.At = fileTimeEpoch
+			}
+
+			for i := range c.Events {
+				if c.Events[i].IsZero() {
+					c.Events[i] = fileTimeEpoch
+				}
+			}
+
+			assert.Equal(t, c, to)
+		}
+	}
+
+	t.Run("ticks", func(t *testing.T) {
+		data, err := Marshal(&struct {
+			At      time.Time
+			Timeout time.Duration
+			Events  []time.Time
+		}{
+			At:      at,
+			Timeout: 2 * time.Second,
+			Events:  []time.Time{at},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ticks struct {
+			At      int64
+			Timeout int64
+			Events  []int64
+		}
+		if err := Unmarshal(data, &ticks); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, int64(132223104000000001), ticks.At)
+		assert.Equal(t, int64(20000000), ticks.Timeout)
+		assert.Equal(t, []int64{132223104000000001}, ticks.Events)
+	})
+
+	t.Run("timespan", func(t *testing.T) {
+		data, err := Marshal(&struct{ D []time.Duration }{D: []time.Duration{5 * time.Second, math.MaxInt64, math.MinInt64}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ticks struct{ D []int64 }
+		if err := Unmarshal(data, &ticks); err != nil {
+			t.Fatal(err)
+		}
+
+		// TimeSpan max and min are kept as sentinels
+		assert.Equal(t, []int64{50000000, math.MaxInt64, math.MinInt64}, ticks.D)
+	})
+
+	t.Run("clamped", func(t *testing.T) {
+		data, err := Marshal(&struct{ D []int64 }{D: []int64{math.MaxInt64 / 2, math.MinInt64 / 2}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to struct{ D []time.Duration }
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, []time.Duration{math.MaxInt64, math.MinInt64}, to.D)
+	})
+
+	t.Run("zero is empty", func(t *testing.T) {
+		data, err := Marshal(&struct{ At time.Time }{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, err := Marshal(&struct{ At int64 }{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, expected, data)
+	})
+
+	t.Run("out of range", func(t *testing.T) {
+		for _, at := range []time.Time{
+			time.Date(1600, 12, 31, 23, 59, 59, 0, time.UTC),
+			time.Date(40000, 1, 1, 0, 0, 0, 0, time.UTC),
+		} {
+			_, err := Marshal(&struct{ At time.Time }{At: at})
+			assert.Error(t, err, "%v", at)
+
+			_, err = Marshal(&struct{ Events []time.Time }{Events: []time.Time{at}})
+			assert.Error(t, err, "%v", at)
+		}
+
+		_, err := Marshal(&struct{ At time.Time }{At: fileTimeEpoch})
+		assert.NoError(t, err)
+	})
+
+	t.Run("decode ticks", func(t *testing.T) {
+		for ticks, expected := range map[int64]time.Time{
+			0:                  fileTimeEpoch,
+			1:                  fileTimeEpoch.Add(100),
+			132223104000000001: at,
+		} {
+			data, err := Marshal(&struct{ At int64 }{At: ticks})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var to struct{ At time.Time }
+			assert.NoError(t, Unmarshal(data, &to))
+			assert.Equal(t, expected, to.At)
+		}
+
+		data, err := Marshal(&struct{ Events []int64 }{Events: []int64{1, -1}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to struct{ Events []time.Time }
+		err = Unmarshal(data, &to)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "ticks -1 out of DateTime range")
+		}
+	})
+}
@@ -0,0 +1,256 @@
+package serialization
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// A struct is a union when one of its fields is tagged `fabric:",union"`, the discriminator,
+// which must be an integer kind, and variant fields are pointers tagged `fabric:",case=<kind>"`.
+// Only the discriminator and the active variant are written, as an object of two fields.
+// Exactly one variant must be set and it must be the case of the discriminator.
+//
+//	type Credential struct {
+//		Kind    CredentialKind     `fabric:",union"`
+//		X509    *X509Credential    `fabric:",case=1"`
+//		Windows *WindowsCredential `fabric:",case=2"`
+//	}
+type union struct {
+	typ   reflect.Type
+	kind  structField
+	cases []unionCase
+}
+
+type unionCase struct {
+	kind  int64
+	field structField
+}
+
+var unionTypes sync.Map // reflect.Type -> bool
+
+// isUnionType reports whether any field of t, including embedded, has a union tag option
+func isUnionType(t reflect.Type) bool {
+	if v, ok := unionTypes.Load(t); ok {
+		return v.(bool)
+	}
+
+	var scan func(t reflect.Type) bool
+	scan = func(t reflect.Type) bool {
+		for i := 0; i < t.NumField(); i++ {
+			ft := t.Field(i)
+
+			if ft.Anonymous {
+				et := ft.Type
+				if et.Kind() == reflect.Ptr {
+					et = et.Elem()
+				}
+
+				if et.Kind() == reflect.Struct && scan(et) {
+					return true
+				}
+
+				continue
+			}
+
+			tag := parseFieldTag(ft.Tag.Get("fabric"))
+			if tag.union || tag.unionCase != "" {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	r := scan(t)
+	unionTypes.Store(t, r)
+	return r
+}
+
+// unionOf returns nil if rv is not a union
+func unionOf(rv reflect.Value, alloc bool) (*union, error) {
+	if !isUnionType(rv.Type()) {
+		return nil, nil
+	}
+
+	var u *union
+	var cases []structField
+
+	for _, f := range allFields(rv, alloc) {
+		switch {
+		case f.tag.union:
+			if u != nil {
+				return nil, fmt.Errorf("union %v has more than one discriminator", rv.Type())
+			}
+
+			switch f.value.Kind() {
+			case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int,
+				reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
+			default:
+				return nil, fmt.Errorf("union %v discriminator %v must be integer", rv.Type(), f.name)
+			}
+
+			u = &union{typ: rv.Type(), kind: f}
+		case f.tag.unionCase != "":
+			cases = append(cases, f)
+		}
+	}
+
+	if u == nil {
+		if len(cases) > 0 {
+			return nil, fmt.Errorf("union %v has no discriminator", rv.Type())
+		}
+
+		return nil, nil
+	}
+
+	for _, f := range cases {
+		kind, err := strconv.ParseInt(f.tag.unionCase, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("union %v variant %v bad case %q", rv.Type(), f.name, f.tag.unionCase)
+		}
+
+		if f.value.Kind() != reflect.Ptr {
+			return nil, fmt.Errorf("union %v variant %v must be pointer", rv.Type(), f.name)
+		}
+
+		if _, ok := u.lookup(kind); ok {
+			return nil, fmt.Errorf("union %v has duplicate case %v", rv.Type(), kind)
+		}
+
+		u.cases = append(u.cases, unionCase{kind: kind, field: f})
+	}
+
+	return u, nil
+}
+
+func (u *union) kindValue() int64 {
+	if u.kind.value.Kind() >= reflect.Uint && u.kind.value.Kind() <= reflect.Uint64 {
+		return int64(u.kind.value.Uint())
+	}
+
+	return u.kind.value.Int()
+}
+
+func (u *union) lookup(kind int64) (structField, bool) {
+	for _, c := range u.cases {
+		if c.kind == kind {
+			return c.field, true
+		}
+	}
+
+	return structField{}, false
+}
+
+// active returns the variant to marshal
+func (u *union) active() (structField, error) {
+	var active *unionCase
+
+	for i, c := range u.cases {
+		if c.field.value.IsNil() {
+			continue
+		}
+
+		if active != nil {
+			return structField{}, fmt.Errorf("union %v has multiple variants set, %v and %v", u.typ, active.field.name, c.field.name)
+		}
+
+		active = &u.cases[i]
+	}
+
+	if active == nil {
+		return structField{}, fmt.Errorf("union %v has no variant set", u.typ)
+	}
+
+	if kind := u.kindValue(); active.kind != kind {
+		return structField{}, fmt.Errorf("union %v kind %v does not match variant %v", u.typ, kind, active.field.name)
+	}
+
+	return active.field, nil
+}
+
+func (s *encodeState) union(u *union) error {
+	active, err := u.active()
+	if err != nil {
+		return err
+	}
+
+	if err := s.objectScopeBegin(); err != nil {
+		return err
+	}
+
+	return s.objectFields([]structField{u.kind, active}, nil)
+}
+
+// union decodes the discriminator first, then the variant of its case
+func (s *decodeState) union(meta FabricSerializationType, u *union) error {
+	if meta != FabricSerializationTypeObject {
+		return fmt.Errorf("union %v expect object got %v", u.typ, meta)
+	}
+
+	endPos, flags, _, err := s.readObjectBegin(meta)
+	if err != nil {
+		return err
+	}
+
+	u.kind.value.Set(reflect.Zero(u.kind.value.Type()))
+	for _, c := range u.cases {
+		c.field.value.Set(reflect.Zero(c.field.value.Type()))
+	}
+
+	if flags&headerFlagsOrdinalFields == headerFlagsOrdinalFields {
+		// fields may come in any order, read the discriminator in a first pass
+		start, err := s.inner.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+
+		if err := s.ordinalFields([]structField{u.kind}, nil); err != nil {
+			return err
+		}
+
+		if _, err := s.inner.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+
+		variant, ok := u.lookup(u.kindValue())
+		if !ok {
+			return fmt.Errorf("union %v unknown kind %v", u.typ, u.kindValue())
+		}
+
+		if err := s.ordinalFields([]structField{u.kind, variant}, nil); err != nil {
+			return err
+		}
+	} else {
+		meta, err := s.readTypeMeta()
+		if err != nil {
+			return err
+		}
+
+		if err := s.decodeField(meta, u.kind, -1); err != nil {
+			return err
+		}
+
+		variant, ok := u.lookup(u.kindValue())
+		if !ok {
+			return fmt.Errorf("union %v unknown kind %v", u.typ, u.kindValue())
+		}
+
+		meta, err = s.readTypeMeta()
+		if err != nil {
+			return err
+		}
+
+		if meta == FabricSerializationTypeScopeEnd {
+			return fmt.Errorf("union %v missing variant %v", u.typ, variant.name)
+		}
+
+		if err := s.decodeField(meta, variant, -1); err != nil {
+			return err
+		}
+	}
+
+	return s.consumeObjectEnd(meta, endPos)
+}
@@ -0,0 +1,146 @@
+package serialization
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type credentialKind int32
+
+const (
+	credentialKindX509    credentialKind = 1
+	credentialKindWindows credentialKind = 2
+)
+
+type x509Credential struct {
+	Thumbprint string
+	StoreName  string
+}
+
+type windowsCredential struct {
+	Spn string
+}
+
+type credential struct {
+	Kind    credentialKind     `fabric:",union"`
+	X509    *x509Credential    `fabric:",case=1"`
+	Windows *windowsCredential `fabric:",case=2"`
+}
+
+func TestUnion(t *testing.T) {
+	type object struct {
+		Before      int32
+		Credential  credential
+		Credentials []credential
+		After       string
+	}
+
+	x509 := credential{Kind: credentialKindX509, X509: &x509Credential{Thumbprint: "ab", StoreName: "My"}}
+	windows := credential{Kind: credentialKindWindows, Windows: &windowsCredential{Spn: "host"}}
+
+	for _, opts := range []MarshalOptions{{}, {OrdinalFields: true}, {FieldNames: true}} {
+		for _, c := range []credential{x509, windows} {
+			from := object{
+				Before:      1,
+				Credential:  c,
+				Credentials: []credential{x509, windows},
+				After:       "2",
+			}
+
+			data, err := opts.Marshal(&from)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var to object
+			if err := Unmarshal(data, &to); err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, from, to)
+		}
+	}
+
+	t.Run("only active variant", func(t *testing.T) {
+		data, err := Marshal(&windows)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wire, err := Marshal(&struct {
+			Kind    credentialKind
+			Windows *windowsCredential
+		}{Kind: windows.Kind, Windows: windows.Windows})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, wire, data)
+	})
+
+	t.Run("stale destination", func(t *testing.T) {
+		data, err := Marshal(&windows)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		to := x509
+		if err := Unmarshal(data, &to); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, windows, to)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		cases := map[string]credential{
+			"multiple set":   {Kind: credentialKindX509, X509: x509.X509, Windows: windows.Windows},
+			"none set":       {Kind: credentialKindX509},
+			"kind mismatch":  {Kind: credentialKindWindows, X509: x509.X509},
+			"unknown kind":   {Kind: 3, X509: x509.X509},
+			"zero with none": {},
+		}
+
+		for name, c := range cases {
+			_, err := Marshal(&c)
+			assert.Error(t, err, name)
+		}
+	})
+
+	t.Run("unknown kind on wire", func(t *testing.T) {
+		data, err := Marshal(&struct {
+			Kind    credentialKind
+			Windows *windowsCredential
+		}{Kind: 3, Windows: windows.Windows})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var to credential
+		assert.Error(t, Unmarshal(data, &to))
+	})
+
+	t.Run("bad tags", func(t *testing.T) {
+		assert.Error(t, marshalErr(&struct {
+			Kind string `fabric:",union"`
+			A    *int32 `fabric:",case=1"`
+		}{}))
+		assert.Error(t, marshalErr(&struct {
+			Kind int32 `fabric:",union"`
+			A    int32 `fabric:",case=1"`
+		}{}))
+		assert.Error(t, marshalErr(&struct {
+			Kind int32  `fabric:",union"`
+			A    *int32 `fabric:",case=x"`
+		}{}))
+		assert.Error(t, marshalErr(&struct {
+			A *int32 `fabric:",case=1"`
+		}{}))
+	})
+}
+
+func marshalErr(v interface{}) error {
+	_, err := Marshal(v)
+	return err
+}
@@ -6,11 +6,37 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"strings"
+	"unicode"
 	"unicode/utf16"
 )
 
 type decodeState struct {
-	inner *bytes.Reader
+	inner   *bytes.Reader
+	scratch []byte
+
+	// depth of objects being decoded, limited by maxDepth if > 0
+	depth    int
+	maxDepth int
+
+	// maxAlloc limits the bytes allocated for a declared length if > 0
+	maxAlloc int64
+
+	// lenient records field decode failures in fieldErrors and skips to the next field
+	lenient     bool
+	path        []string
+	fieldErrors FieldErrors
+
+	// rawUnknownTypes decodes variants of unregistered types as Raw
+	rawUnknownTypes bool
+
+	// validateEnums rejects decoded values of registered enums that are not known constants
+	validateEnums bool
+
+	// strict rejects scalar metas of another type than the field, path is also tracked in strict mode
+	strict bool
+
+	fieldStack
 }
 
 func (s *decodeState) ReadTypeMeta() (FabricSerializationType, error) {
@@ -62,14 +88,89 @@ func (s *decodeState) readCompressedUInt32() (uint32, error) {
 // 	return int16(v), err
 // }
 
+// checkAlloc fails if n elements of size bytes exceed maxAlloc, before a declared length is allocated
+func (s *decodeState) checkAlloc(n int64, size int64) error {
+	if s.maxAlloc > 0 && size > 0 && n > s.maxAlloc/size {
+		return fmt.Errorf("%v elements of %v bytes exceed max alloc %v bytes", n, size, s.maxAlloc)
+	}
+
+	return nil
+}
+
+// readWString reads n wchars and converts them to string
+// raw bytes are read into a reused scratch buffer, lone surrogates become U+FFFD as utf16.Decode
+func (s *decodeState) readWString(n uint32) (string, error) {
+	if err := s.checkAlloc(int64(n), 2); err != nil {
+		return "", err
+	}
+
+	size := 2 * int(n)
+	if cap(s.scratch) < size {
+		s.scratch = make([]byte, size)
+	}
+
+	raw := s.scratch[:size]
+	if _, err := io.ReadFull(s.inner, raw); err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.Grow(int(n))
+
+	for i := 0; i < int(n); i++ {
+		r := rune(binary.LittleEndian.Uint16(raw[2*i:]))
+
+		if utf16.IsSurrogate(r) {
+			r2 := unicode.ReplacementChar
+			if i+1 < int(n) {
+				r2 = utf16.DecodeRune(r, rune(binary.LittleEndian.Uint16(raw[2*i+2:])))
+			}
+
+			if r2 != unicode.ReplacementChar {
+				i++
+			}
+
+			r = r2
+		}
+
+		sb.WriteRune(r)
+	}
+
+	return sb.String(), nil
+}
+
 func (s *decodeState) readTypeMeta() (FabricSerializationType, error) {
-	var meta FabricSerializationType
-	err := binary.Read(s.inner, binary.LittleEndian, &meta)
+	meta, err := s.inner.ReadByte()
 	if err != nil {
 		return FabricSerializationTypeNotAMeta, err
 	}
 
-	return meta, nil
+	return FabricSerializationType(meta), nil
+}
+
+// readFieldNames reads name table written with MarshalOptions.FieldNames
+func (s *decodeState) readFieldNames() ([]string, error) {
+	count, err := s.readCompressedUInt32()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for i := uint32(0); i < count; i++ {
+		len, err := s.readCompressedUInt32()
+		if err != nil {
+			return nil, err
+		}
+
+		name, err := s.readWString(len)
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	return names, nil
 }
 
 func (s *decodeState) expectTypeMeta(expectMeta FabricSerializationType) error {
@@ -85,42 +186,89 @@ func (s *decodeState) expectTypeMeta(expectMeta FabricSerializationType) error {
 	return nil
 }
 
-func (s *decodeState) readObjectBegin(meta FabricSerializationType) (int64, error) {
+// readObjectHeader reads the header and skips declared header bytes beyond objectHeader
+func (s *decodeState) readObjectHeader(h *objectHeader) error {
+	// decoded by hand, binary.Read allocates for every object, b does not escape through the concrete reader
+	var b [8]byte
+	if n, _ := s.inner.Read(b[:]); n < len(b) {
+		if n == 0 {
+			return io.EOF
+		}
+
+		return io.ErrUnexpectedEOF
+	}
+
+	h.Size = binary.LittleEndian.Uint32(b[:4])
+	h.Flag = headerFlags(b[4])
+	copy(h.Padding[:], b[5:])
+
+	size := h.headerSize()
+	if size < sizeOfobjectHeader {
+		return fmt.Errorf("object header size %v too small", size)
+	}
+
+	if size > sizeOfobjectHeader {
+		if _, err := io.CopyN(io.Discard, s.inner, int64(size-sizeOfobjectHeader)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readObjectBegin returns end position, header flags and the field name table if the header has one
+func (s *decodeState) readObjectBegin(meta FabricSerializationType) (int64, headerFlags, []string, error) {
 	if meta != FabricSerializationTypeObject {
-		return -1, nil
+		return -1, headerFlagsEmpty, nil, nil
+	}
+
+	s.depth++
+	if s.maxDepth > 0 && s.depth > s.maxDepth {
+		return -1, headerFlagsEmpty, nil, fmt.Errorf("object nesting exceeds max depth %v", s.maxDepth)
 	}
 
 	var objectheader objectHeader
 
 	headerPosition, err := s.inner.Seek(0, io.SeekCurrent)
 	if err != nil {
-		return -1, err
+		return -1, headerFlagsEmpty, nil, err
+	}
+
+	if err := s.readObjectHeader(&objectheader); err != nil {
+		return -1, headerFlagsEmpty, nil, err
 	}
 
-	binary.Read(s.inner, binary.LittleEndian, &objectheader)
 	if objectheader.Flag&headerFlagsContainsTypeInformation == headerFlagsContainsTypeInformation {
 
 		// TODO no obj activator in go, discard type info at the moment
 		// struct must set exact type info
 		len, err := s.readCompressedUInt32()
 		if err != nil {
-			return -1, err
+			return -1, headerFlagsEmpty, nil, err
 		}
 
 		if len == 0 {
-			return -1, fmt.Errorf("typeinfo len must > 0")
+			return -1, headerFlagsEmpty, nil, fmt.Errorf("typeinfo len must > 0")
 		}
 
 		if _, err := io.CopyN(io.Discard, s.inner, int64(len)); err != nil {
-			return -1, err
+			return -1, headerFlagsEmpty, nil, err
+		}
+	}
+
+	var names []string
+	if objectheader.Flag&headerFlagsContainsExtensionData == headerFlagsContainsExtensionData {
+		names, err = s.readFieldNames()
+		if err != nil {
+			return -1, headerFlagsEmpty, nil, err
 		}
 	}
 
 	if err := s.expectTypeMeta(FabricSerializationTypeScopeBegin); err != nil {
-		return -1, err
+		return -1, headerFlagsEmpty, nil, err
 	}
 
-	return headerPosition + int64(objectheader.Size) - 2, nil
+	return headerPosition + int64(objectheader.Size) - 2, objectheader.Flag, names, nil
 }
 
 func (s *decodeState) consumeObjectEnd(meta FabricSerializationType, endpos int64) error {
@@ -128,6 +276,8 @@ func (s *decodeState) consumeObjectEnd(meta FabricSerializationType, endpos int6
 		return nil
 	}
 
+	s.depth--
+
 	_, err := s.inner.Seek(endpos, io.SeekStart)
 	if err != nil {
 		return err
@@ -145,6 +295,36 @@ func (s *decodeState) consumeObjectEnd(meta FabricSerializationType, endpos int6
 }
 
 func (s *decodeState) value(meta FabricSerializationType, rv reflect.Value) error {
+	if rv.Kind() == reflect.Interface {
+		return s.variant(meta, rv)
+	}
+
+	if isTicksType(rv.Type()) {
+		var ticks int64
+		if err := s.value(meta, reflect.ValueOf(&ticks).Elem()); err != nil {
+			return err
+		}
+
+		return setTicks(rv, ticks)
+	}
+
+	if rv.Type() == rawType {
+		return s.raw(meta, rv)
+	}
+
+	// custom decoding also owns empty metas, which may not mean the zero value
+	if rv.Kind() == reflect.Struct {
+		if cm, ok := castToMarshaler(rv); ok {
+			return cm.Unmarshal(meta, s)
+		}
+	}
+
+	if s.strict {
+		if err := s.checkMeta(meta, rv.Kind()); err != nil {
+			return err
+		}
+	}
+
 	if IsEmptyMeta(meta) {
 
 		// bool is alway empty
@@ -156,13 +336,20 @@ func (s *decodeState) value(meta FabricSerializationType, rv reflect.Value) erro
 			} else {
 				return fmt.Errorf("expect bool got %v", meta)
 			}
+		} else if rv.Kind() == reflect.Ptr {
+			// typed nil, no more bytes follow
+			if !IsBaseMeta(meta, FabricSerializationTypePointer) {
+				return fmt.Errorf("expect empty pointer got %v", meta)
+			}
+
+			rv.Set(reflect.Zero(rv.Type()))
 		} else {
 			// other kind
 			// TODO basetype check
 			rv.Set(reflect.Zero(rv.Type()))
 		}
 
-		return nil
+		return s.checkEnum(rv)
 	}
 
 	switch rv.Kind() {
@@ -182,27 +369,35 @@ func (s *decodeState) value(meta FabricSerializationType, rv reflect.Value) erro
 			return fmt.Errorf("expect char/uchar got %v", meta)
 		}
 
-	case reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	case reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint:
 
 		switch meta {
 		case FabricSerializationTypeUShort, FabricSerializationTypeUInt32, FabricSerializationTypeUInt64:
-			v, err := s.readCompressedUnsigned(int(rv.Type().Size()))
+			v, err := s.readCompressedUnsigned(intSize(rv.Type()))
 			if err != nil {
 				return err
 			}
 
+			if rv.OverflowUint(v) {
+				return fmt.Errorf("%v overflows %v", v, rv.Type())
+			}
+
 			rv.SetUint(v)
 		default:
 			return fmt.Errorf("expect uint got %v", meta)
 		}
-	case reflect.Int16, reflect.Int32, reflect.Int64:
+	case reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int:
 		switch meta {
 		case FabricSerializationTypeShort, FabricSerializationTypeInt32, FabricSerializationTypeInt64:
-			v, err := s.readCompressedSigned(int(rv.Type().Size()))
+			v, err := s.readCompressedSigned(intSize(rv.Type()))
 			if err != nil {
 				return err
 			}
 
+			if rv.OverflowInt(v) {
+				return fmt.Errorf("%v overflows %v", v, rv.Type())
+			}
+
 			rv.SetInt(v)
 		default:
 			return fmt.Errorf("expect int got %v", meta)
@@ -223,6 +418,10 @@ func (s *decodeState) value(meta FabricSerializationType, rv reflect.Value) erro
 
 	case reflect.String:
 
+		if meta == FabricSerializationTypeInt32|FabricSerializationTypeArray {
+			return fmt.Errorf("expect string got int32 array, []rune is written as int32 array not wstring")
+		}
+
 		if meta != FabricSerializationTypeWString|FabricSerializationTypeArray {
 			return fmt.Errorf("expect string got %v", meta)
 		}
@@ -232,14 +431,12 @@ func (s *decodeState) value(meta FabricSerializationType, rv reflect.Value) erro
 			return err
 		}
 
-		body := make([]uint16, len) // wchar
-
-		err = binary.Read(s.inner, binary.LittleEndian, &body)
+		str, err := s.readWString(len)
 		if err != nil {
 			return err
 		}
 
-		rv.SetString(string(utf16.Decode(body)))
+		rv.SetString(str)
 
 	case reflect.Ptr:
 		ptr := reflect.New(rv.Type().Elem())
@@ -256,28 +453,64 @@ func (s *decodeState) value(meta FabricSerializationType, rv reflect.Value) erro
 		rv.Set(ptr)
 
 	case reflect.Struct:
-		if cm, ok := castToMarshaler(rv); ok {
-			return cm.Unmarshal(meta, s)
+		u, err := unionOf(rv, true)
+		if err != nil {
+			return err
+		}
+
+		if u != nil {
+			return s.union(meta, u)
 		}
 
-		endPos, err := s.readObjectBegin(meta)
+		endPos, flags, names, err := s.readObjectBegin(meta)
 		if err != nil {
 			return err
 		}
 
-		for _, field := range allFields(rv) {
-			meta, err := s.readTypeMeta()
-			if err != nil {
+		all, start := s.push(rv, true)
+		defer s.pop(start)
+
+		fields, rest, err := splitRest(all)
+		if err != nil {
+			return err
+		}
+
+		if rest != nil {
+			rest.value.Set(reflect.Zero(rawType))
+		}
+
+		if flags&headerFlagsOrdinalFields == headerFlagsOrdinalFields {
+			if err := s.ordinalFields(fields, rest); err != nil {
 				return err
 			}
-
-			if meta == FabricSerializationTypeScopeEnd {
-				break
+		} else if names != nil {
+			if err := s.namedFields(fields, rest, names); err != nil {
+				return err
+			}
+		} else {
+			ended := false
+			for _, field := range fields {
+				meta, err := s.readTypeMeta()
+				if err != nil {
+					return err
+				}
+
+				if meta == FabricSerializationTypeScopeEnd {
+					ended = true
+					break
+				}
+
+				err = s.decodeField(meta, field, -1)
+				if err != nil {
+					return err
+				}
 			}
 
-			err = s.value(meta, field)
-			if err != nil {
-				return err
+			// fields beyond the known ones
+			if !ended && rest != nil && endPos >= 0 {
+				if err := s.rest(rest, endPos); err != nil {
+					return err
+				}
 			}
 		}
 
@@ -287,25 +520,25 @@ func (s *decodeState) value(meta FabricSerializationType, rv reflect.Value) erro
 		}
 
 	case reflect.Slice:
+		len, err := s.readArrayLen(meta, rv.Type().Elem())
+		if err != nil {
+			return err
+		}
 
-		switch rv.Type().Elem().Kind() {
-		case reflect.String, reflect.Ptr:
-			if meta != FabricSerializationTypeUInt32 {
-				return fmt.Errorf("[]string count expect uint32 got %v", meta)
-			}
-		case reflect.Struct:
-			if meta != FabricSerializationTypeObject|FabricSerializationTypeArray {
-				return fmt.Errorf("[]struct{} expect array got %v", meta)
+		// count is known up front, decode elements in place into one backing array
+		// reuse the backing array of the destination if it is large enough, which aliases it as documented on Unmarshal
+		var objs reflect.Value
+		if rv.Cap() >= len {
+			objs = rv.Slice(0, len)
+		} else {
+			if err := s.checkAlloc(int64(len), int64(rv.Type().Elem().Size())); err != nil {
+				return err
 			}
-		}
 
-		len0, err := s.readCompressedUInt32()
-		len := int(len0)
-		if err != nil {
-			return err
+			objs = reflect.MakeSlice(reflect.SliceOf(rv.Type().Elem()), len, len)
 		}
 
-		objs := reflect.MakeSlice(reflect.SliceOf(rv.Type().Elem()), len, len)
+		zero := reflect.Zero(rv.Type().Elem())
 
 		for i := 0; i < len; i++ {
 
@@ -314,16 +547,30 @@ func (s *decodeState) value(meta FabricSerializationType, rv reflect.Value) erro
 				return err
 			}
 
-			err = s.value(meta, objs.Index(i))
+			elm := objs.Index(i)
+			elm.Set(zero)
 
-			if err != nil {
+			if err := s.value(meta, elm); err != nil {
 				return err
 			}
 		}
 
 		rv.Set(objs)
 
+	case reflect.Array:
+		return s.array(meta, rv, false)
+
 	case reflect.Map:
+		if rv.Type() == stringSetType {
+			var keys []string
+			if err := s.value(meta, reflect.ValueOf(&keys).Elem()); err != nil {
+				return err
+			}
+
+			rv.Set(reflect.ValueOf(NewStringSet(keys...)))
+			return nil
+		}
+
 		keytyp := rv.Type().Key()
 		valtyp := rv.Type().Elem()
 		sliceTyp := reflect.StructOf([]reflect.StructField{
@@ -356,25 +603,514 @@ func (s *decodeState) value(meta FabricSerializationType, rv reflect.Value) erro
 		return fmt.Errorf("unsupported unmarshal type %v", rv.String())
 	}
 
+	return s.checkEnum(rv)
+}
+
+// checkEnum validates a decoded value of a type registered with RegisterEnum if validateEnums is set
+func (s *decodeState) checkEnum(rv reflect.Value) error {
+	if !s.validateEnums {
+		return nil
+	}
+
+	return validateEnum(rv)
+}
+
+// peekTypeInfo returns type information in the header of the object at current position, nil if none
+func (s *decodeState) peekTypeInfo() ([]byte, error) {
+	pos, err := s.inner.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	defer s.inner.Seek(pos, io.SeekStart)
+
+	var objectheader objectHeader
+	if err := s.readObjectHeader(&objectheader); err != nil {
+		return nil, err
+	}
+
+	if objectheader.Flag&headerFlagsContainsTypeInformation != headerFlagsContainsTypeInformation {
+		return nil, nil
+	}
+
+	len, err := s.readCompressedUInt32()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.checkAlloc(int64(len), 1); err != nil {
+		return nil, err
+	}
+
+	typeinfo := make([]byte, len)
+	if _, err := io.ReadFull(s.inner, typeinfo); err != nil {
+		return nil, err
+	}
+
+	return typeinfo, nil
+}
+
+// variant decodes an object into interface rv, allocating the type registered for its type information
+// meta is either pointer followed by the object or the object itself
+func (s *decodeState) variant(meta FabricSerializationType, rv reflect.Value) error {
+	if meta == FabricSerializationTypeEmptyValueBit|FabricSerializationTypePointer {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+
+	if typ, ok := scalarVariantType(meta); ok {
+		if !typ.AssignableTo(rv.Type()) {
+			return fmt.Errorf("%v is not assignable to %v", typ, rv.Type())
+		}
+
+		v := reflect.New(typ).Elem()
+		if err := s.value(meta, v); err != nil {
+			return err
+		}
+
+		rv.Set(v)
+		return nil
+	}
+
+	start, err := s.inner.Seek(-1, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.inner.Seek(1, io.SeekCurrent); err != nil {
+		return err
+	}
+
+	typ, err := s.variantType(meta)
+	if err != nil {
+		if !s.rawUnknownTypes {
+			return err
+		}
+
+		return s.rawVariant(start, rv)
+	}
+
+	if meta == FabricSerializationTypePointer {
+		objmeta, err := s.readTypeMeta()
+		if err != nil {
+			return err
+		}
+
+		meta = objmeta
+	}
+
+	if !typ.AssignableTo(rv.Type()) {
+		return fmt.Errorf("registered type %v is not assignable to %v", typ, rv.Type())
+	}
+
+	structTyp := typ
+	if typ.Kind() == reflect.Ptr {
+		structTyp = typ.Elem()
+	}
+
+	obj := reflect.New(structTyp)
+
+	if err := s.value(meta, obj.Elem()); err != nil {
+		return err
+	}
+
+	if typ.Kind() == reflect.Ptr {
+		rv.Set(obj)
+	} else {
+		rv.Set(obj.Elem())
+	}
+
 	return nil
 }
 
+// variantType returns the registered type of the object following meta, position is restored
+func (s *decodeState) variantType(meta FabricSerializationType) (reflect.Type, error) {
+	pos, err := s.inner.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	defer s.inner.Seek(pos, io.SeekStart)
+
+	if meta == FabricSerializationTypePointer {
+		objmeta, err := s.readTypeMeta()
+		if err != nil {
+			return nil, err
+		}
+
+		meta = objmeta
+	}
+
+	if meta != FabricSerializationTypeObject {
+		return nil, fmt.Errorf("variant expect object got %v", meta)
+	}
+
+	typeinfo, err := s.peekTypeInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(typeinfo) == 0 {
+		return nil, fmt.Errorf("variant expect object with type information")
+	}
+
+	return registeredType(typeinfo)
+}
+
+// rawVariant captures the value starting at start as Raw
+func (s *decodeState) rawVariant(start int64, rv reflect.Value) error {
+	if !rawType.AssignableTo(rv.Type()) {
+		return fmt.Errorf("%v is not assignable to %v", rawType, rv.Type())
+	}
+
+	if _, err := s.inner.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+
+	meta, err := s.readTypeMeta()
+	if err != nil {
+		return err
+	}
+
+	var raw Raw
+	if err := s.raw(meta, reflect.ValueOf(&raw).Elem()); err != nil {
+		return err
+	}
+
+	rv.Set(reflect.ValueOf(raw))
+	return nil
+}
+
+// ordinalFields decodes fields written with MarshalOptions.OrdinalFields until scope end
+// fields may come in any order, missing fields are left untouched and unknown ordinals are skipped, or appended to rest if not nil
+func (s *decodeState) ordinalFields(fields []structField, rest *structField) error {
+	for {
+		entry, err := s.inner.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+
+		meta, err := s.readTypeMeta()
+		if err != nil {
+			return err
+		}
+
+		if meta == FabricSerializationTypeScopeEnd {
+			return nil
+		}
+
+		if meta != FabricSerializationTypeUInt32 {
+			return fmt.Errorf("expect field ordinal got %v", meta)
+		}
+
+		ordinal, err := s.readCompressedUInt32()
+		if err != nil {
+			return err
+		}
+
+		size, err := s.readCompressedUInt32()
+		if err != nil {
+			return err
+		}
+
+		start, err := s.inner.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+
+		if int(ordinal) < len(fields) {
+			meta, err := s.readTypeMeta()
+			if err != nil {
+				return err
+			}
+
+			if err := s.decodeField(meta, fields[ordinal], start+int64(size)); err != nil {
+				return err
+			}
+		} else if rest != nil {
+			if _, err := s.inner.Seek(entry, io.SeekStart); err != nil {
+				return err
+			}
+
+			b := make([]byte, start+int64(size)-entry)
+			if _, err := io.ReadFull(s.inner, b); err != nil {
+				return err
+			}
+
+			rest.value.Set(reflect.ValueOf(append(rest.value.Interface().(Raw), b...)))
+		}
+
+		if _, err := s.inner.Seek(start+int64(size), io.SeekStart); err != nil {
+			return err
+		}
+	}
+}
+
+// namedFields decodes fields written with a field name table, each value is placed by its name in any order
+// values with unknown names are kept in rest if any, otherwise skipped, fields not on the wire are unchanged
+// unknown []string and slices of pointers cannot be skipped, their count is a separate UInt32 value
+func (s *decodeState) namedFields(fields []structField, rest *structField, names []string) error {
+	byName := make(map[string]int, len(fields))
+	for i, f := range fields {
+		byName[f.wireName()] = i
+	}
+
+	for i := 0; ; i++ {
+		entry, err := s.inner.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+
+		meta, err := s.readTypeMeta()
+		if err != nil {
+			return err
+		}
+
+		if meta == FabricSerializationTypeScopeEnd {
+			return nil
+		}
+
+		if i < len(names) {
+			if j, ok := byName[names[i]]; ok {
+				if err := s.decodeField(meta, fields[j], -1); err != nil {
+					return err
+				}
+
+				continue
+			}
+		}
+
+		if err := s.skip(meta); err != nil {
+			return err
+		}
+
+		if rest == nil {
+			continue
+		}
+
+		end, err := s.inner.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+
+		b := make([]byte, end-entry)
+		if _, err := s.inner.ReadAt(b, entry); err != nil {
+			return err
+		}
+
+		rest.value.Set(reflect.ValueOf(append(rest.value.Interface().(Raw), b...)))
+	}
+}
+
+func (s *decodeState) field(meta FabricSerializationType, f structField) error {
+	if f.tag.escape {
+		return s.escapedField(meta, f)
+	}
+
+	if f.value.Kind() == reflect.Array && !IsEmptyMeta(meta) {
+		return s.array(meta, f.value, f.tag.truncate)
+	}
+
+	return s.value(meta, f.value)
+}
+
+// intSize is the wire size of an integer type, int and uint are written as 64 bit on any platform
+func intSize(t reflect.Type) int {
+	if t.Kind() == reflect.Int || t.Kind() == reflect.Uint {
+		return 8
+	}
+
+	return int(t.Size())
+}
+
+// checkMeta rejects meta unless it is the meta marshal writes for a scalar or string kind, ignoring the empty bit
+func (s *decodeState) checkMeta(meta FabricSerializationType, kind reflect.Kind) error {
+	expect := kindToFabricSerializationType(kind)
+
+	switch expect {
+	case FabricSerializationTypeNotAMeta, FabricSerializationTypeObject, FabricSerializationTypePointer:
+		return nil
+	case FabricSerializationTypeBool:
+		if meta&^FabricSerializationTypeEmptyValueBit == FabricSerializationTypeBoolFalse {
+			return nil
+		}
+	case FabricSerializationTypeWString:
+		expect |= FabricSerializationTypeArray
+	}
+
+	if meta&^FabricSerializationTypeEmptyValueBit == expect {
+		return nil
+	}
+
+	if len(s.path) == 0 {
+		return fmt.Errorf("%v expect %v got %v", kind, metaName(expect), metaName(meta))
+	}
+
+	return fmt.Errorf("field %v %v expect %v got %v", strings.Join(s.path, "."), kind, metaName(expect), metaName(meta))
+}
+
+func (s *decodeState) readArrayLen(meta FabricSerializationType, elmTyp reflect.Type) (int, error) {
+	switch elemKind(elmTyp) {
+	case reflect.String, reflect.Ptr:
+		if meta != FabricSerializationTypeUInt32 {
+			return 0, fmt.Errorf("[]string count expect uint32 got %v", meta)
+		}
+	case reflect.Struct:
+		if meta != FabricSerializationTypeObject|FabricSerializationTypeArray {
+			return 0, fmt.Errorf("[]struct{} expect array got %v", meta)
+		}
+	case reflect.Int32:
+		// []rune is []int32, a code point array unrelated to wstring
+		if meta == FabricSerializationTypeWString|FabricSerializationTypeArray {
+			return 0, fmt.Errorf("%v expect int32 array got wstring, decode wstring into string", elmTyp)
+		}
+	case reflect.Interface:
+		// pointer array of variants, or object array with type information on each element
+		if meta != FabricSerializationTypeUInt32 && meta != FabricSerializationTypeObject|FabricSerializationTypeArray {
+			return 0, fmt.Errorf("[]interface{} expect uint32 or array got %v", meta)
+		}
+	}
+
+	len, err := s.readCompressedUInt32()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(len), nil
+}
+
+// array decodes wire array into fixed [N]T
+// wire array must have exact N elements unless truncate, which drops extra elements or zero-fills the rest
+func (s *decodeState) array(meta FabricSerializationType, rv reflect.Value, truncate bool) error {
+	elmTyp := rv.Type().Elem()
+
+	len, err := s.readArrayLen(meta, elmTyp)
+	if err != nil {
+		return err
+	}
+
+	if len != rv.Len() && !truncate {
+		return fmt.Errorf("array %v expect %v elements got %v", rv.Type(), rv.Len(), len)
+	}
+
+	rv.Set(reflect.Zero(rv.Type()))
+
+	for i := 0; i < len; i++ {
+		meta, err := s.readTypeMeta()
+		if err != nil {
+			return err
+		}
+
+		elm := reflect.New(elmTyp).Elem() // discarded
+		if i < rv.Len() {
+			elm = rv.Index(i)
+		}
+
+		if err := s.value(meta, elm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DefaultMaxDepth is the object nesting limit of Unmarshal
+const DefaultMaxDepth = 1000
+
+// DefaultMaxAllocBytes is the allocation limit of Unmarshal for a single declared length
+const DefaultMaxAllocBytes = 256 << 20
+
+// UnmarshalOptions configures unmarshaling, the zero value is the same as Unmarshal
+type UnmarshalOptions struct {
+	// MaxDepth limits nesting of objects so untrusted input cannot exhaust the stack with recursive types,
+	// DefaultMaxDepth is used if 0, negative disables the limit
+	MaxDepth int
+
+	// MaxAllocBytes limits the allocation for a declared length, e.g. of a slice or string, so a corrupt length cannot exhaust memory,
+	// DefaultMaxAllocBytes is used if 0, negative disables the limit
+	MaxAllocBytes int64
+
+	// Lenient skips fields that fail to decode instead of aborting, e.g. for tools reading partially corrupt captures.
+	// Unmarshal returns FieldErrors listing the skipped fields, which are left zero, all other fields are decoded.
+	// Data that cannot be skipped, e.g. a truncated value or a corrupt object header, still aborts.
+	// Trailing fields unknown to the struct are skipped by the object size in either mode.
+	Lenient bool
+
+	// ValidateEnums rejects decoded values of types registered with RegisterEnum that are not known constants,
+	// the same check as MarshalOptions.ValidateEnums, e.g. for messages from a peer with newer enum members
+	ValidateEnums bool
+
+	// Strict requires the meta of each scalar or string value to be the one Marshal writes for its kind,
+	// e.g. an empty wstring or an int64 decoded into an int32 field fails with the field path instead of a zero or converted value.
+	Strict bool
+
+	// RawUnknownTypes decodes a polymorphic value, e.g. a value of map[string]interface{}, as Raw of its exact bytes
+	// when it is an object of unregistered or no type information, or an array, instead of failing.
+	// The interface type must accept Raw, e.g. interface{}, and the Raw is written back verbatim on marshal.
+	RawUnknownTypes bool
+}
+
+// Unmarshal decodes the object in data into the struct v points to
+// into *[]byte data is copied verbatim, the reverse of Marshal of a []byte
+// for versioning, fields of a newer message beyond the struct are skipped and struct fields beyond an older message are left unchanged
+// a slice with enough capacity is decoded into its backing array, as encoding/json does, copy it first if it is still referenced
 func Unmarshal(data []byte, v interface{}) error {
+	return UnmarshalOptions{}.Unmarshal(data, v)
+}
+
+func (o UnmarshalOptions) Unmarshal(data []byte, v interface{}) error {
+	_, err := o.DecodeN(data, v)
+	return err
+}
+
+// DecodeN is Unmarshal of the first object in b, returns the number of bytes consumed so the next object starts at b[n:]
+func DecodeN(b []byte, v interface{}) (int, error) {
+	return UnmarshalOptions{}.DecodeN(b, v)
+}
+
+// DecodeN is Unmarshal of the first object in b, returns the number of bytes consumed so the next object starts at b[n:]
+// n is also returned with FieldErrors in lenient mode, a *[]byte consumes all of b
+func (o UnmarshalOptions) DecodeN(b []byte, v interface{}) (int, error) {
+	if p, ok := v.(*[]byte); ok && p != nil {
+		*p = append((*p)[:0], b...)
+		return len(b), nil
+	}
+
 	pv := reflect.ValueOf(v)
 	if pv.Kind() != reflect.Ptr || pv.IsNil() {
-		return fmt.Errorf("unmarshal type must be ptr")
+		return 0, fmt.Errorf("unmarshal type must be ptr")
 	}
 
 	rv := reflect.Indirect(pv)
 	if rv.Kind() != reflect.Struct {
-		return fmt.Errorf("unmarshal type must be ptr to struct")
+		return 0, fmt.Errorf("unmarshal type must be ptr to struct")
+	}
+
+	maxDepth := o.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxDepth
+	}
+
+	maxAlloc := o.MaxAllocBytes
+	if maxAlloc == 0 {
+		maxAlloc = DefaultMaxAllocBytes
 	}
 
-	d := decodeState{bytes.NewReader(data)}
+	r := bytes.NewReader(b)
+	d := decodeState{inner: r, maxDepth: maxDepth, maxAlloc: maxAlloc, lenient: o.Lenient, rawUnknownTypes: o.RawUnknownTypes, strict: o.Strict, validateEnums: o.ValidateEnums}
 	meta, err := d.readTypeMeta()
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	if err := d.value(meta, rv); err != nil {
+		return 0, err
+	}
+
+	n := len(b) - r.Len()
+
+	if len(d.fieldErrors) > 0 {
+		return n, d.fieldErrors
 	}
 
-	return d.value(meta, rv)
+	return n, nil
 }
@@ -0,0 +1,194 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BatchConfig configures a Batcher
+type BatchConfig struct {
+	// Window is how long the first request of a batch waits for more requests to the same actor and action
+	Window time.Duration
+
+	// MaxBatch flushes a batch as soon as it has this many requests
+	// unlimited if 0
+	MaxBatch int
+}
+
+// Batcher coalesces requests to the same actor and action within a short window and writes them to the connection at once.
+// Fabric has no batched message, each request is still a message of its own and its reply is delivered to its caller only.
+type Batcher struct {
+	client *Client
+	config BatchConfig
+
+	lock    sync.Mutex
+	pending map[batchKey]*batch
+}
+
+type batchKey struct {
+	actor  MessageActorType
+	action string
+}
+
+type batch struct {
+	key      batchKey
+	requests []*batchedRequest
+	timer    *time.Timer
+	flushed  bool
+}
+
+type batchedRequest struct {
+	message *Message
+	sent    chan error
+}
+
+func NewBatcher(client *Client, config BatchConfig) (*Batcher, error) {
+	if config.Window <= 0 {
+		return nil, fmt.Errorf("Window must > 0")
+	}
+
+	if config.MaxBatch < 0 {
+		return nil, fmt.Errorf("MaxBatch must >= 0")
+	}
+
+	return &Batcher{
+		client:  client,
+		config:  config,
+		pending: make(map[batchKey]*batch),
+	}, nil
+}
+
+// RequestReply is Client.RequestReply, except the request is sent with the batch it joins.
+// A request whose ctx is done before its batch is flushed is not sent.
+func (b *Batcher) RequestReply(ctx context.Context, message *Message) (*ByteArrayMessage, error) {
+	return b.client.do(ctx, func() (*ByteArrayMessage, error) {
+		c := b.client.connection
+
+		c.msgfac.fillMessageId(message)
+		message.Headers.ExpectsReply = true
+		setDeadlineHeader(ctx, &message.Headers)
+		pr := c.requestTable.Put(message)
+		defer pr.Close()
+
+		r := &batchedRequest{
+			message: message,
+			sent:    make(chan error, 1),
+		}
+
+		p := b.enqueue(r)
+
+		select {
+		case err := <-r.sent:
+			if err != nil {
+				return nil, err
+			}
+		case <-ctx.Done():
+			b.cancel(p, r)
+			return nil, ctx.Err()
+		}
+
+		return pr.Wait(ctx)
+	})
+}
+
+func (b *Batcher) enqueue(r *batchedRequest) *batch {
+	key := batchKey{
+		actor:  r.message.Headers.Actor,
+		action: r.message.Headers.Action,
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	p, ok := b.pending[key]
+	if !ok {
+		p = &batch{key: key}
+		p.timer = time.AfterFunc(b.config.Window, func() {
+			b.lock.Lock()
+			ok := b.detach(p)
+			b.lock.Unlock()
+
+			if ok {
+				b.flush(p)
+			}
+		})
+
+		b.pending[key] = p
+	}
+
+	p.requests = append(p.requests, r)
+
+	if b.config.MaxBatch > 0 && len(p.requests) >= b.config.MaxBatch && b.detach(p) {
+		go b.flush(p)
+	}
+
+	return p
+}
+
+// detach takes p out of pending, false if already flushed, lock must be held
+func (b *Batcher) detach(p *batch) bool {
+	if p.flushed {
+		return false
+	}
+
+	p.flushed = true
+	p.timer.Stop()
+	delete(b.pending, p.key)
+	return true
+}
+
+// cancel removes r from p unless p is already flushed
+func (b *Batcher) cancel(p *batch, r *batchedRequest) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if p.flushed {
+		return
+	}
+
+	for i, q := range p.requests {
+		if q == r {
+			p.requests = append(p.requests[:i], p.requests[i+1:]...)
+			break
+		}
+	}
+
+	if len(p.requests) == 0 {
+		b.detach(p)
+	}
+}
+
+func (b *Batcher) flush(p *batch) {
+	c := b.client.connection
+
+	if c.fatalerr != nil {
+		for _, r := range p.requests {
+			r.sent <- c.fatalerr
+		}
+		return
+	}
+
+	var buf bytes.Buffer
+	var framed []*batchedRequest
+
+	for _, r := range p.requests {
+		if err := c.writeMessageWithFrameTo(&buf, r.message); err != nil {
+			r.sent <- err
+			continue
+		}
+
+		framed = append(framed, r)
+	}
+
+	if len(framed) == 0 {
+		return
+	}
+
+	_, err := c.conn.Write(buf.Bytes())
+	for _, r := range framed {
+		r.sent <- err
+	}
+}
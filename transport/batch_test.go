@@ -0,0 +1,170 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countConn struct {
+	net.Conn
+	writes int32
+}
+
+func (c *countConn) Write(b []byte) (int, error) {
+	atomic.AddInt32(&c.writes, 1)
+	return c.Conn.Write(b)
+}
+
+func TestBatcher(t *testing.T) {
+	var lock sync.Mutex
+	var received []string
+
+	server, err := ListenTCP("127.0.0.1:0", ServerConfig{
+		MessageCallback: func(c Conn, bam *ByteArrayMessage) {
+			lock.Lock()
+			received = append(received, string(bam.Body))
+			lock.Unlock()
+
+			// reply in reverse order of the batch
+			var i int
+			fmt.Sscanf(string(bam.Body), "request %d", &i)
+			time.Sleep(time.Duration(10-i) * 5 * time.Millisecond)
+
+			msg := &Message{}
+			msg.Headers.RelatesTo = bam.Headers.Id
+			msg.Body = []byte("reply to " + string(bam.Body))
+			if err := c.SendOneWay(msg); err != nil {
+				t.Error(err)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go server.Serve()
+
+	var conn *countConn
+	client, err := DialTCP(server.Addr().String(), ClientConfig{
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			c, err := net.Dial(network, addr)
+			if err != nil {
+				return nil, err
+			}
+
+			conn = &countConn{Conn: c}
+			return conn, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	go client.Wait()
+
+	// flushed by MaxBatch only
+	b, err := NewBatcher(client, BatchConfig{Window: time.Hour, MaxBatch: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(ctx context.Context, body string) (*ByteArrayMessage, error) {
+		msg := &Message{}
+		msg.Headers.Action = "ECHO"
+		msg.Body = []byte(body)
+		return b.RequestReply(ctx, msg)
+	}
+
+	t.Run("demultiplexed", func(t *testing.T) {
+		writes := atomic.LoadInt32(&conn.writes)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				body := fmt.Sprintf("request %d", i)
+				reply, err := request(ctx, body)
+				if assert.NoError(t, err) {
+					assert.Equal(t, "reply to "+body, string(reply.Body))
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		assert.Equal(t, writes+1, atomic.LoadInt32(&conn.writes), "one write for the batch")
+	})
+
+	t.Run("cancelled before flush", func(t *testing.T) {
+		lock.Lock()
+		received = nil
+		lock.Unlock()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			_, err := request(ctx, "request cancelled")
+			done <- err
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+		assert.Equal(t, context.Canceled, <-done)
+
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, err := request(ctx, fmt.Sprintf("request %d", i))
+				assert.NoError(t, err)
+			}(i)
+		}
+		wg.Wait()
+
+		lock.Lock()
+		defer lock.Unlock()
+		assert.Len(t, received, 10)
+		assert.NotContains(t, received, "request cancelled")
+	})
+
+	t.Run("window", func(t *testing.T) {
+		b, err := NewBatcher(client, BatchConfig{Window: 10 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		msg := &Message{}
+		msg.Headers.Action = "ECHO"
+		msg.Body = []byte("request 9")
+		reply, err := b.RequestReply(ctx, msg)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "reply to request 9", string(reply.Body))
+		}
+	})
+
+	t.Run("config", func(t *testing.T) {
+		_, err := NewBatcher(client, BatchConfig{})
+		assert.Error(t, err)
+
+		_, err = NewBatcher(client, BatchConfig{Window: time.Second, MaxBatch: -1})
+		assert.Error(t, err)
+	})
+}
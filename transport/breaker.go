@@ -0,0 +1,178 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type CircuitBreakerState int
+
+const (
+	CircuitBreakerStateClosed CircuitBreakerState = iota
+	CircuitBreakerStateOpen
+	CircuitBreakerStateHalfOpen
+)
+
+func (s CircuitBreakerState) String() string {
+	switch s {
+	case CircuitBreakerStateClosed:
+		return "closed"
+	case CircuitBreakerStateOpen:
+		return "open"
+	case CircuitBreakerStateHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitBreakerState(%d)", int(s))
+	}
+}
+
+var ErrCircuitOpen = fmt.Errorf("circuit breaker open")
+
+type CircuitBreakerConfig struct {
+	// FailureThreshold consecutive failures within Window open the breaker
+	FailureThreshold int
+	Window           time.Duration
+
+	// Cooldown is how long the breaker stays open before a probe request is allowed
+	Cooldown time.Duration
+
+	OnStateChange func(from, to CircuitBreakerState)
+}
+
+type circuitBreaker struct {
+	config CircuitBreakerConfig
+	now    func() time.Time
+
+	lock         sync.Mutex
+	state        CircuitBreakerState
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	probing      bool
+
+	// made under lock, reported to OnStateChange by unlock
+	changes []stateChange
+}
+
+type stateChange struct {
+	from, to CircuitBreakerState
+}
+
+func newCircuitBreaker(config CircuitBreakerConfig) (*circuitBreaker, error) {
+	if config.FailureThreshold <= 0 {
+		return nil, fmt.Errorf("circuit breaker FailureThreshold must > 0")
+	}
+
+	if config.Cooldown <= 0 {
+		return nil, fmt.Errorf("circuit breaker Cooldown must > 0")
+	}
+
+	return &circuitBreaker{
+		config: config,
+		now:    time.Now,
+	}, nil
+}
+
+func (b *circuitBreaker) changeState(to CircuitBreakerState) {
+	from := b.state
+	if from == to {
+		return
+	}
+
+	b.state = to
+	b.changes = append(b.changes, stateChange{from: from, to: to})
+}
+
+// unlock releases lock before calling OnStateChange, so it may call back into the breaker, e.g. State
+func (b *circuitBreaker) unlock() {
+	changes := b.changes
+	b.changes = nil
+	b.lock.Unlock()
+
+	if b.config.OnStateChange == nil {
+		return
+	}
+
+	for _, c := range changes {
+		b.config.OnStateChange(c.from, c.to)
+	}
+}
+
+// allow reports whether a request can be sent, and whether it is the half-open probe
+func (b *circuitBreaker) allow() (bool, error) {
+	b.lock.Lock()
+	defer b.unlock()
+
+	switch b.state {
+	case CircuitBreakerStateOpen:
+		if b.now().Sub(b.openedAt) < b.config.Cooldown {
+			return false, ErrCircuitOpen
+		}
+
+		b.changeState(CircuitBreakerStateHalfOpen)
+		fallthrough
+	case CircuitBreakerStateHalfOpen:
+		if b.probing {
+			return false, ErrCircuitOpen
+		}
+
+		b.probing = true
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func (b *circuitBreaker) done(probe bool, err error) {
+	b.lock.Lock()
+	defer b.unlock()
+
+	if probe {
+		b.probing = false
+	} else if b.state != CircuitBreakerStateClosed {
+		// straggler sent before the breaker opened, only the probe decides when it closes again
+		return
+	}
+
+	// cancelled by caller, not a failure of the gateway
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	if err == nil {
+		b.failures = 0
+		b.changeState(CircuitBreakerStateClosed)
+		return
+	}
+
+	now := b.now()
+
+	if b.state == CircuitBreakerStateHalfOpen {
+		b.openedAt = now
+		b.changeState(CircuitBreakerStateOpen)
+		return
+	}
+
+	if b.failures == 0 || (b.config.Window > 0 && now.Sub(b.firstFailure) > b.config.Window) {
+		b.failures = 0
+		b.firstFailure = now
+	}
+
+	b.failures++
+
+	if b.failures >= b.config.FailureThreshold {
+		b.failures = 0
+		b.openedAt = now
+		b.changeState(CircuitBreakerStateOpen)
+	}
+}
+
+func (b *circuitBreaker) State() CircuitBreakerState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.state
+}
@@ -0,0 +1,148 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	var transitions []CircuitBreakerState
+
+	b, err := newCircuitBreaker(CircuitBreakerConfig{
+		FailureThreshold: 3,
+		Window:           10 * time.Second,
+		Cooldown:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// called without lock, the breaker can be used in the callback
+	b.config.OnStateChange = func(from, to CircuitBreakerState) {
+		assert.Equal(t, to, b.State())
+		transitions = append(transitions, to)
+	}
+
+	now := time.Unix(1000, 0)
+	b.now = func() time.Time { return now }
+
+	fail := func() {
+		probe, err := b.allow()
+		assert.NoError(t, err)
+		b.done(probe, fmt.Errorf("failure"))
+	}
+
+	t.Run("failures outside window", func(t *testing.T) {
+		fail()
+		fail()
+		now = now.Add(11 * time.Second)
+		fail()
+		assert.Equal(t, CircuitBreakerStateClosed, b.State())
+	})
+
+	t.Run("open after threshold", func(t *testing.T) {
+		fail()
+		fail()
+		assert.Equal(t, CircuitBreakerStateOpen, b.State())
+
+		_, err := b.allow()
+		assert.Equal(t, ErrCircuitOpen, err)
+	})
+
+	t.Run("probe fails", func(t *testing.T) {
+		now = now.Add(5 * time.Second)
+
+		probe, err := b.allow()
+		assert.NoError(t, err)
+		assert.True(t, probe)
+		assert.Equal(t, CircuitBreakerStateHalfOpen, b.State())
+
+		// only one probe at a time
+		_, err = b.allow()
+		assert.Equal(t, ErrCircuitOpen, err)
+
+		b.done(probe, fmt.Errorf("failure"))
+		assert.Equal(t, CircuitBreakerStateOpen, b.State())
+	})
+
+	t.Run("stragglers", func(t *testing.T) {
+		// requests allowed before the breaker opened
+		b.done(false, nil)
+		assert.Equal(t, CircuitBreakerStateOpen, b.State())
+
+		now = now.Add(5 * time.Second)
+
+		probe, err := b.allow()
+		assert.NoError(t, err)
+		assert.True(t, probe)
+
+		b.done(false, nil)
+		b.done(false, fmt.Errorf("failure"))
+		assert.Equal(t, CircuitBreakerStateHalfOpen, b.State())
+
+		// cancelled probe leaves the breaker half-open for the next probe
+		b.done(probe, fmt.Errorf("request: %w", context.Canceled))
+		assert.Equal(t, CircuitBreakerStateHalfOpen, b.State())
+	})
+
+	t.Run("probe succeeds", func(t *testing.T) {
+		now = now.Add(5 * time.Second)
+
+		probe, err := b.allow()
+		assert.NoError(t, err)
+		b.done(probe, nil)
+		assert.Equal(t, CircuitBreakerStateClosed, b.State())
+	})
+
+	assert.Equal(t, []CircuitBreakerState{
+		CircuitBreakerStateOpen,
+		CircuitBreakerStateHalfOpen,
+		CircuitBreakerStateOpen,
+		CircuitBreakerStateHalfOpen,
+		CircuitBreakerStateClosed,
+	}, transitions)
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	p1, p2, err := netPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p1.Close()
+	defer p2.Close()
+
+	// peer never replies
+	c, err := Connect(p1, ClientConfig{
+		CircuitBreaker: &CircuitBreakerConfig{
+			FailureThreshold: 2,
+			Cooldown:         time.Hour,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := c.RequestReply(ctx, &Message{})
+		cancel()
+
+		assert.Equal(t, context.DeadlineExceeded, err)
+	}
+
+	assert.Equal(t, CircuitBreakerStateOpen, c.CircuitBreakerState())
+
+	_, err = c.RequestReply(context.Background(), &Message{})
+	assert.Equal(t, ErrCircuitOpen, err)
+
+	t.Run("bad config", func(t *testing.T) {
+		_, err := Connect(p2, ClientConfig{
+			CircuitBreaker: &CircuitBreakerConfig{},
+		})
+		assert.Error(t, err)
+	})
+}
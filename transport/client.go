@@ -1,37 +1,167 @@
 package transport
 
 import (
+	"context"
+	"fmt"
 	"net"
+	"sync/atomic"
+	"time"
 )
 
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
 type Client struct {
 	*connection
+	breaker  *circuitBreaker
+	slots    chan struct{}
+	inflight int32 // atomic
 }
 
 type ClientConfig struct {
 	Config
 	MessageCallback MessageCallback
+
+	// CircuitBreaker short-circuits RequestReply after consecutive failures when set
+	CircuitBreaker *CircuitBreakerConfig
+
+	// MaxInFlight bounds concurrent RequestReply calls, new calls wait for a free slot
+	// unlimited if 0
+	MaxInFlight int
+
+	// Dial establishes the underlying connection, e.g. via a proxy or an in-memory pipe
+	// net.Dialer with DialTimeout and LocalAddr is used if not set
+	Dial        DialFunc
+	DialTimeout time.Duration
+	LocalAddr   net.Addr
+
+	// DialTrace reports durations of connection establishment phases, e.g. to find out whether a slow connect is DNS, TCP or TLS
+	DialTrace DialTraceFunc
+}
+
+func (c *ClientConfig) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if c.Dial != nil {
+		start := time.Now()
+		conn, err := c.Dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		c.DialTrace.done(DialPhaseConnect, start)
+		return conn, nil
+	}
+
+	d := net.Dialer{
+		Timeout:   c.DialTimeout,
+		LocalAddr: c.LocalAddr,
+	}
+
+	if c.DialTrace != nil {
+		return tracedDial(ctx, &d, c.DialTrace, network, addr)
+	}
+
+	return d.DialContext(ctx, network, addr)
 }
 
 func DialTCP(addr string, config ClientConfig) (*Client, error) {
-	conn, err := net.Dial("tcp", addr)
+	return DialTCPContext(context.Background(), addr, config)
+}
+
+func DialTCPContext(ctx context.Context, addr string, config ClientConfig) (*Client, error) {
+	conn, err := config.dial(ctx, "tcp", addr)
+
+	if err != nil {
+		return nil, err
+	}
 
+	c, err := Connect(conn, config)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 
-	return Connect(conn, config)
+	return c, nil
 }
 
 func Connect(conn net.Conn, config ClientConfig) (*Client, error) {
-	c, err := tapClientConn(conn, config.Config)
+	if config.MaxInFlight < 0 {
+		return nil, fmt.Errorf("MaxInFlight must >= 0")
+	}
+
+	var breaker *circuitBreaker
+	if config.CircuitBreaker != nil {
+		b, err := newCircuitBreaker(*config.CircuitBreaker)
+		if err != nil {
+			return nil, err
+		}
+
+		breaker = b
+	}
+
+	c, err := tapClientConn(conn, config.Config, config.DialTrace)
 	if err != nil {
 		return nil, err
 	}
 
 	c.messageCallback = config.MessageCallback
 
-	return &Client{
+	client := &Client{
 		connection: c,
-	}, nil
+		breaker:    breaker,
+	}
+
+	if config.MaxInFlight > 0 {
+		client.slots = make(chan struct{}, config.MaxInFlight)
+	}
+
+	return client, nil
+}
+
+func (c *Client) RequestReply(ctx context.Context, message *Message) (*ByteArrayMessage, error) {
+	return c.do(ctx, func() (*ByteArrayMessage, error) {
+		return c.connection.RequestReply(ctx, message)
+	})
+}
+
+// do runs request under MaxInFlight and CircuitBreaker
+func (c *Client) do(ctx context.Context, request func() (*ByteArrayMessage, error)) (*ByteArrayMessage, error) {
+	if c.slots != nil {
+		select {
+		case c.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		defer func() { <-c.slots }()
+	}
+
+	atomic.AddInt32(&c.inflight, 1)
+	defer atomic.AddInt32(&c.inflight, -1)
+
+	if c.breaker == nil {
+		return request()
+	}
+
+	probe, err := c.breaker.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := request()
+	c.breaker.done(probe, err)
+
+	return reply, err
+}
+
+// InFlight returns the number of RequestReply calls waiting for reply
+func (c *Client) InFlight() int {
+	return int(atomic.LoadInt32(&c.inflight))
+}
+
+// CircuitBreakerState returns the state of the circuit breaker, always closed if not configured
+func (c *Client) CircuitBreakerState() CircuitBreakerState {
+	if c.breaker == nil {
+		return CircuitBreakerStateClosed
+	}
+
+	return c.breaker.State()
 }
@@ -3,7 +3,10 @@ package transport
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"io"
 	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -237,6 +240,55 @@ func TestMessageCRC(t *testing.T) {
 
 }
 
+func TestReadMessage(t *testing.T) {
+	var buf bytes.Buffer
+
+	body, err := serialization.Marshal(&struct{ A string }{"a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Message{Body: body}
+	m.Headers.Actor = MessageActorTypeGenericTestActor
+	m.Headers.Action = "TEST"
+
+	for i := 0; i < 2; i++ {
+		if err := writeMessageWithFrame(&buf, m, frameWriteConfig{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data := buf.Bytes()
+	r := bytes.NewReader(data)
+
+	for i := 0; i < 2; i++ {
+		msg, err := ReadMessage(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, "TEST", msg.Headers.Action)
+		assert.Equal(t, MessageActorTypeGenericTestActor, msg.Headers.Actor)
+		assert.Equal(t, body, msg.Body)
+	}
+
+	_, err = ReadMessage(r)
+	assert.Equal(t, io.EOF, err)
+
+	t.Run("truncated", func(t *testing.T) {
+		_, err := ReadMessage(bytes.NewReader(data[:len(data)/2-1]))
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+	})
+
+	t.Run("bad frame length", func(t *testing.T) {
+		data2 := append(data[:0:0], data...)
+		data2[0], data2[1], data2[2], data2[3] = 1, 0, 0, 0
+
+		_, err := ReadMessage(bytes.NewReader(data2))
+		assert.Error(t, err)
+	})
+}
+
 func TestCancelRequest(t *testing.T) {
 	p1, p2, err := netPipe()
 	if err != nil {
@@ -445,3 +497,341 @@ func TestTransportMessages(t *testing.T) {
 	})
 
 }
+
+func TestKeepAlive(t *testing.T) {
+	t.Run("fabric heartbeat", func(t *testing.T) {
+		p1, p2, err := netPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p1.Close()
+		defer p2.Close()
+
+		var probes int32
+		c1, err := Connect(p1, ClientConfig{
+			Config: Config{
+				KeepAlive: 50 * time.Millisecond,
+				KeepAliveProbe: func(ctx context.Context, conn Conn) error {
+					atomic.AddInt32(&probes, 1)
+					return fmt.Errorf("should not be used")
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c2, err := Connect(p2, ClientConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go c1.Wait()
+		go c2.Wait()
+
+		time.Sleep(300 * time.Millisecond)
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&c1.peerHeartbeat))
+		assert.Equal(t, int32(0), atomic.LoadInt32(&probes))
+		assert.NoError(t, c1.SendOneWay(&Message{}))
+	})
+
+	t.Run("dead peer", func(t *testing.T) {
+		p1, p2, err := netPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p1.Close()
+		defer p2.Close()
+
+		c1, err := Connect(p1, ClientConfig{
+			Config: Config{
+				KeepAlive: 50 * time.Millisecond,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// advertise heartbeat but never answer
+		if err := mustTestConnection(t, p2).sendTransportInit(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan error)
+		go func() {
+			done <- c1.Wait()
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("connection should be closed by keepalive")
+		}
+	})
+
+	t.Run("late response", func(t *testing.T) {
+		p1, p2, err := netPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p1.Close()
+		defer p2.Close()
+
+		c1, err := Connect(p1, ClientConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c2, err := Connect(p2, ClientConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go c1.Wait()
+		go c2.Wait()
+
+		body, err := serialization.Marshal(&heartbeat{HeartbeatTimeTick: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		late := &ByteArrayMessage{Body: body}
+		late.Headers.Action = "HeartbeatResponse"
+
+		// a late response of an earlier ping does not fail the next one
+		go c1.handleTransportMessage(late)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err = c1.Ping(ctx)
+		assert.NoError(t, err)
+
+		// no ping waits, released by close
+		done := make(chan error)
+		go func() {
+			done <- c1.handleTransportMessage(late)
+		}()
+
+		assert.NoError(t, c1.Close())
+
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("response handler blocked after close")
+		}
+
+		_, err = c1.Ping(ctx)
+		assert.Error(t, err)
+	})
+
+	t.Run("fallback probe", func(t *testing.T) {
+		p1, p2, err := netPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p1.Close()
+		defer p2.Close()
+
+		noop := func(conn net.Conn) error { return nil }
+
+		probes := make(chan int, 10)
+		c1, err := Connect(p1, ClientConfig{
+			Config: Config{
+				Handshake: noop,
+				KeepAlive: 50 * time.Millisecond,
+				KeepAliveProbe: func(ctx context.Context, conn Conn) error {
+					probes <- 1
+					return nil
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c2, err := Connect(p2, ClientConfig{Config: Config{Handshake: noop}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go c1.Wait()
+		go c2.Wait()
+
+		select {
+		case <-probes:
+		case <-time.After(time.Second):
+			t.Fatal("fallback probe not called")
+		}
+	})
+}
+
+func TestCustomDialer(t *testing.T) {
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	var dialed string
+
+	inits := make(chan *ByteArrayMessage, 1)
+	go func() {
+		msg, err := ReadMessage(p2)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		inits <- msg
+	}()
+
+	c, err := DialTCP("gateway:19000", ClientConfig{
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialed = network + "://" + addr
+			return p1, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	assert.Equal(t, "tcp://gateway:19000", dialed)
+
+	msg := <-inits
+	assert.Equal(t, MessageActorTypeTransport, msg.Headers.Actor)
+
+	t.Run("dial error", func(t *testing.T) {
+		_, err := DialTCP("gateway:19000", ClientConfig{
+			Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return nil, fmt.Errorf("no route")
+			},
+		})
+
+		assert.EqualError(t, err, "no route")
+	})
+}
+
+func TestMaxInFlight(t *testing.T) {
+	p1, p2, err := netPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p1.Close()
+	defer p2.Close()
+
+	c, err := Connect(p1, ClientConfig{MaxInFlight: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var maxSeen int32
+	release := make(chan struct{})
+
+	// peer holds replies until released
+	server := mustTestConnection(t, p2)
+	server.messageCallback = func(conn Conn, msg *ByteArrayMessage) {
+		go func() {
+			if n := int32(c.InFlight()); n > atomic.LoadInt32(&maxSeen) {
+				atomic.StoreInt32(&maxSeen, n)
+			}
+
+			<-release
+
+			reply := &Message{}
+			reply.Headers.RelatesTo = msg.Headers.Id
+			conn.SendOneWay(reply)
+		}()
+	}
+
+	go c.Wait()
+	go server.Wait()
+
+	done := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			_, err := c.RequestReply(context.Background(), &Message{})
+			done <- err
+		}()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 2, c.InFlight())
+
+	t.Run("wait respects context", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := c.RequestReply(ctx, &Message{})
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+
+	close(release)
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, <-done)
+	}
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&maxSeen))
+	assert.Equal(t, 0, c.InFlight())
+
+	t.Run("bad config", func(t *testing.T) {
+		_, err := Connect(p2, ClientConfig{MaxInFlight: -1})
+		assert.Error(t, err)
+	})
+}
+
+func TestOrphanReply(t *testing.T) {
+	server, err := ListenTCP("127.0.0.1:0", ServerConfig{
+		MessageCallback: func(c Conn, bam *ByteArrayMessage) {
+			// reply after the request timed out
+			time.Sleep(200 * time.Millisecond)
+
+			msg := &Message{}
+			msg.Headers.RelatesTo = bam.Headers.Id
+			msg.Headers.Action = "LateReply"
+			if err := c.SendOneWay(msg); err != nil {
+				t.Error(err)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go server.Serve()
+
+	orphans := make(chan *ByteArrayMessage, 1)
+	client, err := DialTCP(server.Addr().String(), ClientConfig{
+		Config: Config{
+			OrphanReply: func(c Conn, bam *ByteArrayMessage) {
+				orphans <- bam
+			},
+		},
+		MessageCallback: func(c Conn, bam *ByteArrayMessage) {
+			t.Errorf("unexpected message %v", bam.Headers.Action)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	go client.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	msg := &Message{}
+	_, err = client.RequestReply(ctx, msg)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	select {
+	case orphan := <-orphans:
+		assert.Equal(t, msg.Headers.Id, orphan.Headers.RelatesTo)
+		assert.Equal(t, "LateReply", orphan.Headers.Action)
+	case <-time.After(5 * time.Second):
+		t.Fatal("orphan reply not received")
+	}
+}
@@ -1,11 +1,14 @@
 package transport
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tg123/phabrik/serialization"
@@ -13,12 +16,50 @@ import (
 
 type MessageCallback func(Conn, *ByteArrayMessage)
 
+// HandshakeFunc performs the connection negotiation on the raw connection
+// before any framed message is exchanged.
+type HandshakeFunc func(conn net.Conn) error
+
 type Config struct {
 	TLS                           *tls.Config
 	DisableCheckFrameHeaderCRC    bool
 	DisableGenerateFrameHeaderCRC bool
 	CheckFrameBodyCRC             bool
 	GenerateFrameBodyCRC          bool
+
+	// Handshake replaces the standard Fabric negotiation (TLS and transport init message)
+	// when set, TLS is ignored in this case. On an accepted connection bytes already read by the caller,
+	// e.g. the first frame body taken by Piper, are read by Handshake and later frames before the rest of conn.
+	Handshake HandshakeFunc
+
+	// KeepAlive probes the peer after no frame is received for KeepAlive,
+	// the connection is closed if the probe does not complete within KeepAlive.
+	// Fabric transport heartbeat is used if the peer advertised it in its transport init message,
+	// otherwise KeepAliveProbe, e.g. for non-Fabric peers with custom Handshake.
+	KeepAlive      time.Duration
+	KeepAliveProbe func(ctx context.Context, conn Conn) error
+
+	// PayloadCipher encrypts message bodies once the peer advertised the same support in its transport init message.
+	// Messages other than transport messages are held until the peer's init message arrives,
+	// and fail if the peer does not advertise support, e.g. Fabric, unless AllowPlaintextPayload.
+	// It cannot be used with Handshake as no transport init message is exchanged then.
+	PayloadCipher PayloadCipher
+
+	// AllowPlaintextPayload sends bodies in plain to peers without payload encryption instead of failing,
+	// note a middlebox stripping the advertisement from the init message then downgrades the connection
+	AllowPlaintextPayload bool
+
+	// OrphanReply receives replies whose request is no longer pending, e.g. a late reply after the request timed out,
+	// instead of MessageCallback, useful to log or count them when tuning timeouts
+	OrphanReply MessageCallback
+}
+
+func (config Config) validate() error {
+	if config.Handshake != nil && config.PayloadCipher != nil {
+		return fmt.Errorf("PayloadCipher is negotiated in transport init message, not sent with custom Handshake")
+	}
+
+	return nil
 }
 
 type Conn interface {
@@ -37,6 +78,7 @@ type connection struct {
 	requestTable    RequestTable
 	pinglock        sync.Mutex
 	pingCh          chan int64
+	done            chan struct{} // closed on Close, pingCh is never closed as responses may still be sent to it
 	msgfac          *messageFactory
 
 	frameRCfg frameReadConfig
@@ -44,24 +86,51 @@ type connection struct {
 
 	closeOnce sync.Once
 	fatalerr  error
+
+	keepAlive      time.Duration
+	keepAliveProbe func(ctx context.Context, conn Conn) error
+	lastActive     int64 // unix nano, atomic
+	peerHeartbeat  int32 // atomic
+
+	payloadCipher         PayloadCipher
+	allowPlaintextPayload bool
+	peerPayloadEncryption int32         // atomic
+	negotiated            chan struct{} // closed once the peer's transport init message is handled
+	negotiateOnce         sync.Once
+
+	orphanReply MessageCallback
 }
 
 func newConnection(config Config) (*connection, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
 	mf, err := newMessageFactory()
 	if err != nil {
 		return nil, err
 	}
 
 	c := &connection{
-		msgfac: mf,
-		pingCh: make(chan int64),
+		msgfac:         mf,
+		pingCh:         make(chan int64),
+		done:           make(chan struct{}),
+		keepAlive:      config.KeepAlive,
+		keepAliveProbe: config.KeepAliveProbe,
+		lastActive:     time.Now().UnixNano(),
+		payloadCipher:  config.PayloadCipher,
+		negotiated:     make(chan struct{}),
+		orphanReply:    config.OrphanReply,
+
+		allowPlaintextPayload: config.AllowPlaintextPayload,
 	}
 
-	c.frameWCfg.SecurityProviderMask = securityProviderNone
+	c.frameWCfg.SecurityProviderMask = SecurityProviderNone
 	c.frameRCfg.CheckFrameHeaderCRC = !config.DisableCheckFrameHeaderCRC
 	c.frameWCfg.FrameHeaderCRC = !config.DisableGenerateFrameHeaderCRC
 	c.frameRCfg.CheckFrameBodyCRC = config.CheckFrameBodyCRC
 	c.frameWCfg.FrameBodyCRC = config.GenerateFrameBodyCRC
+	c.frameRCfg.PayloadCipher = config.PayloadCipher
 
 	return c, nil
 }
@@ -72,6 +141,19 @@ func tapAcceptedConn(conn net.Conn, config Config, initbuf []byte) (*connection,
 		return nil, err
 	}
 
+	if config.Handshake != nil {
+		if len(initbuf) > 0 {
+			conn = &initConn{Conn: conn, r: io.MultiReader(bytes.NewReader(initbuf), conn)}
+		}
+
+		if err := config.Handshake(conn); err != nil {
+			return nil, err
+		}
+
+		c.conn = conn
+		return c, nil
+	}
+
 	if config.TLS != nil {
 		tlsconn, err := createTlsServerConn(conn, c.msgfac, config.TLS, initbuf)
 		if err != nil {
@@ -91,17 +173,40 @@ func tapAcceptedConn(conn net.Conn, config Config, initbuf []byte) (*connection,
 	return c, nil
 }
 
-func tapClientConn(conn net.Conn, config Config) (*connection, error) {
+// initConn reads bytes taken from conn before it was tapped ahead of conn
+type initConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *initConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func tapClientConn(conn net.Conn, config Config, trace DialTraceFunc) (*connection, error) {
 	c, err := newConnection(config)
 	if err != nil {
 		return nil, err
 	}
 
+	if config.Handshake != nil {
+		start := time.Now()
+		if err := config.Handshake(conn); err != nil {
+			return nil, err
+		}
+		trace.done(DialPhaseNegotiation, start)
+
+		c.conn = conn
+		return c, nil
+	}
+
 	if config.TLS != nil {
+		start := time.Now()
 		tlsconn, err := createTlsClientConn(conn, c.msgfac, config.TLS)
 		if err != nil {
 			return nil, err
 		}
+		trace.done(DialPhaseTLS, start)
 
 		c.setTls()
 		c.conn = tlsconn
@@ -109,9 +214,11 @@ func tapClientConn(conn net.Conn, config Config) (*connection, error) {
 		c.conn = conn
 	}
 
+	start := time.Now()
 	if err := c.sendTransportInit(nil); err != nil {
 		return nil, err
 	}
+	trace.done(DialPhaseNegotiation, start)
 
 	return c, nil
 }
@@ -121,7 +228,7 @@ func (c *connection) setTls() {
 	c.frameRCfg.CheckFrameBodyCRC = false
 	c.frameWCfg.FrameHeaderCRC = false
 	c.frameWCfg.FrameBodyCRC = false
-	c.frameWCfg.SecurityProviderMask = securityProviderSsl
+	c.frameWCfg.SecurityProviderMask = SecurityProviderSsl
 }
 
 func (c *connection) SetMessageCallback(cb MessageCallback) {
@@ -132,13 +239,14 @@ func (c *connection) Close() error {
 	err := c.conn.Close()
 
 	c.closeOnce.Do(func() {
-		close(c.pingCh)
+		close(c.done)
 		c.requestTable.Close()
 	})
 
 	return err
 }
 
+// heartbeat is the body of both HeartbeatRequest and HeartbeatResponse, the response echos the request
 type heartbeat struct {
 	HeartbeatTimeTick int64
 }
@@ -161,14 +269,23 @@ func (c *connection) Ping(ctx context.Context) (time.Duration, error) {
 		return -1, err
 	}
 
-	select {
-	case <-ctx.Done():
-		return -1, ctx.Err()
-	case t := <-c.pingCh:
-		if t != b.HeartbeatTimeTick {
-			return -1, fmt.Errorf("heartbeak time tick out of order")
+	for {
+		select {
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		case <-c.done:
+			return -1, fmt.Errorf("connection closed")
+		case t := <-c.pingCh:
+			// late response to an earlier ping that timed out
+			if t < b.HeartbeatTimeTick {
+				continue
+			}
+
+			if t != b.HeartbeatTimeTick {
+				return -1, fmt.Errorf("heartbeak time tick out of order")
+			}
+			return time.Since(time.Unix(0, t)), nil
 		}
-		return time.Since(time.Unix(0, t)), nil
 	}
 }
 
@@ -193,12 +310,77 @@ func (c *connection) handleTransportMessage(msg *ByteArrayMessage) error {
 			return err
 		}
 
-		c.pingCh <- b.HeartbeatTimeTick
+		// a response no Ping waits for is taken by the next Ping or dropped on close
+		select {
+		case c.pingCh <- b.HeartbeatTimeTick:
+		case <-c.done:
+		}
+	case "":
+		var b transportInitMessageBody
+
+		if err := serialization.Unmarshal(msg.Body, &b); err != nil {
+			return err
+		}
+
+		if b.HeartbeatSupported {
+			atomic.StoreInt32(&c.peerHeartbeat, 1)
+		}
+
+		if b.ConnectionFeatureFlags&connectionFeaturePayloadEncryption != 0 {
+			atomic.StoreInt32(&c.peerPayloadEncryption, 1)
+		}
+
+		c.negotiateOnce.Do(func() {
+			close(c.negotiated)
+		})
 	default:
 	}
 	return nil
 }
 
+func (c *connection) probe() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.keepAlive)
+	defer cancel()
+
+	if atomic.LoadInt32(&c.peerHeartbeat) == 1 {
+		_, err := c.Ping(ctx)
+		return err
+	}
+
+	if c.keepAliveProbe != nil {
+		return c.keepAliveProbe(ctx, c)
+	}
+
+	// no way to probe peer
+	return nil
+}
+
+func (c *connection) keepAliveLoop(done <-chan struct{}) {
+	timer := time.NewTimer(c.keepAlive)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&c.lastActive)))
+		if idle < c.keepAlive {
+			timer.Reset(c.keepAlive - idle)
+			continue
+		}
+
+		if err := c.probe(); err != nil {
+			c.Close()
+			return
+		}
+
+		timer.Reset(c.keepAlive)
+	}
+}
+
 type transportInitMessageBody struct {
 	Address                string
 	Instance               uint64
@@ -221,11 +403,16 @@ func (c *connection) sendTransportInit(conn net.Conn) error {
 	msg := c.msgfac.newMessage()
 	msg.Headers.Actor = MessageActorTypeTransport
 	msg.Headers.HighPriority = true
+	features := uint32(1)
+	if c.payloadCipher != nil {
+		features |= connectionFeaturePayloadEncryption
+	}
+
 	msg.Body = &transportInitMessageBody{
 		Address:                addr,
 		Nonce:                  nonce,
 		HeartbeatSupported:     true,
-		ConnectionFeatureFlags: 1,
+		ConnectionFeatureFlags: features,
 	}
 
 	if err := c.SendOneWay(msg); err != nil {
@@ -236,7 +423,25 @@ func (c *connection) sendTransportInit(conn net.Conn) error {
 }
 
 func (c *connection) writeMessageWithFrame(message *Message) error {
-	return writeMessageWithFrame(c.conn, message, c.frameWCfg)
+	return c.writeMessageWithFrameTo(c.conn, message)
+}
+
+func (c *connection) writeMessageWithFrameTo(w io.Writer, message *Message) error {
+	// transport messages, e.g. init and heartbeat, stay readable by the peer
+	if c.payloadCipher != nil && message.Headers.Actor != MessageActorTypeTransport {
+		ctx, cancel := context.WithTimeout(context.Background(), payloadNegotiationTimeout)
+		defer cancel()
+
+		if err := c.waitPayloadNegotiation(ctx); err != nil {
+			return err
+		}
+
+		if c.encryptPayload() {
+			return c.writeEncryptedMessageWithFrame(w, message)
+		}
+	}
+
+	return writeMessageWithFrame(w, message, c.frameWCfg)
 }
 
 func (c *connection) nextMessageHeaderAndBodyFromFrame() (*MessageHeaders, []byte, error) {
@@ -246,12 +451,21 @@ func (c *connection) nextMessageHeaderAndBodyFromFrame() (*MessageHeaders, []byt
 func (c *connection) Wait() error {
 	defer c.Close()
 
+	if c.keepAlive > 0 {
+		done := make(chan struct{})
+		defer close(done)
+
+		go c.keepAliveLoop(done)
+	}
+
 	for {
 		headers, body, err := c.nextMessageHeaderAndBodyFromFrame()
 		if err != nil {
 			return err
 		}
 
+		atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+
 		msg := &ByteArrayMessage{
 			Headers: *headers,
 			Body:    body,
@@ -277,7 +491,9 @@ func (c *connection) Wait() error {
 		}
 
 		if !c.requestTable.Feed(msg) {
-			if c.messageCallback != nil {
+			if c.orphanReply != nil && !headers.RelatesTo.IsEmpty() {
+				c.orphanReply(c, msg)
+			} else if c.messageCallback != nil {
 				c.messageCallback(c, msg)
 			}
 		}
@@ -295,6 +511,15 @@ func (c *connection) SendOneWay(message *Message) error {
 func (c *connection) RequestReply(ctx context.Context, message *Message) (*ByteArrayMessage, error) {
 	c.msgfac.fillMessageId(message)
 	message.Headers.ExpectsReply = true
+	setDeadlineHeader(ctx, &message.Headers)
+
+	// bounded by ctx rather than payloadNegotiationTimeout
+	if c.payloadCipher != nil && message.Headers.Actor != MessageActorTypeTransport {
+		if err := c.waitPayloadNegotiation(ctx); err != nil {
+			return nil, err
+		}
+	}
+
 	pr := c.requestTable.Put(message)
 	defer pr.Close()
 
@@ -0,0 +1,62 @@
+package transport
+
+import (
+	"context"
+	"time"
+
+	"github.com/tg123/phabrik/common"
+)
+
+// TimeoutHeader carries the remaining time the sender is willing to wait for the reply
+type TimeoutHeader struct {
+	Timeout common.TimeSpan
+}
+
+func init() {
+	RegisterHeaderActivator(MessageHeaderIdTypeTimeout, func() interface{} {
+		return &TimeoutHeader{}
+	})
+}
+
+// setDeadlineHeader writes the remaining time of ctx into the timeout header unless the message already has one
+func setDeadlineHeader(ctx context.Context, h *MessageHeaders) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return
+	}
+
+	remaining := time.Until(deadline)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	h.SetCustomHeader(MessageHeaderIdTypeTimeout, &TimeoutHeader{
+		Timeout: common.TimeSpanFromDuration(remaining),
+	})
+}
+
+// Timeout returns the timeout carried in the timeout header
+func (h *MessageHeaders) Timeout() (time.Duration, bool) {
+	v, ok := h.GetFirstCustomHeader(MessageHeaderIdTypeTimeout)
+	if !ok {
+		return 0, false
+	}
+
+	th, ok := v.(*TimeoutHeader)
+	if !ok {
+		return 0, false
+	}
+
+	return th.Timeout.ToDuration(), true
+}
+
+// RequestContext derives a context from parent that expires when the sender of msg stops waiting for the reply
+// the returned context has no extra deadline if msg carries no timeout header
+func RequestContext(parent context.Context, msg *ByteArrayMessage) (context.Context, context.CancelFunc) {
+	timeout, ok := msg.Headers.Timeout()
+	if !ok {
+		return context.WithCancel(parent)
+	}
+
+	return context.WithTimeout(parent, timeout)
+}
@@ -0,0 +1,80 @@
+package transport
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tg123/phabrik/common"
+)
+
+func TestRequestDeadlinePropagation(t *testing.T) {
+	deadlines := make(chan time.Time, 1)
+
+	server, err := ListenTCP("127.0.0.1:0", ServerConfig{
+		MessageCallback: func(c Conn, bam *ByteArrayMessage) {
+			ctx, cancel := RequestContext(context.Background(), bam)
+			defer cancel()
+
+			deadline, _ := ctx.Deadline()
+			deadlines <- deadline
+
+			msg := &Message{}
+			msg.Headers.RelatesTo = bam.Headers.Id
+			if err := c.SendOneWay(msg); err != nil {
+				t.Error(err)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go server.Serve()
+
+	client, err := DialTCP(server.Addr().String(), ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	go client.Wait()
+
+	t.Run("with deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		clientDeadline, _ := ctx.Deadline()
+
+		if _, err := client.RequestReply(ctx, &Message{}); err != nil {
+			t.Fatal(err)
+		}
+
+		serverDeadline := <-deadlines
+		assert.False(t, serverDeadline.IsZero())
+		assert.WithinDuration(t, clientDeadline, serverDeadline, time.Second)
+		assert.False(t, serverDeadline.After(clientDeadline.Add(100*time.Millisecond)))
+	})
+
+	t.Run("without deadline", func(t *testing.T) {
+		if _, err := client.RequestReply(context.Background(), &Message{}); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.True(t, (<-deadlines).IsZero())
+	})
+
+	t.Run("explicit header kept", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		msg := &Message{}
+		msg.Headers.SetCustomHeader(MessageHeaderIdTypeTimeout, &TimeoutHeader{Timeout: common.TimeSpanFromDuration(time.Second)})
+
+		if _, err := client.RequestReply(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+
+		assert.WithinDuration(t, time.Now().Add(time.Second), <-deadlines, 500*time.Millisecond)
+	})
+}
@@ -0,0 +1,76 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// PayloadCipher encrypts message bodies end to end with a session key it negotiates and owns,
+// e.g. when TLS is terminated by a proxy in between. Message headers are not encrypted.
+type PayloadCipher interface {
+	Encrypt(body []byte) ([]byte, error)
+	Decrypt(body []byte) ([]byte, error)
+}
+
+// advertised in transport init ConnectionFeatureFlags by peers with a PayloadCipher
+// phabrik extension, not known to Fabric peers which never advertise it
+const connectionFeaturePayloadEncryption uint32 = 0x100
+
+// how long a message without deadline waits for the peer's transport init message with PayloadCipher
+const payloadNegotiationTimeout = 10 * time.Second
+
+func decryptFrameBody(header *frameheader, body []byte, cipher PayloadCipher) ([]byte, error) {
+	if header.flags()&FrameFlagEncryptedBody == 0 {
+		return body, nil
+	}
+
+	if cipher == nil {
+		return nil, fmt.Errorf("encrypted frame body without payload cipher")
+	}
+
+	return cipher.Decrypt(body)
+}
+
+// encryptPayload reports whether bodies sent to the peer are encrypted,
+// only after both sides advertised support in their transport init message
+func (c *connection) encryptPayload() bool {
+	return c.payloadCipher != nil && atomic.LoadInt32(&c.peerPayloadEncryption) == 1
+}
+
+// waitPayloadNegotiation blocks until the peer's transport init message tells whether bodies are encrypted,
+// so no body is sent in plain before, and fails if the peer cannot decrypt unless plaintext is allowed
+func (c *connection) waitPayloadNegotiation(ctx context.Context) error {
+	select {
+	case <-c.negotiated:
+	case <-c.done:
+		return fmt.Errorf("connection closed")
+	case <-ctx.Done():
+		return fmt.Errorf("payload encryption not negotiated: %w", ctx.Err())
+	}
+
+	if c.encryptPayload() || c.allowPlaintextPayload {
+		return nil
+	}
+
+	return fmt.Errorf("peer does not support payload encryption")
+}
+
+func (c *connection) writeEncryptedMessageWithFrame(w io.Writer, message *Message) error {
+	headerLen, msg, err := message.marshal()
+	if err != nil {
+		return err
+	}
+
+	body, err := c.payloadCipher.Encrypt(msg[headerLen:])
+	if err != nil {
+		return err
+	}
+
+	config := c.frameWCfg
+	config.Flags |= FrameFlagEncryptedBody
+
+	return writeFrame(w, headerLen, append(msg[:headerLen:headerLen], body...), config)
+}
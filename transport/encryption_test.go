@@ -0,0 +1,168 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type xorCipher byte
+
+func (x xorCipher) xor(body []byte) []byte {
+	r := make([]byte, len(body))
+	for i, b := range body {
+		r[i] = b ^ byte(x)
+	}
+	return r
+}
+
+func (x xorCipher) Encrypt(body []byte) ([]byte, error) {
+	return x.xor(body), nil
+}
+
+func (x xorCipher) Decrypt(body []byte) ([]byte, error) {
+	return x.xor(body), nil
+}
+
+type recordConn struct {
+	net.Conn
+	lock    sync.Mutex
+	written bytes.Buffer
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	c.lock.Lock()
+	c.written.Write(b)
+	c.lock.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *recordConn) contains(b []byte) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return bytes.Contains(c.written.Bytes(), b)
+}
+
+func TestPayloadEncryption(t *testing.T) {
+	secret := []byte("plain text body")
+
+	run := func(t *testing.T, serverCipher PayloadCipher, clientConfig Config) (*recordConn, error) {
+		server, err := ListenTCP("127.0.0.1:0", ServerConfig{
+			Config: Config{PayloadCipher: serverCipher},
+			MessageCallback: func(c Conn, bam *ByteArrayMessage) {
+				msg := &Message{}
+				msg.Headers.RelatesTo = bam.Headers.Id
+				msg.Body = bam.Body
+				if err := c.SendOneWay(msg); err != nil {
+					t.Error(err)
+				}
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		go server.Serve()
+
+		var conn *recordConn
+		client, err := DialTCP(server.Addr().String(), ClientConfig{
+			Config: clientConfig,
+			Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				c, err := net.Dial(network, addr)
+				if err != nil {
+					return nil, err
+				}
+
+				conn = &recordConn{Conn: c}
+				return conn, nil
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		go client.Wait()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		// sent right after dial, held until the init message from server arrives
+		msg := &Message{}
+		msg.Headers.Action = "ECHO"
+		msg.Body = secret
+		reply, err := client.RequestReply(ctx, msg)
+		if err != nil {
+			return conn, err
+		}
+
+		assert.Equal(t, secret, reply.Body)
+		return conn, nil
+	}
+
+	t.Run("negotiated", func(t *testing.T) {
+		conn, err := run(t, xorCipher(0x5a), Config{PayloadCipher: xorCipher(0x5a)})
+		assert.NoError(t, err)
+		assert.False(t, conn.contains(secret))
+		assert.True(t, conn.contains(xorCipher(0x5a).xor(secret)))
+	})
+
+	t.Run("peer without cipher", func(t *testing.T) {
+		conn, err := run(t, nil, Config{PayloadCipher: xorCipher(0x5a)})
+		if assert.Error(t, err) {
+			assert.Equal(t, "peer does not support payload encryption", err.Error())
+		}
+		assert.False(t, conn.contains(secret))
+	})
+
+	t.Run("allow plaintext", func(t *testing.T) {
+		conn, err := run(t, nil, Config{PayloadCipher: xorCipher(0x5a), AllowPlaintextPayload: true})
+		assert.NoError(t, err)
+		assert.True(t, conn.contains(secret))
+	})
+
+	t.Run("custom handshake", func(t *testing.T) {
+		p1, p2, err := netPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p1.Close()
+		defer p2.Close()
+
+		noop := func(conn net.Conn) error { return nil }
+
+		_, err = Connect(p1, ClientConfig{Config: Config{Handshake: noop, PayloadCipher: xorCipher(0x5a)}})
+		if assert.Error(t, err) {
+			assert.Equal(t, "PayloadCipher is negotiated in transport init message, not sent with custom Handshake", err.Error())
+		}
+
+		_, err = Listen(nil, ServerConfig{Config: Config{Handshake: noop, PayloadCipher: xorCipher(0x5a)}})
+		assert.Error(t, err)
+	})
+}
+
+func TestEncryptedFrameWithoutCipher(t *testing.T) {
+	msg := &Message{}
+	msg.Body = []byte{1, 2, 3}
+
+	headerLen, data, err := msg.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeFrame(&buf, headerLen, append(data[:headerLen:headerLen], xorCipher(1).xor(data[headerLen:])...), frameWriteConfig{Flags: FrameFlagEncryptedBody}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = nextMessageHeaderAndBodyFromFrame(bytes.NewReader(buf.Bytes()), frameReadConfig{})
+	assert.Error(t, err)
+
+	_, body, err := nextMessageHeaderAndBodyFromFrame(bytes.NewReader(buf.Bytes()), frameReadConfig{PayloadCipher: xorCipher(1)})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, body)
+}
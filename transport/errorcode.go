@@ -4,5 +4,6 @@ type FabricErrorCode int64
 
 // TODO import errorcodevalue.h
 const (
-	FabricErrorCodeSuccess FabricErrorCode = 0
+	FabricErrorCodeSuccess         FabricErrorCode = 0
+	FabricErrorCodeOperationFailed FabricErrorCode = 0x80004005 // E_FAIL
 )
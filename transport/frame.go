@@ -10,17 +10,59 @@ import (
 	"github.com/sigurn/crc8"
 )
 
-type securityProvider uint8
+type SecurityProvider uint8
 
 const (
-	securityProviderNone      securityProvider = 0
-	securityProviderSsl       securityProvider = 0x1 // Certs
-	securityProviderKerberos  securityProvider = 0x2 // Windows auth
-	securityProviderNegotiate securityProvider = 0x3 // Windows auth
-	securityProviderClaims    securityProvider = 0x4 // Can be for AAD for DSTS
-	securityProviderLast      securityProvider = 0x4 // Maximum size for this enum is 3 bits.
+	SecurityProviderNone      SecurityProvider = 0
+	SecurityProviderSsl       SecurityProvider = 0x1 // Certs
+	SecurityProviderKerberos  SecurityProvider = 0x2 // Windows auth
+	SecurityProviderNegotiate SecurityProvider = 0x3 // Windows auth
+	SecurityProviderClaims    SecurityProvider = 0x4 // Can be for AAD for DSTS
+	SecurityProviderLast      SecurityProvider = 0x4 // Maximum size for this enum is 3 bits.
 )
 
+// FrameFlags are the bits of frame security provider mask above the 3 bits of security provider
+type FrameFlags uint8
+
+const (
+	// FrameFlagEncryptedBody is set when the body is encrypted by PayloadCipher
+	// phabrik extension, Fabric peers never set it
+	FrameFlagEncryptedBody FrameFlags = 0x80
+
+	securityProviderBits            = 0x07
+	knownFrameFlags      FrameFlags = FrameFlagEncryptedBody
+)
+
+// Frame is the frame header of a message, frame length and crc values are computed on write
+type Frame struct {
+	SecurityProvider SecurityProvider
+	Flags            FrameFlags
+
+	// crc8 of frame header and crc32 of frame body, written when set, or were not 0 when read
+	HeaderCRC bool
+	BodyCRC   bool
+}
+
+// DefaultFrame is the frame of Fabric request and reply messages on an unsecured connection
+func DefaultFrame() Frame {
+	return Frame{
+		SecurityProvider: SecurityProviderNone,
+		HeaderCRC:        true,
+	}
+}
+
+func (f Frame) validate() error {
+	if f.SecurityProvider > SecurityProviderLast {
+		return fmt.Errorf("unknown frame security provider %v", f.SecurityProvider)
+	}
+
+	if f.Flags&^knownFrameFlags != 0 || f.Flags&securityProviderBits != 0 {
+		return fmt.Errorf("unexpected frame flags 0x%02x", uint8(f.Flags))
+	}
+
+	return nil
+}
+
 type frameheader struct {
 	FrameLength          uint32
 	SecurityProviderMask uint8
@@ -31,9 +73,24 @@ type frameheader struct {
 
 var sizeOfFrameheader = binary.Size(frameheader{})
 
+func (h *frameheader) flags() FrameFlags {
+	return FrameFlags(h.SecurityProviderMask &^ securityProviderBits)
+}
+
+func (h *frameheader) frame() Frame {
+	return Frame{
+		SecurityProvider: SecurityProvider(h.SecurityProviderMask & securityProviderBits),
+		Flags:            h.flags(),
+		HeaderCRC:        h.FrameHeaderCRC != 0,
+		BodyCRC:          h.FrameBodyCRC != 0,
+	}
+}
+
 type frameReadConfig struct {
 	CheckFrameHeaderCRC bool
 	CheckFrameBodyCRC   bool
+	CheckPresentCRC     bool // check crc values which are not 0
+	PayloadCipher       PayloadCipher
 }
 
 func nextFrame(r io.Reader, config frameReadConfig) (*frameheader, []byte, error) {
@@ -55,12 +112,20 @@ func nextFrame(r io.Reader, config frameReadConfig) (*frameheader, []byte, error
 		return nil, nil, err
 	}
 
-	if config.CheckFrameHeaderCRC {
+	if config.CheckFrameHeaderCRC || (config.CheckPresentCRC && header.FrameHeaderCRC != 0) {
 		if header.FrameHeaderCRC != crc8.Checksum(b.Bytes(), crc8.MakeTable(crc8.CRC8)) {
 			return nil, nil, fmt.Errorf("frame header crc8 check fail")
 		}
 	}
 
+	if header.FrameLength < uint32(sizeOfFrameheader) {
+		return nil, nil, fmt.Errorf("bad frame length %v", header.FrameLength)
+	}
+
+	if err := header.frame().validate(); err != nil {
+		return nil, nil, err
+	}
+
 	body := make([]byte, header.FrameLength-uint32(sizeOfFrameheader))
 
 	_, err = io.ReadFull(r, body)
@@ -68,7 +133,7 @@ func nextFrame(r io.Reader, config frameReadConfig) (*frameheader, []byte, error
 		return nil, nil, err
 	}
 
-	if config.CheckFrameBodyCRC {
+	if config.CheckFrameBodyCRC || (config.CheckPresentCRC && header.FrameBodyCRC != 0) {
 		if header.FrameBodyCRC != crc32.Checksum(body, crc32.IEEETable) {
 			return nil, nil, fmt.Errorf("frame body crc32 check fail")
 		}
@@ -80,9 +145,10 @@ func nextFrame(r io.Reader, config frameReadConfig) (*frameheader, []byte, error
 var crc8table = crc8.MakeTable(crc8.CRC8)
 
 type frameWriteConfig struct {
-	SecurityProviderMask securityProvider
+	SecurityProviderMask SecurityProvider
 	FrameHeaderCRC       bool
 	FrameBodyCRC         bool
+	Flags                FrameFlags
 }
 
 func writeFrame(w io.Writer, headerLen int, msg []byte, config frameWriteConfig) error {
@@ -95,6 +161,8 @@ func writeFrame(w io.Writer, headerLen int, msg []byte, config frameWriteConfig)
 		FrameBodyCRC:         0,
 	}
 
+	tcpheader.SecurityProviderMask |= uint8(config.Flags)
+
 	var b bytes.Buffer
 	err := binary.Write(&b, binary.LittleEndian, tcpheader)
 	if err != nil {
@@ -109,11 +177,20 @@ func writeFrame(w io.Writer, headerLen int, msg []byte, config frameWriteConfig)
 		tcpheader.FrameBodyCRC = crc32.Checksum(msg, crc32.IEEETable)
 	}
 
-	err = binary.Write(w, binary.LittleEndian, tcpheader)
-	if err != nil {
+	return writeRawFrame(w, tcpheader, msg)
+}
+
+// writeRawFrame writes header and body as is with a single write
+func writeRawFrame(w io.Writer, header *frameheader, body []byte) error {
+	var frame bytes.Buffer
+	frame.Grow(sizeOfFrameheader + len(body))
+
+	if err := binary.Write(&frame, binary.LittleEndian, header); err != nil {
 		return err
 	}
 
-	_, err = w.Write(msg)
+	frame.Write(body)
+
+	_, err := w.Write(frame.Bytes())
 	return err
 }
@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	frames := []Frame{
+		DefaultFrame(),
+		{SecurityProvider: SecurityProviderSsl},
+		{SecurityProvider: SecurityProviderClaims, HeaderCRC: true, BodyCRC: true},
+		{SecurityProvider: SecurityProviderNone, Flags: FrameFlagEncryptedBody, BodyCRC: true},
+	}
+
+	for _, frame := range frames {
+		msg := &Message{}
+		msg.Headers.Action = "Action"
+		msg.Body = []byte{1, 2, 3}
+
+		var buf bytes.Buffer
+		if err := WriteFrame(&buf, frame, msg); err != nil {
+			t.Fatal(err)
+		}
+
+		data := buf.Bytes()
+		assert.Equal(t, uint32(len(data)), binary.LittleEndian.Uint32(data))
+		assert.Equal(t, uint8(frame.SecurityProvider)|uint8(frame.Flags), data[4])
+
+		f, bam, err := ReadFrame(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, frame, f)
+		assert.Equal(t, "Action", bam.Headers.Action)
+		assert.Equal(t, []byte{1, 2, 3}, bam.Body)
+	}
+}
+
+func TestFrameRejected(t *testing.T) {
+	msg := &Message{}
+	msg.Body = []byte{1}
+
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, DefaultFrame(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	valid := buf.Bytes()
+
+	corrupt := func(f func(b []byte)) []byte {
+		b := append([]byte(nil), valid...)
+		f(b)
+		return b
+	}
+
+	cases := map[string][]byte{
+		"unknown flag":     corrupt(func(b []byte) { b[4] |= 0x08 }),
+		"unknown provider": corrupt(func(b []byte) { b[4] = 0x05 }),
+		"header crc":       corrupt(func(b []byte) { b[5] ^= 0xff }),
+		"body crc":         corrupt(func(b []byte) { b[8] = 1 }),
+		"short length":     corrupt(func(b []byte) { binary.LittleEndian.PutUint32(b, 4) }),
+	}
+
+	for name, data := range cases {
+		_, _, err := ReadFrame(bytes.NewReader(data))
+		assert.Error(t, err, name)
+	}
+
+	t.Run("connection", func(t *testing.T) {
+		_, _, err := nextMessageHeaderAndBodyFromFrame(bytes.NewReader(cases["unknown flag"]), frameReadConfig{})
+		assert.Error(t, err)
+	})
+
+	t.Run("write", func(t *testing.T) {
+		assert.Error(t, WriteFrame(&bytes.Buffer{}, Frame{Flags: 0x10}, msg))
+		assert.Error(t, WriteFrame(&bytes.Buffer{}, Frame{Flags: 0x01}, msg))
+		assert.Error(t, WriteFrame(&bytes.Buffer{}, Frame{SecurityProvider: 7}, msg))
+	})
+}
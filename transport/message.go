@@ -2,6 +2,7 @@ package transport
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"sync/atomic"
 
@@ -62,15 +63,74 @@ func nextMessageHeaderAndBodyFromFrame(r io.Reader, config frameReadConfig) (*Me
 		return nil, nil, err
 	}
 
+	if int(frameheader.HeaderLength) > len(framebody) {
+		return nil, nil, fmt.Errorf("bad frame header length %v", frameheader.HeaderLength)
+	}
+
 	headers, err := parseFabricMessageHeaders(bytes.NewBuffer(framebody[:frameheader.HeaderLength]))
 	if err != nil {
 		return nil, nil, err
 	}
 
-	body := framebody[frameheader.HeaderLength:]
+	body, err := decryptFrameBody(frameheader, framebody[frameheader.HeaderLength:], config.PayloadCipher)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	return headers, body, nil
 }
 
+// ReadMessage reads next framed message from r, frame crc is not checked
+// useful to inspect captured traffic
+func ReadMessage(r io.Reader) (*ByteArrayMessage, error) {
+	headers, body, err := nextMessageHeaderAndBodyFromFrame(r, frameReadConfig{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ByteArrayMessage{
+		Headers: *headers,
+		Body:    body,
+	}, nil
+}
+
+// WriteFrame writes message framed with frame, which must have a known security provider and flags
+func WriteFrame(w io.Writer, frame Frame, message *Message) error {
+	if err := frame.validate(); err != nil {
+		return err
+	}
+
+	return writeMessageWithFrame(w, message, frameWriteConfig{
+		SecurityProviderMask: frame.SecurityProvider,
+		FrameHeaderCRC:       frame.HeaderCRC,
+		FrameBodyCRC:         frame.BodyCRC,
+		Flags:                frame.Flags,
+	})
+}
+
+// ReadFrame reads next framed message from r with its frame, crc values are checked when not 0
+// frames with unknown security provider or flags are rejected, an encrypted body is returned as is
+func ReadFrame(r io.Reader) (Frame, *ByteArrayMessage, error) {
+	frameheader, framebody, err := nextFrame(r, frameReadConfig{CheckPresentCRC: true})
+	if err != nil {
+		return Frame{}, nil, err
+	}
+
+	if int(frameheader.HeaderLength) > len(framebody) {
+		return Frame{}, nil, fmt.Errorf("bad frame header length %v", frameheader.HeaderLength)
+	}
+
+	headers, err := parseFabricMessageHeaders(bytes.NewBuffer(framebody[:frameheader.HeaderLength]))
+	if err != nil {
+		return Frame{}, nil, err
+	}
+
+	return frameheader.frame(), &ByteArrayMessage{
+		Headers: *headers,
+		Body:    framebody[frameheader.HeaderLength:],
+	}, nil
+}
+
 type messageFactory struct {
 	messagePrefix serialization.GUID
 	messageIdx    uint32
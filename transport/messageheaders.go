@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"sort"
 
 	"github.com/tg123/phabrik/serialization"
 )
@@ -74,6 +75,17 @@ func RegisterHeaderActivator(typ MessageHeaderIdType, activator HeaderTypeActiva
 	headerTypeActivators[typ] = activator
 }
 
+// CustomHeaderIds returns ids of all custom headers in ascending order
+func (h *MessageHeaders) CustomHeaderIds() []MessageHeaderIdType {
+	ids := make([]MessageHeaderIdType, 0, len(h.customHeaders))
+	for id := range h.customHeaders {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
 func (h *MessageHeaders) GetCustomHeaders(typ MessageHeaderIdType) []interface{} {
 	return h.customHeaders[typ]
 }
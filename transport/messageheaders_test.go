@@ -6,17 +6,13 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tg123/phabrik/common"
 	"github.com/tg123/phabrik/serialization"
 )
 
 func TestMessageHeadersSerialization(t *testing.T) {
 	var buf bytes.Buffer
 
-	type timeoutHeader struct {
-		Timeout time.Duration
-	}
-	RegisterHeaderActivator(MessageHeaderIdTypeTimeout, func() interface{} { return &timeoutHeader{} })
-
 	var h MessageHeaders
 	h.Action = "AC"
 	h.Actor = MessageActorTypeGenericTestActor2
@@ -28,8 +24,8 @@ func TestMessageHeadersSerialization(t *testing.T) {
 	h.Idempotent = true
 	h.RelatesTo = MessageId{serialization.MustNewGuidV4(), 200}
 	h.RetryCount = 4567
-	h.SetCustomHeader(MessageHeaderIdTypeTimeout, &timeoutHeader{
-		Timeout: 20 * time.Second,
+	h.SetCustomHeader(MessageHeaderIdTypeTimeout, &TimeoutHeader{
+		Timeout: common.TimeSpanFromDuration(20 * time.Second),
 	})
 
 	err := h.writeTo(&buf)
@@ -48,7 +44,7 @@ func TestMessageHeadersSerialization(t *testing.T) {
 	{
 		th, ok := h2.GetFirstCustomHeader(MessageHeaderIdTypeTimeout)
 		assert.True(t, ok)
-		assert.Equal(t, &timeoutHeader{Timeout: 20 * time.Second}, th)
+		assert.Equal(t, &TimeoutHeader{Timeout: common.TimeSpanFromDuration(20 * time.Second)}, th)
 	}
 
 	{
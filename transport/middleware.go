@@ -0,0 +1,53 @@
+package transport
+
+import (
+	"log"
+)
+
+// Middleware wraps a server message callback for cross-cutting concerns, e.g. auth, logging or metrics
+type Middleware func(next MessageCallback) MessageCallback
+
+// chain wraps cb with middleware, the first middleware is the outermost and sees the message first
+func chain(cb MessageCallback, middleware []Middleware) MessageCallback {
+	if cb == nil {
+		return nil
+	}
+
+	for i := len(middleware) - 1; i >= 0; i-- {
+		cb = middleware[i](cb)
+	}
+
+	return cb
+}
+
+// Recovery turns a panic of the handler into a reply with FabricErrorCodeOperationFailed
+// if the message expects one, the connection is left open
+func Recovery() Middleware {
+	return func(next MessageCallback) MessageCallback {
+		return func(c Conn, bam *ByteArrayMessage) {
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+
+				log.Printf("handler of action [%v] panic: %v", bam.Headers.Action, r)
+
+				if !bam.Headers.ExpectsReply {
+					return
+				}
+
+				fault := &Message{}
+				fault.Headers.Actor = bam.Headers.Actor
+				fault.Headers.RelatesTo = bam.Headers.Id
+				fault.Headers.ErrorCode = FabricErrorCodeOperationFailed
+
+				if err := c.SendOneWay(fault); err != nil {
+					log.Printf("sending fault reply error %v", err)
+				}
+			}()
+
+			next(c, bam)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerMiddleware(t *testing.T) {
+	var lock sync.Mutex
+	var calls []string
+
+	record := func(name string) Middleware {
+		return func(next MessageCallback) MessageCallback {
+			return func(c Conn, bam *ByteArrayMessage) {
+				lock.Lock()
+				calls = append(calls, name+" before")
+				lock.Unlock()
+
+				defer func() {
+					lock.Lock()
+					calls = append(calls, name+" after")
+					lock.Unlock()
+				}()
+
+				next(c, bam)
+			}
+		}
+	}
+
+	server, err := ListenTCP("127.0.0.1:0", ServerConfig{
+		Middleware: []Middleware{record("outer"), Recovery(), record("inner")},
+		MessageCallback: func(c Conn, bam *ByteArrayMessage) {
+			if bam.Headers.Action == "PANIC" {
+				panic("handler failure")
+			}
+
+			msg := &Message{}
+			msg.Headers.RelatesTo = bam.Headers.Id
+			msg.Headers.Action = "REPLY"
+			if err := c.SendOneWay(msg); err != nil {
+				t.Error(err)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go server.Serve()
+
+	client, err := DialTCP(server.Addr().String(), ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	go client.Wait()
+
+	request := func(action string) *ByteArrayMessage {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		msg := &Message{}
+		msg.Headers.Action = action
+		reply, err := client.RequestReply(ctx, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return reply
+	}
+
+	takeCalls := func() []string {
+		// the reply is sent before the middleware returns
+		assert.Eventually(t, func() bool {
+			lock.Lock()
+			defer lock.Unlock()
+			return len(calls) > 0 && calls[len(calls)-1] == "outer after"
+		}, time.Second, time.Millisecond)
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		r := calls
+		calls = nil
+		return r
+	}
+
+	t.Run("order", func(t *testing.T) {
+		reply := request("OK")
+		assert.Equal(t, "REPLY", reply.Headers.Action)
+		assert.Equal(t, []string{"outer before", "inner before", "inner after", "outer after"}, takeCalls())
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		reply := request("PANIC")
+		assert.Equal(t, FabricErrorCodeOperationFailed, reply.Headers.ErrorCode)
+
+		// deferred inner after runs while unwinding, Recovery stops the panic before outer
+		assert.Equal(t, []string{"outer before", "inner before", "inner after", "outer after"}, takeCalls())
+	})
+
+	t.Run("alive after panic", func(t *testing.T) {
+		reply := request("OK")
+		assert.Equal(t, "REPLY", reply.Headers.Action)
+		assert.Equal(t, FabricErrorCodeSuccess, reply.Headers.ErrorCode)
+		takeCalls()
+	})
+}
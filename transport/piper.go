@@ -3,6 +3,7 @@ package transport
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"log"
 	"net"
 )
@@ -54,7 +55,7 @@ func (p *Piper) handle(conn net.Conn) error {
 		return err
 	}
 
-	u, err := tapClientConn(rawu, uc)
+	u, err := tapClientConn(rawu, uc, nil)
 	if err != nil {
 		return err
 	}
@@ -150,3 +151,45 @@ func (p *Piper) copy(src, dst *connection) error {
 		}
 	}
 }
+
+// ForwardFrames reads frames from src and writes each one byte for byte to the writer route returns for it, or drops it if nil,
+// only message headers are parsed, e.g. for routing by actor or action, the body is passed through untouched.
+// headers is nil for frames that are not plaintext, i.e. with a security provider, e.g. ssl, or an encrypted body.
+// A frame split across reads is reassembled and written whole with a single write, which blocks reading the next frame
+// until dst accepts it. It returns nil when src ends between frames.
+func ForwardFrames(src io.Reader, route func(frame Frame, headers *MessageHeaders) io.Writer) error {
+	for {
+		frameheader, framebody, err := nextFrame(src, frameReadConfig{CheckPresentCRC: true})
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if int(frameheader.HeaderLength) > len(framebody) {
+			return fmt.Errorf("bad frame header length %v", frameheader.HeaderLength)
+		}
+
+		frame := frameheader.frame()
+
+		// headers of ssl frames and payload encrypted frames are not read, route decides by frame alone
+		var headers *MessageHeaders
+		if frame.SecurityProvider == SecurityProviderNone && frame.Flags&FrameFlagEncryptedBody == 0 {
+			headers, err = parseFabricMessageHeaders(bytes.NewBuffer(framebody[:frameheader.HeaderLength]))
+			if err != nil {
+				return err
+			}
+		}
+
+		dst := route(frame, headers)
+		if dst == nil {
+			continue
+		}
+
+		if err := writeRawFrame(dst, frameheader, framebody); err != nil {
+			return err
+		}
+	}
+}
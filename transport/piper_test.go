@@ -0,0 +1,143 @@
+package transport
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForwardFrames(t *testing.T) {
+	// client <-> proxy front, proxy back <-> server
+	client, front, err := netPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	defer front.Close()
+
+	back, server, err := netPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer back.Close()
+	defer server.Close()
+
+	frame := Frame{SecurityProvider: SecurityProviderNone, HeaderCRC: true, BodyCRC: true}
+
+	var actions []string
+	route := func(to net.Conn) func(Frame, *MessageHeaders) io.Writer {
+		return func(f Frame, headers *MessageHeaders) io.Writer {
+			assert.Equal(t, frame, f)
+			actions = append(actions, headers.Action)
+
+			if headers.Action == "Drop" {
+				return nil
+			}
+
+			return to
+		}
+	}
+
+	upstream := make(chan error, 1)
+	go func() { upstream <- ForwardFrames(front, route(back)) }()
+	downstream := make(chan error, 1)
+	go func() { downstream <- ForwardFrames(back, route(front)) }()
+
+	drop := &Message{}
+	drop.Headers.Action = "Drop"
+
+	var request bytes.Buffer
+	if err := WriteFrame(&request, frame, drop); err != nil {
+		t.Fatal(err)
+	}
+	dropped := request.Len()
+
+	msg := &Message{}
+	msg.Headers.Action = "Request"
+	msg.Body = bytes.Repeat([]byte{1, 2, 3}, 1000)
+	if err := WriteFrame(&request, frame, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// fragmented writes are reassembled into whole frames
+	for data := request.Bytes(); len(data) > 0; {
+		n := 7
+		if n > len(data) {
+			n = len(data)
+		}
+
+		if _, err := client.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+
+		data = data[n:]
+	}
+
+	// request arrives byte for byte without the dropped frame
+	forwarded := make([]byte, request.Len()-dropped)
+	if _, err := io.ReadFull(server, forwarded); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, request.Bytes()[dropped:], forwarded)
+
+	_, bam, err := ReadFrame(bytes.NewReader(forwarded))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply := &Message{}
+	reply.Headers.Action = "Reply"
+	reply.Headers.RelatesTo = bam.Headers.Id
+	reply.Body = []byte{4, 5, 6}
+	if err := WriteFrame(server, frame, reply); err != nil {
+		t.Fatal(err)
+	}
+
+	f, bam, err := ReadFrame(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, frame, f)
+	assert.Equal(t, "Reply", bam.Headers.Action)
+	assert.Equal(t, msg.Headers.Id, bam.Headers.RelatesTo)
+	assert.Equal(t, []byte{4, 5, 6}, bam.Body)
+
+	// clean close between frames ends forwarding without error
+	client.Close()
+	assert.NoError(t, <-upstream)
+	server.Close()
+	assert.NoError(t, <-downstream)
+
+	assert.Equal(t, []string{"Drop", "Request", "Reply"}, actions)
+
+	t.Run("not plaintext", func(t *testing.T) {
+		frames := []Frame{
+			{SecurityProvider: SecurityProviderSsl},
+			{SecurityProvider: SecurityProviderNone, Flags: FrameFlagEncryptedBody},
+		}
+
+		// e.g. the start of a tls record, which fails to parse as message headers
+		var src bytes.Buffer
+		for _, f := range frames {
+			if err := writeFrame(&src, 3, []byte{0x16, 0x03, 0x01, 1, 2, 3}, frameWriteConfig{SecurityProviderMask: f.SecurityProvider, Flags: f.Flags}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		data := src.Bytes()
+		var dst bytes.Buffer
+		var routed []Frame
+		assert.NoError(t, ForwardFrames(bytes.NewReader(data), func(f Frame, headers *MessageHeaders) io.Writer {
+			assert.Nil(t, headers)
+			routed = append(routed, f)
+			return &dst
+		}))
+
+		assert.Equal(t, frames, routed)
+		assert.Equal(t, data, dst.Bytes())
+	})
+}
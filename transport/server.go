@@ -14,6 +14,9 @@ type Server struct {
 type ServerConfig struct {
 	Config
 	MessageCallback MessageCallback
+
+	// Middleware wraps MessageCallback, applied in order with the first one the outermost
+	Middleware []Middleware
 }
 
 func ListenTCP(addr string, config ServerConfig) (*Server, error) {
@@ -22,13 +25,23 @@ func ListenTCP(addr string, config ServerConfig) (*Server, error) {
 		return nil, err
 	}
 
-	return Listen(l, config)
+	s, err := Listen(l, config)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return s, nil
 }
 
 func Listen(l net.Listener, config ServerConfig) (*Server, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
 	return &Server{
 		listener:        l,
-		messageCallback: config.MessageCallback,
+		messageCallback: chain(config.MessageCallback, config.Middleware),
 		config:          config,
 	}, nil
 }
@@ -56,8 +69,9 @@ func (s *Server) handle(conn net.Conn) error {
 	return c.Wait()
 }
 
+// SetMessageCallback replaces the callback, it is wrapped by the configured middleware
 func (s *Server) SetMessageCallback(cb MessageCallback) {
-	s.messageCallback = cb
+	s.messageCallback = chain(cb, s.config.Middleware)
 }
 
 func (s *Server) Serve() error {
@@ -5,6 +5,9 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -71,8 +74,7 @@ func TestBasicServer(t *testing.T) {
 	})
 }
 
-func TestTlsServer(t *testing.T) {
-
+func testCertificate(t *testing.T) tls.Certificate {
 	certPem := []byte(`-----BEGIN CERTIFICATE-----
 MIIBhTCCASugAwIBAgIQIRi6zePL6mKjOipn+dNuaTAKBggqhkjOPQQDAjASMRAw
 DgYDVQQKEwdBY21lIENvMB4XDTE3MTAyMDE5NDMwNloXDTE4MTAyMDE5NDMwNlow
@@ -94,6 +96,12 @@ EKTcWGekdmdDPsHloRNtsiCa697B2O9IFA==
 		t.Fatal(err)
 	}
 
+	return cert
+}
+
+func TestTlsServer(t *testing.T) {
+	cert := testCertificate(t)
+
 	serverCertCallback := false
 	clientCertCallback := false
 
@@ -174,3 +182,122 @@ EKTcWGekdmdDPsHloRNtsiCa697B2O9IFA==
 		assert.True(t, clientCertCallback)
 	}
 }
+
+func TestCustomHandshake(t *testing.T) {
+	server, err := ListenTCP("127.0.0.1:0", ServerConfig{
+		Config: Config{
+			Handshake: func(conn net.Conn) error {
+				hello := make([]byte, 5)
+				if _, err := io.ReadFull(conn, hello); err != nil {
+					return err
+				}
+
+				if string(hello) != "HELLO" {
+					return fmt.Errorf("unexpected hello %v", string(hello))
+				}
+
+				_, err := conn.Write([]byte("OK"))
+				return err
+			},
+		},
+		MessageCallback: func(c Conn, bam *ByteArrayMessage) {
+			msg := &Message{}
+			msg.Headers.RelatesTo = bam.Headers.Id
+			msg.Body = []byte(hex.EncodeToString(bam.Body))
+
+			err := c.SendOneWay(msg)
+			if err != nil {
+				t.Error(err)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer server.Close()
+	go server.Serve()
+
+	handshakeCalled := false
+
+	client, err := DialTCP(server.Addr().String(), ClientConfig{
+		Config: Config{
+			Handshake: func(conn net.Conn) error {
+				handshakeCalled = true
+
+				if _, err := conn.Write([]byte("HELLO")); err != nil {
+					return err
+				}
+
+				ok := make([]byte, 2)
+				if _, err := io.ReadFull(conn, ok); err != nil {
+					return err
+				}
+
+				if string(ok) != "OK" {
+					return fmt.Errorf("unexpected reply %v", string(ok))
+				}
+
+				return nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	go client.Wait()
+
+	assert.True(t, handshakeCalled)
+
+	reply, err := client.RequestReply(context.TODO(), &Message{
+		Body: []byte{1, 2, 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, hex.EncodeToString([]byte{1, 2, 3}), string(reply.Body))
+
+	t.Run("buffered bytes", func(t *testing.T) {
+		p1, p2 := net.Pipe()
+		defer p1.Close()
+		defer p2.Close()
+
+		go p2.Write([]byte("LO"))
+
+		var hello []byte
+		c, err := tapAcceptedConn(p1, Config{
+			Handshake: func(conn net.Conn) error {
+				hello = make([]byte, 5)
+				_, err := io.ReadFull(conn, hello)
+				return err
+			},
+		}, []byte("HEL"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// read ahead of the rest of the connection by handshake
+		assert.Equal(t, "HELLO", string(hello))
+
+		go p2.Write([]byte("!"))
+		b := make([]byte, 1)
+		_, err = io.ReadFull(c.conn, b)
+		assert.NoError(t, err)
+		assert.Equal(t, "!", string(b))
+	})
+
+	t.Run("handshake failure", func(t *testing.T) {
+		_, err := DialTCP(server.Addr().String(), ClientConfig{
+			Config: Config{
+				Handshake: func(conn net.Conn) error {
+					return fmt.Errorf("refused")
+				},
+			},
+		})
+
+		assert.Error(t, err)
+	})
+}
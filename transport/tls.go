@@ -22,7 +22,7 @@ func createTlsConn(conn net.Conn, mf *messageFactory, tlsconf *tls.Config, facto
 		rawconn: conn,
 		mf:      mf,
 	}
-	rawtls.frameWCfg.SecurityProviderMask = securityProviderSsl
+	rawtls.frameWCfg.SecurityProviderMask = SecurityProviderSsl
 	if initbuf != nil {
 		rawtls.rbuf.Write(initbuf)
 	}
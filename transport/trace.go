@@ -0,0 +1,82 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+type DialPhase int
+
+const (
+	DialPhaseDNS DialPhase = iota
+	DialPhaseConnect
+	DialPhaseTLS
+	DialPhaseNegotiation
+)
+
+func (p DialPhase) String() string {
+	switch p {
+	case DialPhaseDNS:
+		return "dns"
+	case DialPhaseConnect:
+		return "connect"
+	case DialPhaseTLS:
+		return "tls"
+	case DialPhaseNegotiation:
+		return "negotiation"
+	default:
+		return fmt.Sprintf("DialPhase(%d)", int(p))
+	}
+}
+
+// DialTraceFunc is called when a phase of connection establishment completes, in the order of DialPhase
+// DNS is only reported when the default dialer resolves a host name, TLS only when TLS is configured
+type DialTraceFunc func(phase DialPhase, elapsed time.Duration)
+
+func (f DialTraceFunc) done(phase DialPhase, start time.Time) {
+	if f != nil {
+		f(phase, time.Since(start))
+	}
+}
+
+// tracedDial resolves the host separately to time DNS, then dials the resolved addresses in turn
+func tracedDial(ctx context.Context, d *net.Dialer, trace DialTraceFunc, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := []string{host}
+
+	if net.ParseIP(host) == nil {
+		start := time.Now()
+
+		resolver := d.Resolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+
+		addrs, err = resolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		trace.done(DialPhaseDNS, start)
+	}
+
+	start := time.Now()
+	err = fmt.Errorf("no address found for %v", host)
+
+	for _, a := range addrs {
+		var conn net.Conn
+		conn, err = d.DialContext(ctx, network, net.JoinHostPort(a, port))
+		if err == nil {
+			trace.done(DialPhaseConnect, start)
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
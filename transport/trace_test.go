@@ -0,0 +1,87 @@
+package transport
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type tracedPhase struct {
+	phase   DialPhase
+	elapsed time.Duration
+}
+
+func TestDialTrace(t *testing.T) {
+	cert := testCertificate(t)
+
+	dialAndTrace := func(t *testing.T, addr string, config Config) []tracedPhase {
+		var phases []tracedPhase
+
+		c, err := DialTCP(addr, ClientConfig{
+			Config: config,
+			DialTrace: func(phase DialPhase, elapsed time.Duration) {
+				phases = append(phases, tracedPhase{phase, elapsed})
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+
+		for _, p := range phases {
+			assert.GreaterOrEqual(t, int64(p.elapsed), int64(0), p.phase.String())
+			assert.Less(t, int64(p.elapsed), int64(5*time.Second), p.phase.String())
+		}
+
+		return phases
+	}
+
+	order := func(phases []tracedPhase) []DialPhase {
+		var r []DialPhase
+		for _, p := range phases {
+			r = append(r, p.phase)
+		}
+		return r
+	}
+
+	t.Run("tls with host name", func(t *testing.T) {
+		server, err := ListenTCP("127.0.0.1:0", ServerConfig{
+			Config: Config{
+				TLS: &tls.Config{
+					Certificates: []tls.Certificate{cert},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		go server.Serve()
+
+		_, port, _ := net.SplitHostPort(server.Addr().String())
+
+		phases := dialAndTrace(t, net.JoinHostPort("localhost", port), Config{
+			TLS: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		})
+
+		assert.Equal(t, []DialPhase{DialPhaseDNS, DialPhaseConnect, DialPhaseTLS, DialPhaseNegotiation}, order(phases))
+	})
+
+	t.Run("plain with ip", func(t *testing.T) {
+		server, err := ListenTCP("127.0.0.1:0", ServerConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		go server.Serve()
+
+		phases := dialAndTrace(t, server.Addr().String(), Config{})
+
+		assert.Equal(t, []DialPhase{DialPhaseConnect, DialPhaseNegotiation}, order(phases))
+	})
+}